@@ -1,5 +1,12 @@
 package main
 
+import (
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
 type MailAppInfo struct {
 	ApplicationName string `json:"application_name"`
 	SystemName      string `json:"system_name"`
@@ -7,99 +14,422 @@ type MailAppInfo struct {
 	Recommend       string `json:"recommend"`
 }
 
-type CpuLimitWeekData struct {
+// GroupWeekAvg 部署组某项指标的周平均值，container和pod各一个
+// Container、Pod为分位数和峰值，数据源不支持时为nil
+type GroupWeekAvg struct {
 	GroupName    string  `json:"group_name"`
 	ContainerAvg float64 `json:"container_avg"`
 	PodAvg       float64 `json:"pod_avg"`
+	Container    *Stats  `json:"container_stats,omitempty"`
+	Pod          *Stats  `json:"pod_stats,omitempty"`
 }
 
-type MemLimitWeekData struct {
-	GroupName    string  `json:"group_name"`
-	ContainerAvg float64 `json:"container_avg"`
-	PodAvg       float64 `json:"pod_avg"`
+// 规则和报告可以使用的统计量
+const (
+	StatAvg = "avg"
+	StatP50 = "p50"
+	StatP95 = "p95"
+	StatP99 = "p99"
+	StatMax = "max"
+)
+
+// Stats 一组数据的分位数和最大值，单位与对应的平均值一致
+type Stats struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
 }
 
-type CpuCoreWeekData struct {
-	GroupName    string  `json:"group_name"`
-	ContainerAvg float64 `json:"container_avg"`
-	PodAvg       float64 `json:"pod_avg"`
+// computeStats 计算分位数(线性插值)和最大值，values为空时返回nil
+func computeStats(values []float64) *Stats {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	quantile := func(q float64) float64 {
+		pos := q * float64(len(sorted)-1)
+		lower := int(math.Floor(pos))
+		if lower+1 >= len(sorted) {
+			return sorted[lower]
+		}
+		return sorted[lower] + (sorted[lower+1]-sorted[lower])*(pos-float64(lower))
+	}
+	return &Stats{P50: quantile(0.5), P95: quantile(0.95), P99: quantile(0.99), Max: sorted[len(sorted)-1]}
 }
 
-type MemAvgWeekData struct {
-	GroupName    string  `json:"group_name"`
-	ContainerAvg float64 `json:"container_avg"`
-	PodAvg       float64 `json:"pod_avg"`
+// scaled 按比例换算并四舍五入，与平均值的口径一致
+func (s *Stats) scaled(scale float64, digits int) *Stats {
+	if s == nil {
+		return nil
+	}
+	return &Stats{
+		P50: roundTo(s.P50*scale, digits),
+		P95: roundTo(s.P95*scale, digits),
+		P99: roundTo(s.P99*scale, digits),
+		Max: roundTo(s.Max*scale, digits),
+	}
+}
+
+// value 按统计量名称取值
+func (s Stats) value(stat string) (float64, bool) {
+	switch stat {
+	case StatP50:
+		return s.P50, true
+	case StatP95:
+		return s.P95, true
+	case StatP99:
+		return s.P99, true
+	case StatMax:
+		return s.Max, true
+	}
+	return 0, false
+}
+
+// 各项指标的周平均值，保留原有名称以兼容旧代码
+type (
+	CpuLimitWeekData = GroupWeekAvg
+	MemLimitWeekData = GroupWeekAvg
+	CpuCoreWeekData  = GroupWeekAvg
+	MemAvgWeekData   = GroupWeekAvg
+)
+
+// Category 部署组的分类
+type Category string
+
+const (
+	CategoryOver   Category = "over"   // 超过阈值，建议扩容评估
+	CategoryBelow  Category = "below"  // 低于阈值，建议缩容评估
+	CategoryNormal Category = "normal" // 未命中任何规则
+)
+
+// UsageRecord 报告中的一行：部署组的应用信息、一周的平均资源使用情况及分类
+// Cpu/Mem Avg为相对limit的使用率(百分比)，CoreAvg、MemValue为使用量
+type UsageRecord struct {
+	ApplicationName   string           `json:"application_name"`
+	SystemName        string           `json:"system_name"`
+	GroupName         string           `json:"group_name"`
+	PodId             string           `json:"pod_id,omitempty"` // 仅pod明细行有值
+	ContainerCpuAvg   float64          `json:"container_cpu_avg"`
+	PodCpuAvg         float64          `json:"pod_cpu_avg"`
+	ContainerMemAvg   float64          `json:"container_mem_avg"`
+	PodMemAvg         float64          `json:"pod_mem_avg"`
+	ContainerCoreAvg  float64          `json:"container_core_avg"`
+	PodCoreAvg        float64          `json:"pod_core_avg"`
+	ContainerMemValue float64          `json:"container_mem_value"`
+	PodMemValue       float64          `json:"pod_mem_value"`
+	Recommend         string           `json:"recommend"`
+	Category          Category         `json:"category,omitempty"`
+	Pods              []UsageRecord    `json:"pods,omitempty"`           // 可选的pod明细
+	Trend             *Trend           `json:"trend,omitempty"`          // 与之前几周的对比，未对比时为nil
+	Cost              *CostEstimate    `json:"cost,omitempty"`           // 费用估算，未配置单价时为nil
+	Recommendation    *Recommendation  `json:"recommendation,omitempty"` // 结构化的调整建议，Recommend为其文字描述
+	Stats             map[string]Stats `json:"stats,omitempty"`          // 分位数和峰值，key为指标/范围，如cpu_limit/pod
+}
+
+// Label 分类的中文名称
+func (c Category) Label() string {
+	switch c {
+	case CategoryOver:
+		return "超过阈值"
+	case CategoryBelow:
+		return "低于阈值"
+	case CategoryNormal:
+		return "正常"
+	}
+	return string(c)
+}
+
+// Trend 部署组与之前几周的对比
+// 变化量为container使用率的百分点差值，上周没有数据时PreviousCategory为空
+type Trend struct {
+	PreviousCategory Category `json:"previous_category,omitempty"`
+	CpuDelta         float64  `json:"cpu_delta"`
+	MemDelta         float64  `json:"mem_delta"`
+	Streak           int      `json:"streak"` // 连续处于当前分类的周数，包含本周
+}
+
+// HasPrevious 上周是否有数据
+func (t *Trend) HasPrevious() bool {
+	return t != nil && t.PreviousCategory != ""
+}
+
+// CpuDeltaText CPU使用率变化的展示文本，如+3.5，无数据时为-
+func (t *Trend) CpuDeltaText() string {
+	if !t.HasPrevious() {
+		return "-"
+	}
+	return formatDelta(t.CpuDelta)
+}
+
+// MemDeltaText 内存使用率变化的展示文本
+func (t *Trend) MemDeltaText() string {
+	if !t.HasPrevious() {
+		return "-"
+	}
+	return formatDelta(t.MemDelta)
+}
+
+func formatDelta(v float64) string {
+	text := strconv.FormatFloat(v, 'f', 1, 64)
+	if v > 0 {
+		text = "+" + text
+	}
+	return text
+}
+
+// 报告行的旧名称，均为UsageRecord
+type (
+	OverWeekData       = UsageRecord
+	BelowWeekData      = UsageRecord
+	OverWeekExcelData  = UsageRecord
+	BelowWeekExcelData = UsageRecord
+)
+
+// statsKey Stats的key
+func statsKey(metric, scope string) string {
+	return metric + "/" + scope
+}
+
+// Value 按指标、范围和统计量取值，stat为空或avg时取平均值，数据源没有分位数时返回false
+func (r UsageRecord) Value(metric, scope, stat string) (float64, bool) {
+	if stat == "" || stat == StatAvg {
+		return r.Metric(metric, scope)
+	}
+	stats, ok := r.Stats[statsKey(metric, scope)]
+	if !ok {
+		return 0, false
+	}
+	return stats.value(stat)
+}
+
+// PeakText container的P95和峰值，如72.1 / 95.0，没有分位数时为-
+func (r UsageRecord) PeakText(metric string) string {
+	stats, ok := r.Stats[statsKey(metric, ScopeContainer)]
+	if !ok {
+		return "-"
+	}
+	return strconv.FormatFloat(stats.P95, 'f', -1, 64) + " / " + strconv.FormatFloat(stats.Max, 'f', -1, 64)
+}
+
+// Metric 按指标和范围取平均值
+func (r UsageRecord) Metric(metric, scope string) (float64, bool) {
+	values := map[string][2]float64{
+		MetricCpuLimit: {r.PodCpuAvg, r.ContainerCpuAvg},
+		MetricMemLimit: {r.PodMemAvg, r.ContainerMemAvg},
+		MetricCpuCore:  {r.PodCoreAvg, r.ContainerCoreAvg},
+		MetricMemValue: {r.PodMemValue, r.ContainerMemValue},
+	}
+	v, ok := values[metric]
+	if !ok {
+		return 0, false
+	}
+	switch scope {
+	case ScopePod:
+		return v[0], true
+	case ScopeContainer:
+		return v[1], true
+	}
+	return 0, false
+}
+
+// Section 报告中同一分类的部署组
+type Section struct {
+	Category Category
+	Rule     string // 判定规则描述
+	Records  []UsageRecord
+}
+
+// Report 一份报告的全部数据，HTML正文、Excel附件等均由它渲染
+type Report struct {
+	StartTime string
+	EndTime   string
+	Sections  []Section
+	Images    []ReportImage
+
+	// 与之前TrendWeeks周的对比，为0时以下字段为空
+	TrendWeeks   int
+	NewlyFlagged []UsageRecord // 本周新进入超过或低于阈值的部署组，Category为本周分类
+	Resolved     []UsageRecord // 上周超过或低于阈值、本周恢复正常的部署组
+
+	Currency string // 费用估算的币种，未配置单价时为空
+}
+
+// 报告数据的旧名称，正文和Excel附件使用同一份数据
+type (
+	MailTotalDataInfo = Report
+	MailExcelDataInfo = Report
+)
+
+// Section 返回指定分类的段落，不存在时返回nil
+func (r *Report) Section(category Category) *Section {
+	for i := range r.Sections {
+		if r.Sections[i].Category == category {
+			return &r.Sections[i]
+		}
+	}
+	return nil
+}
+
+// Add 向指定分类追加记录，段落不存在时自动创建
+func (r *Report) Add(category Category, records ...UsageRecord) {
+	s := r.Section(category)
+	if s == nil {
+		r.Sections = append(r.Sections, Section{Category: category})
+		s = &r.Sections[len(r.Sections)-1]
+	}
+	for _, record := range records {
+		record.Category = category
+		s.Records = append(s.Records, record)
+	}
+}
+
+// Records 指定分类的全部记录
+func (r Report) Records(category Category) []UsageRecord {
+	if s := r.Section(category); s != nil {
+		return s.Records
+	}
+	return nil
+}
+
+// Rule 指定分类的判定规则描述
+func (r Report) Rule(category Category) string {
+	if s := r.Section(category); s != nil {
+		return s.Rule
+	}
+	return ""
+}
+
+// 以下方法供现有模板和旧代码使用
+
+// OverWeekData 超过阈值的部署组
+func (r Report) OverWeekData() []UsageRecord { return r.Records(CategoryOver) }
+
+// BelowWeekData 低于阈值的部署组
+func (r Report) BelowWeekData() []UsageRecord { return r.Records(CategoryBelow) }
+
+// OverWeekExcelData 同OverWeekData
+func (r Report) OverWeekExcelData() []UsageRecord { return r.Records(CategoryOver) }
+
+// BelowWeekExcelData 同BelowWeekData
+func (r Report) BelowWeekExcelData() []UsageRecord { return r.Records(CategoryBelow) }
+
+// OverRule 超过阈值的判定规则描述，为空时模板使用默认描述
+func (r Report) OverRule() string { return r.Rule(CategoryOver) }
+
+// BelowRule 低于阈值的判定规则描述
+func (r Report) BelowRule() string { return r.Rule(CategoryBelow) }
+
+// CostTotal 指定分类的费用合计
+func (r Report) CostTotal(category Category) CostEstimate {
+	var total CostEstimate
+	for _, record := range r.Records(category) {
+		if record.Cost != nil {
+			total.WeeklyWaste += record.Cost.WeeklyWaste
+			total.PotentialSavings += record.Cost.PotentialSavings
+		}
+	}
+	total.WeeklyWaste = roundTo(total.WeeklyWaste, 2)
+	total.PotentialSavings = roundTo(total.PotentialSavings, 2)
+	return total
+}
+
+// Filter 只保留满足keep的部署组，分类规则、趋势和费用等设置不变，图片为全局数据不保留
+func (r Report) Filter(keep func(UsageRecord) bool) Report {
+	filtered := Report{
+		StartTime:  r.StartTime,
+		EndTime:    r.EndTime,
+		TrendWeeks: r.TrendWeeks,
+		Currency:   r.Currency,
+	}
+	for _, s := range r.Sections {
+		section := Section{Category: s.Category, Rule: s.Rule}
+		section.Records = filterRecords(s.Records, keep)
+		filtered.Sections = append(filtered.Sections, section)
+	}
+	filtered.NewlyFlagged = filterRecords(r.NewlyFlagged, keep)
+	filtered.Resolved = filterRecords(r.Resolved, keep)
+	return filtered
+}
+
+func filterRecords(records []UsageRecord, keep func(UsageRecord) bool) []UsageRecord {
+	var result []UsageRecord
+	for _, record := range records {
+		if keep(record) {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// Truncate 每类最多保留limit个部署组
+func (r Report) Truncate(limit int) Report {
+	truncated := r
+	truncated.Sections = make([]Section, len(r.Sections))
+	for i, s := range r.Sections {
+		s.Records = limitRecords(s.Records, limit)
+		truncated.Sections[i] = s
+	}
+	truncated.NewlyFlagged = limitRecords(r.NewlyFlagged, limit)
+	truncated.Resolved = limitRecords(r.Resolved, limit)
+	return truncated
+}
+
+// Empty 报告中是否没有任何部署组
+func (r Report) Empty() bool {
+	for _, s := range r.Sections {
+		if len(s.Records) > 0 {
+			return false
+		}
+	}
+	return len(r.NewlyFlagged) == 0 && len(r.Resolved) == 0
+}
+
+// HasStats 报告中的部署组是否带有分位数
+func (r Report) HasStats() bool {
+	for _, s := range r.Sections {
+		for _, record := range s.Records {
+			if len(record.Stats) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TableColumns 正文中分类表格的列数，随分位数、趋势和费用列变化
+func (r Report) TableColumns() int {
+	columns := 12
+	if r.HasStats() {
+		columns += 2
+	}
+	if r.TrendWeeks > 0 {
+		columns += 3
+	}
+	if r.Currency != "" {
+		columns += 2
+	}
+	return columns
+}
+
+// CostLabelColumns 合计行中费用列之前的列数
+func (r Report) CostLabelColumns() int {
+	return r.TableColumns() - 3
+}
+
+// ReportImage 报告正文中内嵌的图片，模板中通过{{cid .CID}}引用
+type ReportImage struct {
+	CID   string
+	Title string
+	Path  string // 图片文件路径，Data为空时读取
+	Data  []byte
 }
 
-type MailTotalDataInfo struct {
-	StartTime     string
-	EndTime       string
-	OverWeekData  []OverWeekData
-	BelowWeekData []BelowWeekData
-}
-
-type OverWeekData struct {
-	ApplicationName   string  `json:"application_name"`
-	SystemName        string  `json:"system_name"`
-	GroupName         string  `json:"group_name"`
-	ContainerCpuAvg   float64 `json:"container_cpu_avg"`
-	PodCpuAvg         float64 `json:"pod_cpu_avg"`
-	ContainerMemAvg   float64 `json:"container_mem_avg"`
-	PodMemAvg         float64 `json:"pod_mem_avg"`
-	ContainerCoreAvg  float64 `json:"container_core_avg"`
-	PodCoreAvg        float64 `json:"pod_core_avg"`
-	ContainerMemValue float64 `json:"container_mem_value"`
-	PodMemValue       float64 `json:"pod_mem_value"`
-	Recommend         string  `json:"recommend"`
-}
-
-type BelowWeekData struct {
-	ApplicationName   string  `json:"application_name"`
-	SystemName        string  `json:"system_name"`
-	GroupName         string  `json:"group_name"`
-	ContainerCpuAvg   float64 `json:"container_cpu_avg"`
-	PodCpuAvg         float64 `json:"pod_cpu_avg"`
-	ContainerMemAvg   float64 `json:"container_mem_avg"`
-	PodMemAvg         float64 `json:"pod_mem_avg"`
-	ContainerCoreAvg  float64 `json:"container_core_avg"`
-	PodCoreAvg        float64 `json:"pod_core_avg"`
-	ContainerMemValue float64 `json:"container_mem_value"`
-	PodMemValue       float64 `json:"pod_mem_value"`
-	Recommend         string  `json:"recommend"`
-}
-
-type MailExcelDataInfo struct {
-	OverWeekExcelData  []OverWeekExcelData
-	BelowWeekExcelData []BelowWeekExcelData
-}
-
-type OverWeekExcelData struct {
-	ApplicationName   string  `json:"application_name"`
-	SystemName        string  `json:"system_name"`
-	GroupName         string  `json:"group_name"`
-	ContainerCpuAvg   float64 `json:"container_cpu_avg"`
-	PodCpuAvg         float64 `json:"pod_cpu_avg"`
-	ContainerMemAvg   float64 `json:"container_mem_avg"`
-	PodMemAvg         float64 `json:"pod_mem_avg"`
-	ContainerCoreAvg  float64 `json:"container_core_avg"`
-	PodCoreAvg        float64 `json:"pod_core_avg"`
-	ContainerMemValue float64 `json:"container_mem_value"`
-	PodMemValue       float64 `json:"pod_mem_value"`
-	Recommend         string  `json:"recommend"`
-}
-
-type BelowWeekExcelData struct {
-	ApplicationName   string  `json:"application_name"`
-	SystemName        string  `json:"system_name"`
-	GroupName         string  `json:"group_name"`
-	PodId             string  `json:"pod_id"`
-	ContainerCpuAvg   float64 `json:"container_cpu_avg"`
-	PodCpuAvg         float64 `json:"pod_cpu_avg"`
-	ContainerMemAvg   float64 `json:"container_mem_avg"`
-	PodMemAvg         float64 `json:"pod_mem_avg"`
-	ContainerCoreAvg  float64 `json:"container_core_avg"`
-	PodCoreAvg        float64 `json:"pod_core_avg"`
-	ContainerMemValue float64 `json:"container_mem_value"`
-	PodMemValue       float64 `json:"pod_mem_value"`
-	Recommend         string  `json:"recommend"`
+// FileName 内嵌图片的文件名
+func (i ReportImage) FileName() string {
+	if i.Path != "" {
+		return filepath.Base(i.Path)
+	}
+	return i.CID
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// SASL认证机制
+const (
+	AuthPlain   = "PLAIN"
+	AuthLogin   = "LOGIN"
+	AuthCramMD5 = "CRAM-MD5"
+	AuthXOAuth2 = "XOAUTH2"
+)
+
+// authPreference 自动协商时的机制强度排序，靠前的优先
+var authPreference = []string{AuthXOAuth2, AuthCramMD5, AuthPlain, AuthLogin}
+
+// TokenSource XOAUTH2访问令牌来源，每次认证前调用一次
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc 将函数适配为TokenSource
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticTokenSource 固定的访问令牌
+type StaticTokenSource string
+
+func (s StaticTokenSource) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// FileTokenSource 每次从文件读取访问令牌，适合由外部进程定期刷新令牌文件的场景
+type FileTokenSource string
+
+func (f FileTokenSource) Token(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return "", fmt.Errorf("读取访问令牌失败: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// negotiateAuth 根据服务器EHLO返回的AUTH能力选择双方都支持的最强机制
+func (c *MailServerConfig) negotiateAuth(ctx context.Context, serverMechanisms string) (smtp.Auth, error) {
+	offered := map[string]bool{}
+	for _, mech := range strings.Fields(serverMechanisms) {
+		offered[strings.ToUpper(mech)] = true
+	}
+
+	candidates := authPreference
+	if len(c.AuthMechanisms) > 0 {
+		candidates = c.AuthMechanisms
+	}
+	for _, mech := range candidates {
+		mech = strings.ToUpper(strings.TrimSpace(mech))
+		if !offered[mech] {
+			continue
+		}
+		switch mech {
+		case AuthXOAuth2:
+			if c.TokenSource == nil {
+				continue
+			}
+			token, err := c.TokenSource.Token(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("获取XOAUTH2令牌失败: %w", err)
+			}
+			return &xoauth2Auth{username: c.User, token: token}, nil
+		case AuthCramMD5:
+			if c.Password == "" {
+				continue
+			}
+			return smtp.CRAMMD5Auth(c.User, c.Password), nil
+		case AuthPlain:
+			return &plainAuth{username: c.User, password: c.Password}, nil
+		case AuthLogin:
+			return LoginAuth(c.User, c.Password), nil
+		default:
+			return nil, fmt.Errorf("不支持的认证机制: %s", mech)
+		}
+	}
+	return nil, fmt.Errorf("没有双方都支持的认证机制，服务器支持: %s", serverMechanisms)
+}
+
+// plainAuth PLAIN机制(RFC 4616)
+// 与smtp.PlainAuth不同，是否允许明文连接由SMTPTransport统一判断
+type plainAuth struct {
+	username, password string
+}
+
+func (a *plainAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return AuthPlain, []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a *plainAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, fmt.Errorf("PLAIN认证收到意外的服务器质询: %s", fromServer)
+	}
+	return nil, nil
+}
+
+// loginAuth LOGIN机制
+// 服务器提示语并不统一("Username:"、"User Name"等)，无法识别时按用户名、密码的顺序应答
+type loginAuth struct {
+	username, password string
+	step               int
+}
+
+func LoginAuth(username, password string) smtp.Auth {
+	return &loginAuth{username: username, password: password}
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	a.step = 0
+	return AuthLogin, nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(strings.Trim(string(fromServer), " \t\r\n\x00:"))
+	step := a.step
+	a.step++
+	switch {
+	case strings.Contains(prompt, "user"):
+		return []byte(a.username), nil
+	case strings.Contains(prompt, "pass"):
+		return []byte(a.password), nil
+	case step == 0:
+		return []byte(a.username), nil
+	case step == 1:
+		return []byte(a.password), nil
+	default:
+		// We've already sent everything.
+		return nil, fmt.Errorf("unexpected server challenge: %s", prompt)
+	}
+}
+
+// xoauth2Auth XOAUTH2机制，用于Office365、Gmail等使用OAuth2令牌的服务器
+type xoauth2Auth struct {
+	username, token string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return AuthXOAuth2, []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// 认证失败时服务器返回JSON格式的错误详情，按协议回复空行后服务器会给出最终错误码
+		return []byte{}, nil
+	}
+	return nil, nil
+}
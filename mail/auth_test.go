@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateAuth(t *testing.T) {
+	token := StaticTokenSource("access-token")
+	tests := []struct {
+		name    string
+		config  MailServerConfig
+		offered string
+		want    string // 选中的机制，为空时期望失败
+	}{
+		{"strongest first", MailServerConfig{Password: "pw", TokenSource: token}, "LOGIN PLAIN CRAM-MD5 XOAUTH2", AuthXOAuth2},
+		{"xoauth2 needs a token", MailServerConfig{Password: "pw"}, "LOGIN PLAIN CRAM-MD5 XOAUTH2", AuthCramMD5},
+		{"cram-md5 needs a password", MailServerConfig{}, "LOGIN CRAM-MD5 PLAIN", AuthPlain},
+		{"plain before login", MailServerConfig{Password: "pw"}, "LOGIN PLAIN", AuthPlain},
+		{"login only", MailServerConfig{Password: "pw"}, "LOGIN", AuthLogin},
+		{"case insensitive", MailServerConfig{Password: "pw"}, "login", AuthLogin},
+		{"configured order wins", MailServerConfig{Password: "pw", AuthMechanisms: []string{"login", "PLAIN"}}, "PLAIN LOGIN", AuthLogin},
+		{"configured but not offered", MailServerConfig{Password: "pw", AuthMechanisms: []string{"CRAM-MD5", "PLAIN"}}, "LOGIN PLAIN", AuthPlain},
+		{"nothing configured is offered", MailServerConfig{Password: "pw", AuthMechanisms: []string{"CRAM-MD5"}}, "PLAIN LOGIN", ""},
+		{"unknown server mechanisms", MailServerConfig{Password: "pw"}, "GSSAPI NTLM", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.User = "rpa@example.com"
+			auth, err := tt.config.negotiateAuth(context.Background(), tt.offered)
+			if tt.want == "" {
+				if err == nil || !strings.Contains(err.Error(), tt.offered) {
+					t.Fatalf("negotiateAuth() = %T, %v; want an error naming the offered mechanisms", auth, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			mech, _, err := auth.Start(&smtp.ServerInfo{Name: "localhost", TLS: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mech != tt.want {
+				t.Errorf("negotiated %s, want %s", mech, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateAuthTokenError(t *testing.T) {
+	config := MailServerConfig{User: "rpa@example.com", TokenSource: TokenSourceFunc(func(ctx context.Context) (string, error) {
+		return "", errors.New("token expired")
+	})}
+	if _, err := config.negotiateAuth(context.Background(), "XOAUTH2 PLAIN"); err == nil || !strings.Contains(err.Error(), "token expired") {
+		t.Errorf("negotiateAuth() = %v, want the token error", err)
+	}
+}
+
+func TestLoginAuthPrompts(t *testing.T) {
+	tests := []struct {
+		name    string
+		prompts []string
+		want    []string
+	}{
+		{"standard", []string{"Username:", "Password:"}, []string{"rpa@example.com", "pw"}},
+		{"user name", []string{"User Name", "Password"}, []string{"rpa@example.com", "pw"}},
+		{"lower case with padding", []string{" username: \r\n", "password:\x00"}, []string{"rpa@example.com", "pw"}},
+		{"password asked first", []string{"Password:", "Username:"}, []string{"pw", "rpa@example.com"}},
+		{"unknown prompts", []string{"Enter value", "Enter another value"}, []string{"rpa@example.com", "pw"}},
+		{"empty prompts", []string{"", ""}, []string{"rpa@example.com", "pw"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := LoginAuth("rpa@example.com", "pw")
+			mech, initial, err := auth.Start(&smtp.ServerInfo{Name: "localhost", TLS: true})
+			if err != nil || mech != AuthLogin || initial != nil {
+				t.Fatalf("Start() = %q, %q, %v", mech, initial, err)
+			}
+			for i, prompt := range tt.prompts {
+				got, err := auth.Next([]byte(prompt), true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != tt.want[i] {
+					t.Errorf("Next(%q) = %q, want %q", prompt, got, tt.want[i])
+				}
+			}
+			if got, err := auth.Next(nil, false); got != nil || err != nil {
+				t.Errorf("Next() after success = %q, %v", got, err)
+			}
+		})
+	}
+
+	// 用户名和密码都已发送后，无法识别的质询视为错误
+	auth := LoginAuth("rpa@example.com", "pw")
+	auth.Start(&smtp.ServerInfo{})
+	auth.Next([]byte("Enter value"), true)
+	auth.Next([]byte("Enter value"), true)
+	if _, err := auth.Next([]byte("Enter value"), true); err == nil {
+		t.Error("Next() accepted a third unknown challenge")
+	}
+}
+
+func TestXOAuth2Auth(t *testing.T) {
+	config := MailServerConfig{User: "rpa@example.com", TokenSource: StaticTokenSource("access-token")}
+	auth, err := config.negotiateAuth(context.Background(), "XOAUTH2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mech, initial, err := auth.Start(&smtp.ServerInfo{Name: "localhost", TLS: true})
+	if err != nil || mech != AuthXOAuth2 {
+		t.Fatalf("Start() = %q, %v", mech, err)
+	}
+	if want := "user=rpa@example.com\x01auth=Bearer access-token\x01\x01"; string(initial) != want {
+		t.Errorf("initial response = %q, want %q", initial, want)
+	}
+	// 令牌无效时服务器以334返回JSON错误详情，客户端应答空行后服务器返回最终错误码
+	challenge, _ := base64.StdEncoding.DecodeString("eyJzdGF0dXMiOiI0MDEiLCJzY2hlbWVzIjoiYmVhcmVyIiwic2NvcGUiOiJodHRwczovL21haWwuZ29vZ2xlLmNvbS8ifQ==")
+	got, err := auth.Next(challenge, true)
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("Next(error challenge) = %q, %v; want an empty response", got, err)
+	}
+	if got, err := auth.Next(nil, false); got != nil || err != nil {
+		t.Errorf("Next() after success = %q, %v", got, err)
+	}
+}
+
+func TestPlainAuthRejectsChallenge(t *testing.T) {
+	auth := &plainAuth{username: "rpa@example.com", password: "pw"}
+	mech, initial, err := auth.Start(&smtp.ServerInfo{Name: "localhost"})
+	if err != nil || mech != AuthPlain || string(initial) != "\x00rpa@example.com\x00pw" {
+		t.Errorf("Start() = %q, %q, %v", mech, initial, err)
+	}
+	if _, err := auth.Next([]byte("unexpected"), true); err == nil {
+		t.Error("Next() accepted a server challenge")
+	}
+}
+
+func TestSMTPTransportLoginAuth(t *testing.T) {
+	// 通过本地服务器走完整的LOGIN认证，提示语为"User Name"和"Password"
+	cert := newTestCert(t)
+	server := smtpTestServer{StartTLS: true, AuthMechs: "LOGIN", AuthPrompt: []string{"User Name", "Password"}}
+	server.start(t, cert)
+	if err := NewSMTPTransport(server.mailServer(cert, TLSModeStartTLS)).Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	session := server.Sessions()[0]
+	want := []string{"AUTH LOGIN", base64.StdEncoding.EncodeToString([]byte("rpa@example.com")), base64.StdEncoding.EncodeToString([]byte("s3cret"))}
+	if strings.Join(session.Auth, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Auth = %q, want %q", session.Auth, want)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+// 命令行退出码
+const (
+	exitOK      = 0
+	exitFailure = 1 // 执行失败
+	exitUsage   = 2 // 命令或参数错误
+)
+
+const cliUsage = `用法: finops <命令> [参数]
+
+命令:
+  report render [--out report.html]   生成报告正文HTML，默认输出到标准输出
+  report excel  [--out report.xlsx]   生成Excel附件，默认使用附件文件名
+  report eml    [--out msg.eml]       生成完整的邮件报文，默认输出到标准输出
+  report send   [--dry-run]           发送报告，--dry-run时写出邮件、正文和附件而不投递
+  schedule      [--dry-run]           常驻运行，按schedule.cron定时发送每日报告
+  smtp test     [--to 收件人]         检查SMTP连接和认证，指定--to时发送一封测试邮件
+  secrets seal  --keystore 文件       将JSON中的敏感信息加密写入密钥库，已有的密钥库会合并
+
+通用参数:
+  --config 配置文件路径，默认依次使用FINOPS_CONFIG和config/finops.yaml
+
+预览参数(report send、schedule):
+  --dry-run  按真实发送的流程构建邮件，写入预览目录并输出摘要，不投递也不记录history
+  --out-dir  预览目录，默认为dry_run.dir
+
+report参数:
+  --report       报告名称，默认daily
+  --date         按该日期计算报告窗口(前7天至前1天)，格式2006-01-02，默认今天
+  --start --end  直接指定报告窗口，需同时指定
+  --to --cc      覆盖收件人，多个地址用逗号分隔；覆盖收件人时不发送负责人报告
+  render、excel和eml只在本地生成，不需要配置smtp.host和SMTP密码
+
+使用"finops <命令> -h"查看各命令的参数
+`
+
+// usageError 命令或参数错误，退出码为exitUsage
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// listFlag 逗号分隔、可重复指定的参数
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// runCLI 执行命令行，返回退出码
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	err := dispatch(args, stdout, stderr)
+	var usage *usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usage):
+		fmt.Fprintln(stderr, Redact(err.Error()))
+		fmt.Fprint(stderr, "\n"+cliUsage)
+		return exitUsage
+	default:
+		fmt.Fprintln(stderr, Redact(err.Error()))
+		return exitFailure
+	}
+}
+
+func dispatch(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return usageErrorf("缺少命令")
+	}
+	switch args[0] {
+	case "-h", "--help", "help":
+		fmt.Fprint(stdout, cliUsage)
+		return nil
+	case "schedule":
+		return runSchedule(args[1:], stderr)
+	case "report", "smtp", "secrets":
+		if len(args) < 2 {
+			return usageErrorf("%s缺少子命令", args[0])
+		}
+	default:
+		return usageErrorf("未知的命令%q", args[0])
+	}
+	command, sub, rest := args[0], args[1], args[2:]
+	switch command + " " + sub {
+	case "report render", "report excel", "report eml", "report send":
+		return runReport(sub, rest, stdout, stderr)
+	case "smtp test":
+		return runSMTPTest(rest, stdout, stderr)
+	case "secrets seal":
+		return runSecretsSeal(rest, stdout, stderr)
+	}
+	return usageErrorf("未知的命令%q", command+" "+sub)
+}
+
+// newFlagSet 创建子命令参数，所有子命令都支持--config
+func newFlagSet(name string, stderr io.Writer) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "", "配置文件路径，默认依次使用FINOPS_CONFIG和config/finops.yaml")
+	return fs, configPath
+}
+
+// parseFlags 解析参数，参数错误转换为usageError，-h原样返回
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{msg: err.Error()}
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("多余的参数: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
+
+// reportFlags report子命令共用的参数
+type reportFlags struct {
+	name, date, start, end string
+	to, cc                 listFlag
+}
+
+func (f *reportFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.name, "report", DailyReport, "报告名称")
+	fs.StringVar(&f.date, "date", "", "按该日期计算报告窗口(前7天至前1天)，格式2006-01-02，默认今天")
+	fs.StringVar(&f.start, "start", "", "报告窗口开始日期，格式2006-01-02，需与--end同时指定")
+	fs.StringVar(&f.end, "end", "", "报告窗口结束日期，格式2006-01-02，需与--start同时指定")
+	fs.Var(&f.to, "to", "覆盖收件人，多个地址用逗号分隔")
+	fs.Var(&f.cc, "cc", "覆盖抄送人，多个地址用逗号分隔")
+}
+
+// window 按参数计算报告窗口，日期按本机时区解析
+func (f *reportFlags) window(now time.Time) (ReportWindow, error) {
+	parse := func(name, value string) (time.Time, error) {
+		t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return time.Time{}, usageErrorf("--%s应为2006-01-02格式的日期: %s", name, value)
+		}
+		return t, nil
+	}
+	if f.start != "" || f.end != "" {
+		if f.start == "" || f.end == "" || f.date != "" {
+			return ReportWindow{}, usageErrorf("--start和--end需同时指定，且不能与--date同时使用")
+		}
+		start, err := parse("start", f.start)
+		if err != nil {
+			return ReportWindow{}, err
+		}
+		end, err := parse("end", f.end)
+		if err != nil {
+			return ReportWindow{}, err
+		}
+		if end.Before(start) {
+			return ReportWindow{}, usageErrorf("--end不能早于--start")
+		}
+		return ReportWindow{Start: start, End: end}, nil
+	}
+	if f.date != "" {
+		date, err := parse("date", f.date)
+		if err != nil {
+			return ReportWindow{}, err
+		}
+		now = date
+	}
+	return DefaultReportWindow(now), nil
+}
+
+// checkRecipients 校验参数中的收件人
+func (f *reportFlags) checkRecipients() error {
+	for name, list := range map[string]listFlag{"to": f.to, "cc": f.cc} {
+		for _, addr := range list {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				return usageErrorf("--%s不是有效的邮箱地址: %s", name, addr)
+			}
+		}
+	}
+	return nil
+}
+
+// overrideRecipients 用参数中的收件人替换配置，替换后不再发送负责人报告
+func (f *reportFlags) overrideRecipients(report *PreparedReport) {
+	if len(f.to) == 0 && len(f.cc) == 0 {
+		return
+	}
+	report.Config.To, report.Config.Cc, report.Config.Bcc = f.to, f.cc, nil
+	report.Routing = false
+}
+
+func runReport(sub string, args []string, stdout, stderr io.Writer) error {
+	fs, configPath := newFlagSet("report "+sub, stderr)
+	var rf reportFlags
+	rf.register(fs)
+	var out string
+	var preview dryRunFlags
+	if sub != "send" {
+		fs.StringVar(&out, "out", "", "输出文件路径，-为标准输出")
+	} else {
+		preview.register(fs)
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	window, err := rf.window(time.Now())
+	if err != nil {
+		return err
+	}
+	if err := rf.checkRecipients(); err != nil {
+		return err
+	}
+	// render、excel和eml只在本地生成报告，不需要邮件服务器
+	offline := func(cfg *Config) { cfg.Offline = sub != "send" }
+	if err := InitConfig(*configPath, preview.apply, offline); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	report, err := PrepareReport(ctx, rf.name, window)
+	if err != nil {
+		return err
+	}
+	rf.overrideRecipients(report)
+	switch sub {
+	case "render":
+		return writeOutput(out, []byte(report.HTML), stdout)
+	case "excel":
+		if out == "" {
+			out = report.FileName
+		}
+		return writeOutput(out, report.Attachment, stdout)
+	case "eml":
+		_, data, err := reportMessage(report)
+		if err != nil {
+			return err
+		}
+		return writeOutput(out, data, stdout)
+	}
+
+	transport, err := configuredReportTransport()
+	if err != nil {
+		return err
+	}
+	return report.Send(ctx, transport)
+}
+
+// dryRunFlags 预览参数，在校验前覆盖配置中的dry_run
+type dryRunFlags struct {
+	enabled bool
+	dir     string
+}
+
+func (f *dryRunFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.enabled, "dry-run", false, "按真实发送的流程构建邮件，写入预览目录并输出摘要，不投递")
+	fs.StringVar(&f.dir, "out-dir", "", "预览目录，默认为dry_run.dir")
+}
+
+func (f *dryRunFlags) apply(cfg *Config) {
+	if f.enabled {
+		cfg.DryRun.Enabled = true
+	}
+	if f.dir != "" {
+		cfg.DryRun.Dir = f.dir
+	}
+}
+
+// reportMessage 按配置的发件人构建报告邮件，返回邮件和完整报文，不需要SMTP凭据
+func reportMessage(report *PreparedReport) (*Message, []byte, error) {
+	msg, err := report.Message(GlobalConfig.SMTP.sender())
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("构建邮件失败: %w", err)
+	}
+	return msg, data, nil
+}
+
+// writeOutput 写入文件，path为空或-时写入标准输出
+func writeOutput(path string, data []byte, stdout io.Writer) error {
+	if path == "" || path == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("写入%s失败: %w", path, err)
+	}
+	return nil
+}
+
+func runSchedule(args []string, stderr io.Writer) error {
+	fs, configPath := newFlagSet("schedule", stderr)
+	var preview dryRunFlags
+	preview.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := InitConfig(*configPath, preview.apply); err != nil {
+		return err
+	}
+	transport, err := configuredReportTransport()
+	if err != nil {
+		return err
+	}
+	return RunDailyScheduler(transport)
+}
+
+func runSMTPTest(args []string, stdout, stderr io.Writer) error {
+	fs, configPath := newFlagSet("smtp test", stderr)
+	var to listFlag
+	fs.Var(&to, "to", "发送测试邮件的收件人，多个地址用逗号分隔；不指定时只检查连接和认证")
+	timeout := fs.Duration("timeout", 30*time.Second, "超时时间")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return usageErrorf("--to不是有效的邮箱地址: %s", addr)
+		}
+	}
+	if err := InitConfig(*configPath); err != nil {
+		return err
+	}
+	mailServer, err := configuredMailServer()
+	if err != nil {
+		return fmt.Errorf("读取邮件服务器配置失败: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	transport := NewSMTPTransport(mailServer)
+	if len(to) == 0 {
+		if err := transport.Check(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "SMTP连接和认证正常: %s:%d\n", mailServer.SMTPServer, mailServer.SMTPPort)
+		return nil
+	}
+	msg := &Message{
+		From:    mail.Address{Name: mailServer.Alias, Address: mailServer.User},
+		To:      to,
+		Subject: "FinOps报告SMTP测试",
+		Text:    fmt.Sprintf("这是一封测试邮件，由%s:%d发送。\n", mailServer.SMTPServer, mailServer.SMTPPort),
+	}
+	if err := transport.Send(ctx, msg); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "测试邮件已发送: %s\n", strings.Join(to, ", "))
+	return nil
+}
+
+// runSecretsSeal 读取JSON对象形式的敏感信息(路径到值)，用主密钥加密写入密钥库
+// 不加载配置文件：配置中的secret://引用可能正依赖这个密钥库
+func runSecretsSeal(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("secrets seal", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	keystore := fs.String("keystore", "", "密钥库文件，已存在时用同一主密钥解密后合并")
+	in := fs.String("in", "-", "JSON文件，如{\"smtp/password\": \"...\"}，-为标准输入")
+	masterKeyEnv := fs.String("master-key-env", "FINOPS_MASTER_KEY", "保存主密钥的环境变量")
+	masterKeyFile := fs.String("master-key-file", "", "保存主密钥的文件，优先于--master-key-env")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *keystore == "" {
+		return usageErrorf("缺少--keystore")
+	}
+	masterKey := []byte(os.Getenv(*masterKeyEnv))
+	if *masterKeyFile != "" {
+		data, err := os.ReadFile(*masterKeyFile)
+		if err != nil {
+			return fmt.Errorf("读取主密钥失败: %w", err)
+		}
+		masterKey = []byte(strings.TrimSpace(string(data)))
+	}
+	if len(masterKey) == 0 {
+		return fmt.Errorf("未提供主密钥，请设置环境变量%s或指定--master-key-file", *masterKeyEnv)
+	}
+
+	var data []byte
+	var err error
+	if *in == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		return fmt.Errorf("读取敏感信息失败: %w", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("敏感信息应为JSON对象，键为路径、值为字符串: %w", err)
+	}
+	for _, value := range values {
+		RegisterSecret(value)
+	}
+
+	secrets := map[string]string{}
+	if _, err := os.Stat(*keystore); err == nil {
+		if secrets, err = OpenKeystore(*keystore, masterKey); err != nil {
+			return err
+		}
+	}
+	for path, value := range values {
+		secrets[path] = value
+	}
+	if err := SealKeystore(*keystore, masterKey, secrets); err != nil {
+		return fmt.Errorf("写入密钥库失败: %w", err)
+	}
+	fmt.Fprintf(stdout, "已写入%d项，密钥库%s共%d项\n", len(values), *keystore, len(secrets))
+	return nil
+}
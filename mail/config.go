@@ -0,0 +1,725 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix 环境变量覆盖配置时使用的前缀，如FINOPS_SMTP_HOST覆盖smtp.host
+const envPrefix = "FINOPS_"
+
+// defaultConfigPath 未指定配置文件时的默认路径，可通过FINOPS_CONFIG修改
+const defaultConfigPath = "config/finops.yaml"
+
+// GlobalConfig InitConfig加载后的全局配置
+var GlobalConfig *Config
+
+// Config 报告程序配置
+type Config struct {
+	SMTP        SMTPConfig              `json:"smtp"`
+	Reports     map[string]ReportConfig `json:"reports"`
+	Templates   TemplateConfig          `json:"templates"`
+	Thresholds  ThresholdConfig         `json:"thresholds"`
+	Rules       RulesConfig             `json:"rules"`
+	Database    DatabaseConfig          `json:"database"`
+	Metrics     MetricsConfig           `json:"metrics"`
+	Secrets     SecretsConfig           `json:"secrets"`
+	History     HistoryConfig           `json:"history"`
+	Pricing     PricingConfig           `json:"pricing"`
+	Recommender RecommenderConfig       `json:"recommender"`
+	Routing     RoutingConfig           `json:"routing"`
+	Schedule    ScheduleConfig          `json:"schedule"`
+	DryRun      DryRunConfig            `json:"dry_run"`
+	Offline     bool                    `json:"-"` // 只在本地生成报告(report render/excel/eml)，由命令行设置，不要求SMTP配置
+}
+
+// SMTPConfig 邮件服务器配置，密码建议通过password_env或password_file提供
+type SMTPConfig struct {
+	Host               string      `json:"host"`
+	Port               int         `json:"port"`
+	User               string      `json:"user"`
+	Password           string      `json:"password"`
+	PasswordEnv        string      `json:"password_env"`
+	PasswordFile       string      `json:"password_file"`
+	Alias              string      `json:"alias"`
+	TLSMode            string      `json:"tls_mode"`
+	TLSServerName      string      `json:"tls_server_name"`
+	CAFile             string      `json:"ca_file"`
+	ClientCertFile     string      `json:"client_cert_file"`
+	ClientKeyFile      string      `json:"client_key_file"`
+	PinnedCertSHA256   []string    `json:"pinned_cert_sha256"`
+	InsecureSkipVerify bool        `json:"insecure_skip_verify"`
+	AllowInsecureAuth  bool        `json:"allow_insecure_auth"`
+	AuthMechanisms     []string    `json:"auth_mechanisms"`
+	TokenFile          string      `json:"token_file"`
+	Retry              RetryConfig `json:"retry"` // 临时失败时的重试
+}
+
+// ReportConfig 单个报告的收件人和主题
+type ReportConfig struct {
+	Subject     string   `json:"subject"`
+	To          []string `json:"to"`
+	Cc          []string `json:"cc"`
+	Bcc         []string `json:"bcc"`
+	Attachments []string `json:"attachments"` // 随报告发送的额外附件
+	PodDetails  bool     `json:"pod_details"` // 低于阈值的部署组附带pod明细
+	TrendWeeks  int      `json:"trend_weeks"` // 与之前几周对比，0为不对比
+
+	Images []ReportImageConfig `json:"images"` // 内嵌在正文末尾的图片
+}
+
+// ReportImageConfig 报告正文中内嵌的图片文件
+type ReportImageConfig struct {
+	Path  string `json:"path"`
+	CID   string `json:"cid"`   // 为空时使用文件名
+	Title string `json:"title"` // 图片上方的标题，为空时不显示
+}
+
+// ContentID 图片的Content-ID
+func (c ReportImageConfig) ContentID() string {
+	if c.CID != "" {
+		return c.CID
+	}
+	return filepath.Base(c.Path)
+}
+
+// HistoryConfig 已发送报告的本地记录
+type HistoryConfig struct {
+	Path string `json:"path"` // SQLite文件路径，为空时不记录
+}
+
+// TemplateConfig 报告模板路径
+type TemplateConfig struct {
+	HTML  string `json:"html"`
+	Excel string `json:"excel"`
+}
+
+// ThresholdConfig 资源使用率阈值(0~1)
+type ThresholdConfig struct {
+	CpuOverPod        float64 `json:"cpu_over_pod"`
+	CpuOverContainer  float64 `json:"cpu_over_container"`
+	CpuBelowPod       float64 `json:"cpu_below_pod"`
+	CpuBelowContainer float64 `json:"cpu_below_container"`
+	MemOverContainer  float64 `json:"mem_over_container"`
+}
+
+// DatabaseConfig 指标数据库连接配置
+// DSN中的{password}会被替换为Password，以便密码单独通过secret://引用提供
+type DatabaseConfig struct {
+	Driver   string `json:"driver"`
+	DSN      string `json:"dsn"`
+	Password string `json:"password"`
+}
+
+// ConnString 返回填入密码后的连接串
+func (c DatabaseConfig) ConnString() string {
+	return strings.ReplaceAll(c.DSN, "{password}", c.Password)
+}
+
+// MetricsConfig 报告数据来源
+type MetricsConfig struct {
+	Source     string           `json:"source"` // sql(默认，使用database配置)、prometheus或file
+	Prometheus PrometheusConfig `json:"prometheus"`
+	File       FileSourceConfig `json:"file"`
+}
+
+// FileSourceConfig 采样文件数据源配置
+type FileSourceConfig struct {
+	Paths []string `json:"paths"` // CSV或JSON lines文件，支持通配符
+}
+
+// PrometheusConfig Prometheus数据源配置
+type PrometheusConfig struct {
+	URL         string      `json:"url"`
+	GroupLabel  string      `json:"group_label"` // 部署组标签名
+	Step        string      `json:"step"`        // 范围查询步长，如1h
+	Timeout     string      `json:"timeout"`     // 单次查询超时
+	BearerToken string      `json:"bearer_token"`
+	Queries     PromQueries `json:"queries"` // 未配置的查询使用默认查询
+}
+
+// SecretsConfig secret://引用的解析方式
+type SecretsConfig struct {
+	Providers     []string `json:"providers"`       // 按顺序尝试的提供方：env、file、keystore
+	EnvPrefix     string   `json:"env_prefix"`      // env提供方的环境变量前缀
+	Dir           string   `json:"dir"`             // file提供方的挂载目录
+	Keystore      string   `json:"keystore"`        // keystore提供方的密钥库文件
+	MasterKeyEnv  string   `json:"master_key_env"`  // 保存密钥库主密钥的环境变量
+	MasterKeyFile string   `json:"master_key_file"` // 保存密钥库主密钥的文件，优先于master_key_env
+}
+
+// SecretProvider 根据配置构建敏感信息提供方
+func (c SecretsConfig) SecretProvider() (SecretProvider, error) {
+	var chain ChainSecretProvider
+	for _, name := range c.Providers {
+		switch name {
+		case "env":
+			chain = append(chain, &EnvSecretProvider{Prefix: c.EnvPrefix})
+		case "file":
+			chain = append(chain, &FileSecretProvider{Dir: c.Dir})
+		case "keystore":
+			masterKey := []byte(os.Getenv(c.MasterKeyEnv))
+			if c.MasterKeyFile != "" {
+				data, err := os.ReadFile(c.MasterKeyFile)
+				if err != nil {
+					return nil, &ConfigError{Key: "secrets.master_key_file", Msg: err.Error()}
+				}
+				masterKey = []byte(strings.TrimSpace(string(data)))
+			}
+			if len(masterKey) == 0 {
+				return nil, &ConfigError{Key: "secrets.master_key_env", Msg: fmt.Sprintf("环境变量%s未设置", c.MasterKeyEnv)}
+			}
+			chain = append(chain, &KeystoreSecretProvider{Path: c.Keystore, MasterKey: masterKey})
+		default:
+			return nil, &ConfigError{Key: "secrets.providers", Msg: fmt.Sprintf("不支持的提供方%q", name)}
+		}
+	}
+	return chain, nil
+}
+
+// DailyReport 每日报告在配置中的名称
+const DailyReport = "daily"
+
+// defaultConfig 配置文件中未出现的项使用的默认值
+func defaultConfig() *Config {
+	return &Config{
+		SMTP: SMTPConfig{
+			Port: 25,
+			Retry: RetryConfig{
+				MaxAttempts:    3,
+				InitialBackoff: "5s",
+				MaxBackoff:     "1m",
+				Deadline:       "5m",
+			},
+		},
+		Reports: map[string]ReportConfig{},
+		Templates: TemplateConfig{
+			HTML:  "template/finops_table_new.html",
+			Excel: "template/FinOps.xlsx",
+		},
+		Thresholds: ThresholdConfig{
+			CpuOverPod:        0.4,
+			CpuOverContainer:  0.6,
+			CpuBelowPod:       0.15,
+			CpuBelowContainer: 0.3,
+			MemOverContainer:  0.95,
+		},
+		Metrics: MetricsConfig{
+			Source: "sql",
+			Prometheus: PrometheusConfig{
+				GroupLabel: "group_name",
+				Step:       "1h",
+				Timeout:    "2m",
+			},
+		},
+		Pricing: PricingConfig{
+			Currency:          "CNY",
+			TargetUtilization: 0.6,
+		},
+		Recommender: RecommenderConfig{
+			Headroom:    1.3,
+			LimitFactor: 2,
+			Cpu:         ResourceBounds{Min: 0.05},
+			Memory:      ResourceBounds{Min: 64},
+		},
+		DryRun: DryRunConfig{
+			Dir: "data/dry-run",
+		},
+		Schedule: ScheduleConfig{
+			StateFile: "data/scheduler.state",
+			LockFile:  "data/scheduler.lock",
+		},
+		Secrets: SecretsConfig{
+			Providers:    []string{"env"},
+			EnvPrefix:    envPrefix + "SECRET_",
+			Dir:          "/run/secrets",
+			MasterKeyEnv: envPrefix + "MASTER_KEY",
+		},
+	}
+}
+
+// InitConfig 加载配置文件到GlobalConfig，path为空时依次使用FINOPS_CONFIG和默认路径
+// overrides在环境变量覆盖之后、校验之前执行，用于命令行参数覆盖配置
+func InitConfig(path string, overrides ...func(*Config)) error {
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if path == "" {
+		path = defaultConfigPath
+	}
+	// 尽早安装脱敏输出，保证之后所有日志都不会出现已登记的敏感信息
+	log.SetOutput(&RedactWriter{W: os.Stderr})
+	cfg, err := LoadConfig(path, overrides...)
+	if err != nil {
+		return err
+	}
+	GlobalConfig = cfg
+	return nil
+}
+
+// LoadConfig 读取YAML/JSON/TOML配置文件，应用环境变量和overrides覆盖并校验
+func LoadConfig(path string, overrides ...func(*Config)) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	// 三种格式统一转换为JSON后解码，字段名和报错信息只需维护一套
+	var raw map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("不支持的配置文件格式: %s", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析配置文件%s失败: %w", path, err)
+	}
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("解析配置文件%s失败: %w", path, err)
+	}
+
+	if err := checkUnknownKeys(raw, reflect.TypeOf(Config{}), ""); err != nil {
+		return nil, fmt.Errorf("配置文件%s有误: %w", path, err)
+	}
+	cfg := defaultConfig()
+	if err := json.Unmarshal(normalized, cfg); err != nil {
+		return nil, fmt.Errorf("配置文件%s有误: %w", path, describeDecodeError(err))
+	}
+
+	if err := applyEnvOverrides(reflect.ValueOf(cfg).Elem(), envPrefix); err != nil {
+		return nil, err
+	}
+	for _, override := range overrides {
+		override(cfg)
+	}
+	if err := cfg.resolveSecrets(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolveSecrets 解析配置中所有secret://引用(secrets段本身除外)
+func (c *Config) resolveSecrets(ctx context.Context) error {
+	provider, err := c.Secrets.SecretProvider()
+	if err != nil {
+		return err
+	}
+	secrets := c.Secrets
+	c.Secrets = SecretsConfig{}
+	defer func() { c.Secrets = secrets }()
+	if err := resolveSecretRefs(ctx, reflect.ValueOf(c).Elem(), provider, ""); err != nil {
+		return err
+	}
+	// 直接写在配置中的密码同样需要脱敏
+	RegisterSecret(c.SMTP.Password)
+	RegisterSecret(c.Database.Password)
+	RegisterSecret(c.Metrics.Prometheus.BearerToken)
+	return nil
+}
+
+// describeDecodeError 将解码错误转换为指向具体配置项的描述
+func describeDecodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &ConfigError{Key: typeErr.Field, Msg: fmt.Sprintf("类型应为%s，实际为%s", typeErr.Type, typeErr.Value)}
+	}
+	return err
+}
+
+// checkUnknownKeys 检查配置中拼写错误或不存在的配置项
+func checkUnknownKeys(raw interface{}, t reflect.Type, prefix string) error {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return checkUnknownKeys(raw, t.Elem(), prefix)
+	case reflect.Map:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for key, value := range m {
+			if err := checkUnknownKeys(value, t.Elem(), prefix+key+"."); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		items, ok := raw.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range items {
+			if err := checkUnknownKeys(item, t.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(prefix, "."), i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldType, ok := fields[key]
+			if !ok {
+				return &ConfigError{Key: prefix + key, Msg: "未知的配置项"}
+			}
+			if err := checkUnknownKeys(m[key], fieldType, prefix+key+"."); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyEnvOverrides 用环境变量覆盖配置项，变量名为前缀加上大写的配置路径，如FINOPS_SMTP_PORT
+// 切片使用逗号分隔；map只能覆盖配置文件中已存在的key，如FINOPS_REPORTS_DAILY_TO
+func applyEnvOverrides(v reflect.Value, prefix string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			if err := applyEnvOverrides(v.Field(i), prefix+strings.ToUpper(name)+"_"); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			if err := applyEnvOverrides(elem, prefix+strings.ToUpper(key.String())+"_"); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+		return nil
+	}
+
+	envName := strings.TrimSuffix(prefix, "_")
+	value, ok := os.LookupEnv(envName)
+	if !ok {
+		return nil
+	}
+	if err := setFromString(v, value); err != nil {
+		return &ConfigError{Key: envName, Msg: err.Error()}
+	}
+	return nil
+}
+
+func setFromString(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("应为整数: %s", value)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("应为数字: %s", value)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("应为true或false: %s", value)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("不支持通过环境变量设置")
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("不支持通过环境变量设置")
+	}
+	return nil
+}
+
+// ConfigError 指向具体配置项的错误
+type ConfigError struct {
+	Key string
+	Msg string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Msg)
+}
+
+// ConfigErrors 校验发现的全部错误
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return "配置校验失败:\n  " + strings.Join(msgs, "\n  ")
+}
+
+// Validate 校验配置，返回的错误中包含出错的配置项路径
+func (c *Config) Validate() error {
+	var errs ConfigErrors
+	add := func(key, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{Key: key, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	// 只生成报告或预览时不连接邮件服务器，服务器地址和密码未就绪时也允许运行
+	connects := !c.Offline && !c.DryRun.Enabled
+	if c.SMTP.Host == "" && connects {
+		add("smtp.host", "不能为空")
+	}
+	if c.SMTP.Port <= 0 || c.SMTP.Port > 65535 {
+		add("smtp.port", "端口必须在1~65535之间，当前为%d", c.SMTP.Port)
+	}
+	if c.SMTP.User != "" {
+		if _, err := mail.ParseAddress(c.SMTP.User); err != nil {
+			add("smtp.user", "不是有效的邮箱地址: %s", c.SMTP.User)
+		}
+	}
+	switch TLSMode(c.SMTP.TLSMode) {
+	case "", TLSModeNone, TLSModeStartTLS, TLSModeOpportunistic, TLSModeImplicit:
+	default:
+		add("smtp.tls_mode", "只能是none、starttls、opportunistic或tls，当前为%q", c.SMTP.TLSMode)
+	}
+	for i, pin := range c.SMTP.PinnedCertSHA256 {
+		if _, err := parseCertPin(pin); err != nil {
+			add(fmt.Sprintf("smtp.pinned_cert_sha256[%d]", i), "应为64位十六进制的SHA-256指纹: %s", pin)
+		}
+	}
+	if c.SMTP.InsecureSkipVerify && len(c.SMTP.PinnedCertSHA256) == 0 {
+		add("smtp.insecure_skip_verify", "跳过证书校验时必须配置pinned_cert_sha256")
+	}
+	for _, mech := range c.SMTP.AuthMechanisms {
+		switch strings.ToUpper(mech) {
+		case AuthPlain, AuthLogin, AuthCramMD5, AuthXOAuth2:
+		default:
+			add("smtp.auth_mechanisms", "不支持的认证机制%q", mech)
+		}
+	}
+	if c.SMTP.PasswordFile != "" && connects {
+		if _, err := os.Stat(c.SMTP.PasswordFile); err != nil {
+			add("smtp.password_file", "无法读取: %v", err)
+		}
+	}
+	if c.SMTP.PasswordEnv != "" && connects {
+		if _, ok := os.LookupEnv(c.SMTP.PasswordEnv); !ok {
+			add("smtp.password_env", "环境变量%s未设置", c.SMTP.PasswordEnv)
+		}
+	}
+
+	if c.Database.Driver != "" && c.Database.DSN == "" {
+		add("database.dsn", "指定了driver时不能为空")
+	}
+	switch c.Metrics.Source {
+	case "", "sql":
+	case "prometheus":
+		p := c.Metrics.Prometheus
+		if u, err := url.Parse(p.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			add("metrics.prometheus.url", "不是有效的地址: %q", p.URL)
+		}
+		if p.GroupLabel == "" {
+			add("metrics.prometheus.group_label", "不能为空")
+		}
+		for key, value := range map[string]string{"metrics.prometheus.step": p.Step, "metrics.prometheus.timeout": p.Timeout} {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				add(key, "不是有效的时长: %q", value)
+			}
+		}
+	case "file":
+		if len(c.Metrics.File.Paths) == 0 {
+			add("metrics.file.paths", "至少需要一个采样文件")
+		}
+		for i, pattern := range c.Metrics.File.Paths {
+			if _, err := filepath.Glob(pattern); err != nil {
+				add(fmt.Sprintf("metrics.file.paths[%d]", i), "路径格式错误: %v", err)
+			}
+		}
+	default:
+		add("metrics.source", "只能是sql、prometheus或file，当前为%q", c.Metrics.Source)
+	}
+
+	names := make([]string, 0, len(c.Reports))
+	for name := range c.Reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report := c.Reports[name]
+		key := "reports." + name
+		if len(report.To) == 0 {
+			add(key+".to", "至少需要一个收件人")
+		}
+		for field, list := range map[string][]string{"to": report.To, "cc": report.Cc, "bcc": report.Bcc} {
+			for i, addr := range list {
+				if _, err := mail.ParseAddress(addr); err != nil {
+					add(fmt.Sprintf("%s.%s[%d]", key, field, i), "不是有效的邮箱地址: %s", addr)
+				}
+			}
+		}
+		if report.TrendWeeks < 0 || report.TrendWeeks > 52 {
+			add(key+".trend_weeks", "应在0到52之间，当前为%d", report.TrendWeeks)
+		}
+		for i, file := range report.Attachments {
+			if _, err := os.Stat(file); err != nil {
+				add(fmt.Sprintf("%s.attachments[%d]", key, i), "无法读取: %v", err)
+			}
+		}
+		cids := map[string]bool{}
+		for i, image := range report.Images {
+			imageKey := fmt.Sprintf("%s.images[%d]", key, i)
+			if image.Path == "" {
+				add(imageKey+".path", "不能为空")
+				continue
+			}
+			if _, err := os.Stat(image.Path); err != nil {
+				add(imageKey+".path", "无法读取: %v", err)
+			}
+			cid := image.ContentID()
+			if strings.ContainsAny(cid, " \t\r\n<>\"") {
+				add(imageKey+".cid", "不能包含空白、尖括号或引号: %q", cid)
+			} else if cids[cid] {
+				add(imageKey+".cid", "与其他图片重复: %s", cid)
+			}
+			cids[cid] = true
+		}
+	}
+
+	for key, path := range map[string]string{"templates.html": c.Templates.HTML, "templates.excel": c.Templates.Excel} {
+		if path == "" {
+			add(key, "不能为空")
+		} else if _, err := os.Stat(path); err != nil {
+			add(key, "无法读取: %v", err)
+		}
+	}
+
+	t := c.Thresholds
+	for key, value := range map[string]float64{
+		"thresholds.cpu_over_pod":        t.CpuOverPod,
+		"thresholds.cpu_over_container":  t.CpuOverContainer,
+		"thresholds.cpu_below_pod":       t.CpuBelowPod,
+		"thresholds.cpu_below_container": t.CpuBelowContainer,
+		"thresholds.mem_over_container":  t.MemOverContainer,
+	} {
+		if value <= 0 || value > 1 {
+			add(key, "阈值必须在(0, 1]之间，当前为%v", value)
+		}
+	}
+	if t.CpuBelowPod >= t.CpuOverPod {
+		add("thresholds.cpu_below_pod", "应小于cpu_over_pod")
+	}
+	if t.CpuBelowContainer >= t.CpuOverContainer {
+		add("thresholds.cpu_below_container", "应小于cpu_over_container")
+	}
+
+	c.Rules.Over.validate("rules.over", add)
+	c.Rules.Below.validate("rules.below", add)
+	c.SMTP.Retry.validate(add)
+	c.Pricing.validate(add)
+	c.Recommender.validate(add)
+	c.Routing.validate(c.Database, add)
+	c.Schedule.validate(add)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Key < errs[j].Key })
+	return errs
+}
+
+// MailServer 解析密码等敏感项，返回邮件服务器配置
+// 密码优先级：password_file > password_env > password
+func (c *SMTPConfig) MailServer() (*MailServerConfig, error) {
+	server := c.sender()
+	password := c.Password
+	if c.PasswordEnv != "" {
+		password = os.Getenv(c.PasswordEnv)
+	}
+	if c.PasswordFile != "" {
+		data, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return nil, &ConfigError{Key: "smtp.password_file", Msg: err.Error()}
+		}
+		password = strings.TrimSpace(string(data))
+	}
+	RegisterSecret(password)
+	server.Password = password
+	if c.TokenFile != "" {
+		server.TokenSource = FileTokenSource(c.TokenFile)
+	}
+	return server, nil
+}
+
+// sender 不含密码和令牌的邮件服务器配置，用于只构建邮件而不连接服务器的场景，如预览
+func (c *SMTPConfig) sender() *MailServerConfig {
+	return &MailServerConfig{
+		SMTPServer:         c.Host,
+		SMTPPort:           c.Port,
+		User:               c.User,
+		Alias:              c.Alias,
+		TLSMode:            TLSMode(c.TLSMode),
+		TLSServerName:      c.TLSServerName,
+		CAFile:             c.CAFile,
+		ClientCertFile:     c.ClientCertFile,
+		ClientKeyFile:      c.ClientKeyFile,
+		PinnedCertSHA256:   c.PinnedCertSHA256,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		AllowInsecureAuth:  c.AllowInsecureAuth,
+		AuthMechanisms:     c.AuthMechanisms,
+	}
+}
+
+// Report 返回指定名称的报告配置
+func (c *Config) Report(name string) (ReportConfig, error) {
+	report, ok := c.Reports[name]
+	if !ok {
+		return ReportConfig{}, &ConfigError{Key: "reports." + name, Msg: "未配置"}
+	}
+	return report, nil
+}
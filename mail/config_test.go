@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile 在临时目录写入配置文件，name的扩展名决定格式
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// configErrorKeys 校验错误中的配置项路径
+func configErrorKeys(t *testing.T, err error) []string {
+	t.Helper()
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("err = %v, want ConfigErrors", err)
+	}
+	keys := make([]string, 0, len(errs))
+	for _, e := range errs {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	files := map[string]string{
+		"finops.yaml": `
+smtp:
+  host: smtp.example.com
+  port: 465
+  user: rpa@example.com
+  retry:
+    max_attempts: 5
+reports:
+  daily:
+    subject: FinOps周报
+    to: [ops@example.com, finops@example.com]
+templates:
+  html: ../template/finops_table_new.html
+  excel: ../template/FinOps.xlsx
+thresholds:
+  cpu_over_pod: 0.5
+`,
+		"finops.json": `{
+  "smtp": {"host": "smtp.example.com", "port": 465, "user": "rpa@example.com", "retry": {"max_attempts": 5}},
+  "reports": {"daily": {"subject": "FinOps周报", "to": ["ops@example.com", "finops@example.com"]}},
+  "templates": {"html": "../template/finops_table_new.html", "excel": "../template/FinOps.xlsx"},
+  "thresholds": {"cpu_over_pod": 0.5}
+}`,
+		"finops.toml": `
+[smtp]
+host = "smtp.example.com"
+port = 465
+user = "rpa@example.com"
+
+[smtp.retry]
+max_attempts = 5
+
+[reports.daily]
+subject = "FinOps周报"
+to = ["ops@example.com", "finops@example.com"]
+
+[templates]
+html = "../template/finops_table_new.html"
+excel = "../template/FinOps.xlsx"
+
+[thresholds]
+cpu_over_pod = 0.5
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfigFile(t, name, content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.SMTP.Host != "smtp.example.com" || cfg.SMTP.Port != 465 || cfg.SMTP.Retry.MaxAttempts != 5 {
+				t.Errorf("smtp = %+v", cfg.SMTP)
+			}
+			// 未配置的项保留默认值
+			if cfg.SMTP.Retry.InitialBackoff != "5s" || cfg.Thresholds.CpuOverContainer != 0.6 || cfg.Metrics.Source != "sql" {
+				t.Errorf("defaults were lost: retry %+v, thresholds %+v, metrics %q", cfg.SMTP.Retry, cfg.Thresholds, cfg.Metrics.Source)
+			}
+			if cfg.Thresholds.CpuOverPod != 0.5 {
+				t.Errorf("thresholds.cpu_over_pod = %v, want 0.5", cfg.Thresholds.CpuOverPod)
+			}
+			daily := cfg.Reports[DailyReport]
+			if daily.Subject != "FinOps周报" || !reflect.DeepEqual(daily.To, []string{"ops@example.com", "finops@example.com"}) {
+				t.Errorf("reports.daily = %+v", daily)
+			}
+		})
+	}
+
+	if _, err := LoadConfig(writeConfigFile(t, "finops.ini", "[smtp]")); err == nil || !strings.Contains(err.Error(), ".ini") {
+		t.Errorf("LoadConfig(.ini) = %v, want an unsupported format error", err)
+	}
+	if _, err := LoadConfig(writeConfigFile(t, "finops.yaml", "smtp: [")); err == nil || !strings.Contains(err.Error(), "解析配置文件") {
+		t.Errorf("LoadConfig(invalid yaml) = %v", err)
+	}
+}
+
+// minimalConfig 能通过校验的最小YAML配置
+const minimalConfig = `
+smtp:
+  host: smtp.example.com
+reports:
+  daily:
+    to: [ops@example.com]
+templates:
+  html: ../template/finops_table_new.html
+  excel: ../template/FinOps.xlsx
+`
+
+func TestLoadConfigUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name, content, key string
+	}{
+		{"top level", minimalConfig + "smpt: {}\n", "smpt"},
+		{"nested", strings.Replace(minimalConfig, "host:", "hots: x\n  host:", 1), "smtp.hots"},
+		{"inside a map", strings.Replace(minimalConfig, "to: [ops@example.com]", "to: [ops@example.com]\n    recipients: []", 1), "reports.daily.recipients"},
+		{"inside a list", minimalConfig + "routing:\n  owners:\n    - name: a\n      emails: [a@example.com]\n", "routing.owners[0].emails"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfigFile(t, "finops.yaml", tt.content))
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) || configErr.Key != tt.key || !strings.Contains(err.Error(), "未知的配置项") {
+				t.Errorf("LoadConfig() = %v, want an unknown key error for %s", err, tt.key)
+			}
+		})
+	}
+
+	_, err := LoadConfig(writeConfigFile(t, "finops.yaml", minimalConfig+"thresholds:\n  cpu_over_pod: high\n"))
+	if err == nil || !strings.Contains(err.Error(), "cpu_over_pod") {
+		t.Errorf("LoadConfig() with a wrong type = %v, want the key in the error", err)
+	}
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	path := writeConfigFile(t, "finops.yaml", minimalConfig)
+	t.Setenv("FINOPS_SMTP_HOST", "relay.example.com")
+	t.Setenv("FINOPS_SMTP_PORT", "2525")
+	t.Setenv("FINOPS_SMTP_INSECURE_SKIP_VERIFY", "false")
+	t.Setenv("FINOPS_REPORTS_DAILY_TO", "a@example.com, b@example.com,")
+	t.Setenv("FINOPS_THRESHOLDS_CPU_OVER_POD", "0.45")
+	// 配置文件中没有的map key不会被创建
+	t.Setenv("FINOPS_REPORTS_WEEKLY_TO", "c@example.com")
+
+	cfg, err := LoadConfig(path, func(cfg *Config) { cfg.SMTP.Alias = "FinOps" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SMTP.Host != "relay.example.com" || cfg.SMTP.Port != 2525 || cfg.SMTP.Alias != "FinOps" {
+		t.Errorf("smtp = %+v", cfg.SMTP)
+	}
+	if to := cfg.Reports[DailyReport].To; !reflect.DeepEqual(to, []string{"a@example.com", "b@example.com"}) {
+		t.Errorf("reports.daily.to = %v", to)
+	}
+	if cfg.Thresholds.CpuOverPod != 0.45 {
+		t.Errorf("thresholds.cpu_over_pod = %v", cfg.Thresholds.CpuOverPod)
+	}
+	if _, ok := cfg.Reports["weekly"]; ok {
+		t.Error("FINOPS_REPORTS_WEEKLY_TO created a report")
+	}
+
+	t.Setenv("FINOPS_SMTP_PORT", "smtp")
+	_, err = LoadConfig(path)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Key != "FINOPS_SMTP_PORT" {
+		t.Errorf("LoadConfig() with an invalid override = %v, want an error naming FINOPS_SMTP_PORT", err)
+	}
+	t.Setenv("FINOPS_SMTP_PORT", "70000")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "smtp.port") {
+		t.Errorf("LoadConfig() with an out of range override = %v, want a smtp.port error", err)
+	}
+}
+
+func TestValidateErrorKeys(t *testing.T) {
+	cfg := testConfig()
+	cfg.SMTP.Port = 0
+	cfg.SMTP.User = "not an address"
+	cfg.SMTP.TLSMode = "ssl"
+	cfg.SMTP.PasswordEnv = "FINOPS_TEST_UNSET_PASSWORD"
+	cfg.Reports[DailyReport] = ReportConfig{Cc: []string{"bad"}, TrendWeeks: 53}
+	cfg.Templates.Excel = filepath.Join(t.TempDir(), "missing.xlsx")
+	cfg.Thresholds.CpuBelowPod = 0.5
+	cfg.Metrics.Source = "influx"
+
+	want := []string{
+		"metrics.source",
+		"reports.daily.cc[0]",
+		"reports.daily.to",
+		"reports.daily.trend_weeks",
+		"smtp.password_env",
+		"smtp.port",
+		"smtp.tls_mode",
+		"smtp.user",
+		"templates.excel",
+		"thresholds.cpu_below_pod",
+	}
+	err := cfg.Validate()
+	if keys := configErrorKeys(t, err); !reflect.DeepEqual(keys, want) {
+		t.Errorf("Validate() keys = %v, want %v", keys, want)
+	}
+	for _, key := range want {
+		if !strings.Contains(err.Error(), key+": ") {
+			t.Errorf("error message does not name %s:\n%v", key, err)
+		}
+	}
+	if err := testConfig().Validate(); err != nil {
+		t.Errorf("Validate() of a valid config = %v", err)
+	}
+}
+
+func TestValidateSMTPHostOnlyWhenSending(t *testing.T) {
+	cfg := testConfig()
+	cfg.SMTP.Host = ""
+	cfg.SMTP.PasswordEnv = "FINOPS_TEST_UNSET_PASSWORD"
+	if keys := configErrorKeys(t, cfg.Validate()); !reflect.DeepEqual(keys, []string{"smtp.host", "smtp.password_env"}) {
+		t.Errorf("Validate() keys when sending = %v", keys)
+	}
+	cfg.Offline = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() offline = %v", err)
+	}
+	cfg.Offline, cfg.DryRun.Enabled = false, true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() in dry-run = %v", err)
+	}
+}
+
+func TestReportRenderWithoutSMTP(t *testing.T) {
+	// 没有smtp段时render和excel仍可生成报告，send报告缺少smtp.host
+	dir := t.TempDir()
+	config := writeConfigFile(t, "finops.yaml", `
+database:
+  driver: sqlite
+  dsn: `+writeManyGroupsFixture(t, 0)+`
+reports:
+  daily:
+    to: [ops@example.com]
+templates:
+  html: ../template/finops_table_new.html
+  excel: ../template/FinOps.xlsx
+`)
+	previous := GlobalConfig
+	t.Cleanup(func() { GlobalConfig = previous })
+	run := func(args ...string) (int, string) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		args = append(args, "--config", config, "--start", "2025-05-26", "--end", "2025-06-01")
+		return runCLI(args, &stdout, &stderr), stderr.String()
+	}
+
+	html := filepath.Join(dir, "report.html")
+	if code, stderr := run("report", "render", "--out", html); code != exitOK {
+		t.Fatalf("report render = %d: %s", code, stderr)
+	}
+	if data, err := os.ReadFile(html); err != nil || !strings.Contains(string(data), "claims-batch") {
+		t.Errorf("rendered report: %v", err)
+	}
+	if code, stderr := run("report", "excel", "--out", filepath.Join(dir, "report.xlsx")); code != exitOK {
+		t.Fatalf("report excel = %d: %s", code, stderr)
+	}
+	if code, stderr := run("report", "send"); code != exitFailure || !strings.Contains(stderr, "smtp.host") {
+		t.Errorf("report send = %d: %s; want a smtp.host error", code, stderr)
+	}
+}
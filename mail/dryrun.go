@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DryRunConfig 预览模式，开启后所有报告都只写入本地目录而不投递
+type DryRunConfig struct {
+	Enabled bool   `json:"enabled"`
+	Dir     string `json:"dir"` // 每封邮件写入该目录下的一个子目录
+}
+
+// DryRunTransport 预览投递方式：邮件按与真实发送相同的流程构建，只在最后一步写入本地文件
+// 每封邮件写入Dir下的一个子目录，包含完整报文message.eml、正文body.html和attachments目录下的各附件，并向Out输出摘要
+type DryRunTransport struct {
+	Dir string
+	Out io.Writer
+}
+
+var dryRunSeq uint64
+
+// Transport 按配置返回投递方式，开启dry_run时返回预览投递方式，否则为按smtp.retry重试的SMTP投递
+func (c *Config) Transport(mailServer *MailServerConfig) Transport {
+	if c.DryRun.Enabled {
+		return &DryRunTransport{Dir: c.DryRun.Dir, Out: os.Stdout}
+	}
+	transport := NewSMTPTransport(mailServer)
+	if policy, err := c.SMTP.Retry.Policy(); err == nil && policy.MaxAttempts > 1 {
+		return &RetryTransport{Transport: transport, Policy: policy}
+	}
+	return transport
+}
+
+// configuredTransport 按GlobalConfig返回投递方式，未加载配置时直接使用SMTP
+func configuredTransport(mailServer *MailServerConfig) Transport {
+	if GlobalConfig == nil {
+		return NewSMTPTransport(mailServer)
+	}
+	return GlobalConfig.Transport(mailServer)
+}
+
+// mailServerFor 通过transport投递时使用的邮件服务器配置
+// 预览不连接服务器，只需要发件人，因此不读取密码等凭据，未配置密码时也能预览
+func mailServerFor(transport Transport) (*MailServerConfig, error) {
+	if _, ok := transport.(*DryRunTransport); ok && GlobalConfig != nil {
+		return GlobalConfig.SMTP.sender(), nil
+	}
+	return configuredMailServer()
+}
+
+// configuredReportTransport 按GlobalConfig返回报告的投递方式，开启dry_run时不读取SMTP凭据
+func configuredReportTransport() (Transport, error) {
+	if GlobalConfig.DryRun.Enabled {
+		return GlobalConfig.Transport(nil), nil
+	}
+	mailServer, err := configuredMailServer()
+	if err != nil {
+		return nil, fmt.Errorf("读取邮件服务器配置失败: %w", err)
+	}
+	return GlobalConfig.Transport(mailServer), nil
+}
+
+func (t *DryRunTransport) Send(ctx context.Context, msg *Message) error {
+	return t.SendReport(ctx, msg, nil)
+}
+
+// SendReport 写出邮件并输出摘要，report不为nil时摘要中包含各部分的行数
+func (t *DryRunTransport) SendReport(ctx context.Context, msg *Message, report *Report) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return fmt.Errorf("构建邮件失败: %w", err)
+	}
+	seq := atomic.AddUint64(&dryRunSeq, 1)
+	dir := filepath.Join(t.Dir, fmt.Sprintf("%s_%d", time.Now().Format("20060102-150405"), seq))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("创建预览目录失败: %w", err)
+	}
+	type previewFile struct {
+		name string
+		data []byte
+	}
+	files := []previewFile{{"message.eml", data}}
+	if msg.HTML != "" {
+		files = append(files, previewFile{"body.html", []byte(msg.HTML)})
+	}
+	for i, name := range attachmentNames(msg.Attachments) {
+		if i == 0 {
+			if err := os.MkdirAll(filepath.Join(dir, "attachments"), 0o755); err != nil {
+				return fmt.Errorf("创建预览目录失败: %w", err)
+			}
+		}
+		files = append(files, previewFile{filepath.Join("attachments", name), msg.Attachments[i].Data})
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.name), f.data, 0o644); err != nil {
+			return fmt.Errorf("写入预览文件%s失败: %w", f.name, err)
+		}
+	}
+	if t.Out != nil {
+		fmt.Fprint(t.Out, dryRunSummary(msg, len(data), dir, report))
+	}
+	return nil
+}
+
+// attachmentNames 附件写入预览目录时的文件名，只保留文件名部分，重名时在扩展名前加序号，如report_2.xlsx
+func attachmentNames(attachments []Attachment) []string {
+	names := make([]string, len(attachments))
+	used := map[string]bool{}
+	for i, a := range attachments {
+		name := filepath.Base(filepath.FromSlash(a.Filename))
+		if name == "." || name == ".." || name == string(filepath.Separator) {
+			name = "attachment"
+		}
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d%s", base, n, ext)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// dryRunSummary 预览摘要：收件人、大小、附件以及各部分的行数
+func dryRunSummary(msg *Message, size int, dir string, report *Report) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[dry-run] %s\n", msg.Subject)
+	fmt.Fprintf(&b, "  目录: %s\n", dir)
+	for _, list := range []struct {
+		name  string
+		addrs []string
+	}{{"收件人", msg.To}, {"抄送", msg.Cc}, {"密送", msg.Bcc}} {
+		if len(list.addrs) > 0 {
+			fmt.Fprintf(&b, "  %s: %s\n", list.name, strings.Join(list.addrs, ", "))
+		}
+	}
+	fmt.Fprintf(&b, "  大小: %d字节\n", size)
+	for _, a := range msg.Attachments {
+		fmt.Fprintf(&b, "  附件: %s (%d字节)\n", a.Filename, len(a.Data))
+	}
+	for _, a := range msg.Inline {
+		fmt.Fprintf(&b, "  内嵌: %s (%d字节)\n", a.Filename, len(a.Data))
+	}
+	if report != nil {
+		var counts []string
+		for _, s := range report.Sections {
+			counts = append(counts, fmt.Sprintf("%s %d", s.Category.Label(), len(s.Records)))
+		}
+		if report.TrendWeeks > 0 {
+			counts = append(counts, fmt.Sprintf("新增 %d", len(report.NewlyFlagged)), fmt.Sprintf("已恢复 %d", len(report.Resolved)))
+		}
+		fmt.Fprintf(&b, "  行数: %s\n", strings.Join(counts, "，"))
+	}
+	return b.String()
+}
+
+// deliverReport 投递报告邮件并保存发送记录
+// 预览时把报告数据交给DryRunTransport用于摘要，且不保存记录，避免预览被当作已发送
+func deliverReport(ctx context.Context, transport Transport, msg *Message, record *HistoryRecord, report Report) error {
+	if preview, ok := transport.(*DryRunTransport); ok {
+		return preview.SendReport(ctx, msg, &report)
+	}
+	err := deliver(ctx, transport, msg)
+	record.SetResult(err)
+	saveHistory(ctx, record)
+	return err
+}
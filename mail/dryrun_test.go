@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dryRunConfig 开启预览的配置，password_file不存在，读取SMTP凭据时会失败
+func dryRunConfig(t *testing.T) *Config {
+	t.Helper()
+	cfg := testConfig()
+	cfg.SMTP.User = "rpa@example.com"
+	cfg.SMTP.Alias = "FinOps"
+	cfg.SMTP.PasswordFile = filepath.Join(t.TempDir(), "missing-password")
+	cfg.DryRun = DryRunConfig{Enabled: true, Dir: t.TempDir()}
+	return cfg
+}
+
+// previewDir 预览目录下唯一的邮件子目录
+func previewDir(t *testing.T, dir string) string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		t.Fatalf("preview dir has %d entries, want one message directory", len(entries))
+	}
+	return filepath.Join(dir, entries[0].Name())
+}
+
+func TestSendEmailDryRunWithoutCredentials(t *testing.T) {
+	cfg := dryRunConfig(t)
+	useConfig(t, cfg)
+	if _, err := cfg.SMTP.MailServer(); err == nil {
+		t.Fatal("MailServer() should fail without the password file")
+	}
+
+	err := SendEmail([]string{"ops@example.com"}, nil, "报告", "report.xlsx", []byte("xlsx"), nil, nil, nil, "<p>正文</p>")
+	if err != nil {
+		t.Fatalf("SendEmail() in dry-run = %v", err)
+	}
+	dir := previewDir(t, cfg.DryRun.Dir)
+	eml, err := os.ReadFile(filepath.Join(dir, "message.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(eml), `From: "FinOps" <rpa@example.com>`) {
+		t.Errorf("message.eml does not use the configured sender:\n%s", eml)
+	}
+	if body, err := os.ReadFile(filepath.Join(dir, "body.html")); err != nil || string(body) != "<p>正文</p>" {
+		t.Errorf("body.html = %q, %v", body, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "attachments", "report.xlsx")); err != nil || string(data) != "xlsx" {
+		t.Errorf("attachments/report.xlsx = %q, %v", data, err)
+	}
+}
+
+func TestDryRunTransportSummary(t *testing.T) {
+	useConfig(t, dryRunConfig(t))
+	var out bytes.Buffer
+	transport := &DryRunTransport{Dir: t.TempDir(), Out: &out}
+	err := SendEmailWithTransport(context.Background(), transport, []string{"ops@example.com"}, []string{"lead@example.com"}, "报告", "report.xlsx", []byte("xlsx"), nil, nil, nil, "<p>正文</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := previewDir(t, transport.Dir)
+	for _, line := range []string{
+		"[dry-run] 报告",
+		"目录: " + dir,
+		"收件人: ops@example.com",
+		"抄送: lead@example.com",
+		"附件: report.xlsx (4字节)",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("summary missing %q:\n%s", line, out.String())
+		}
+	}
+
+	out.Reset()
+	report := sampleReport()
+	msg := &Message{From: mail.Address{Address: "rpa@example.com"}, To: []string{"ops@example.com"}, Subject: "报告", HTML: "<p>正文</p>"}
+	if err := transport.SendReport(context.Background(), msg, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "行数: 超过阈值 1，低于阈值 1") {
+		t.Errorf("summary missing row counts:\n%s", out.String())
+	}
+}
+
+func TestAttachmentNames(t *testing.T) {
+	got := attachmentNames([]Attachment{{Filename: "report.xlsx"}, {Filename: "../report.xlsx"}, {Filename: "dir/report.xlsx"}, {Filename: ".."}, {Filename: "raw"}})
+	want := []string{"report.xlsx", "report_2.xlsx", "report_3.xlsx", "attachment", "raw"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("attachmentNames() = %v, want %v", got, want)
+	}
+}
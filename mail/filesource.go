@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PodSample 从Kubernetes导出的单个容器采样，CPU单位为核，内存单位为MiB
+type PodSample struct {
+	ApplicationName string    `json:"application_name"`
+	SystemName      string    `json:"system_name"`
+	GroupName       string    `json:"group_name"`
+	PodId           string    `json:"pod_id"`
+	Container       string    `json:"container"`
+	Cpu             float64   `json:"cpu"`
+	Memory          float64   `json:"memory"`
+	CpuLimit        float64   `json:"cpu_limit"`
+	MemoryLimit     float64   `json:"memory_limit"`
+	Recommend       string    `json:"recommend"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// sampleTimeLayouts 采样时间支持的格式，RFC3339之外的格式按本地时区解析
+var sampleTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+func parseSampleTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	for _, layout := range sampleTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法识别的时间: %q", value)
+}
+
+// UnmarshalJSON 时间字段兼容字符串和Unix秒
+func (s *PodSample) UnmarshalJSON(data []byte) error {
+	type plain PodSample
+	var raw struct {
+		plain
+		Timestamp json.RawMessage `json:"timestamp"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = PodSample(raw.plain)
+	var text string
+	if err := json.Unmarshal(raw.Timestamp, &text); err != nil {
+		text = string(raw.Timestamp)
+	}
+	t, err := parseSampleTime(text)
+	if err != nil {
+		return err
+	}
+	s.Timestamp = t
+	return nil
+}
+
+// ReadPodSamples 读取采样文件，.csv按表头识别列，.jsonl/.ndjson/.json为每行一个JSON对象
+func ReadPodSamples(path string) ([]PodSample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开采样文件失败: %w", err)
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return readCSVSamples(f, path)
+	case ".jsonl", ".ndjson", ".json":
+		return readJSONSamples(f, path)
+	}
+	return nil, fmt.Errorf("不支持的采样文件格式: %s", path)
+}
+
+func readCSVSamples(r io.Reader, path string) ([]PodSample, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("读取%s表头失败: %w", path, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"group_name", "pod_id", "container", "cpu", "memory", "cpu_limit", "memory_limit", "timestamp"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%s缺少列%s", path, required)
+		}
+	}
+
+	var samples []PodSample
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取%s失败: %w", path, err)
+		}
+		line, _ := reader.FieldPos(0)
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		var errs []error
+		number := func(name string) float64 {
+			text := get(name)
+			if text == "" {
+				return 0
+			}
+			v, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("列%s应为数字: %q", name, text))
+			}
+			return v
+		}
+		sample := PodSample{
+			ApplicationName: get("application_name"),
+			SystemName:      get("system_name"),
+			GroupName:       get("group_name"),
+			PodId:           get("pod_id"),
+			Container:       get("container"),
+			Cpu:             number("cpu"),
+			Memory:          number("memory"),
+			CpuLimit:        number("cpu_limit"),
+			MemoryLimit:     number("memory_limit"),
+			Recommend:       get("recommend"),
+		}
+		if sample.Timestamp, err = parseSampleTime(get("timestamp")); err != nil {
+			errs = append(errs, err)
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, fmt.Errorf("%s第%d行: %w", path, line, err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+func readJSONSamples(r io.Reader, path string) ([]PodSample, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	var samples []PodSample
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var sample PodSample
+		if err := json.Unmarshal([]byte(text), &sample); err != nil {
+			return nil, fmt.Errorf("%s第%d行: %w", path, line, err)
+		}
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取%s失败: %w", path, err)
+	}
+	return samples, nil
+}
+
+// FileMetricsSource 从导出的采样文件计算报告数据，用于无法连接数据库的环境
+// Paths支持通配符，窗口外的采样会被忽略
+type FileMetricsSource struct {
+	Paths []string
+
+	mu    sync.Mutex
+	cache map[string]map[string]*groupSamples // 按窗口缓存汇总结果
+}
+
+// groupSamples 部署组在窗口内的采样汇总
+type groupSamples struct {
+	app         MailAppInfo
+	recommendAt time.Time
+	container   [4]runningAvg // 依次为cpu_limit、mem_limit、cpu_core、mem_value
+	pod         [4]runningAvg
+	pods        map[string]*podSamples // 按pod_id汇总的明细
+}
+
+// podSamples 单个pod在窗口内的采样汇总
+type podSamples struct {
+	container [4]runningAvg
+	pod       [4]runningAvg
+}
+
+// podSamples 返回pod的汇总，不存在时创建
+func (g *groupSamples) podSamples(podID string) *podSamples {
+	if g.pods == nil {
+		g.pods = map[string]*podSamples{}
+	}
+	p := g.pods[podID]
+	if p == nil {
+		p = &podSamples{}
+		g.pods[podID] = p
+	}
+	return p
+}
+
+// runningAvg 累计的采样值，用于计算平均值和分位数
+type runningAvg struct {
+	sum    float64
+	values []float64
+}
+
+func (a *runningAvg) add(v float64) {
+	a.sum += v
+	a.values = append(a.values, v)
+}
+
+func (a runningAvg) value() (float64, bool) {
+	if len(a.values) == 0 {
+		return 0, false
+	}
+	return a.sum / float64(len(a.values)), true
+}
+
+// podKey 同一时刻同一pod的容器汇总为pod级数据
+type podKey struct {
+	group string
+	pod   string
+	ts    int64
+}
+
+// podTotals 同一时刻pod内各容器之和
+// 使用率只统计配置了对应limit的容器，没有limit的容器的使用量只计入usage
+type podTotals struct {
+	usage   PodSample // 全部容器的使用量
+	limited PodSample // 有limit的容器的使用量和limit
+}
+
+func (t *podTotals) add(sample PodSample) {
+	t.usage.Cpu += sample.Cpu
+	t.usage.Memory += sample.Memory
+	if sample.CpuLimit > 0 {
+		t.limited.Cpu += sample.Cpu
+		t.limited.CpuLimit += sample.CpuLimit
+	}
+	if sample.MemoryLimit > 0 {
+		t.limited.Memory += sample.Memory
+		t.limited.MemoryLimit += sample.MemoryLimit
+	}
+}
+
+// load 读取并汇总窗口内的采样，同一窗口只读取一次
+func (s *FileMetricsSource) load(w ReportWindow) (map[string]*groupSamples, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cacheKey := w.StartTime() + "/" + w.EndTime()
+	if groups, ok := s.cache[cacheKey]; ok {
+		return groups, nil
+	}
+
+	var files []string
+	for _, pattern := range s.Paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("采样文件路径错误: %w", err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("没有匹配%s的采样文件", pattern)
+		}
+		files = append(files, matches...)
+	}
+
+	start, end := w.bounds()
+	groups := map[string]*groupSamples{}
+	pods := map[podKey]*podTotals{}
+	for _, file := range files {
+		samples, err := ReadPodSamples(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range samples {
+			if sample.GroupName == "" || sample.Timestamp.Before(start) || !sample.Timestamp.Before(end) {
+				continue
+			}
+			g := groups[sample.GroupName]
+			if g == nil {
+				g = &groupSamples{app: MailAppInfo{GroupName: sample.GroupName}}
+				groups[sample.GroupName] = g
+			}
+			// 应用信息取任一非空值，建议取最新的一条
+			if g.app.ApplicationName == "" {
+				g.app.ApplicationName = sample.ApplicationName
+			}
+			if g.app.SystemName == "" {
+				g.app.SystemName = sample.SystemName
+			}
+			if sample.Recommend != "" && !sample.Timestamp.Before(g.recommendAt) {
+				g.app.Recommend, g.recommendAt = sample.Recommend, sample.Timestamp
+			}
+			addUsage(&g.container, sample)
+			addUsage(&g.podSamples(sample.PodId).container, sample)
+
+			key := podKey{sample.GroupName, sample.PodId, sample.Timestamp.Unix()}
+			p := pods[key]
+			if p == nil {
+				p = &podTotals{}
+				pods[key] = p
+			}
+			p.add(sample)
+		}
+	}
+	for key, p := range pods {
+		g := groups[key.group]
+		addPodUsage(&g.pod, p)
+		addPodUsage(&g.podSamples(key.pod).pod, p)
+	}
+	if s.cache == nil {
+		s.cache = map[string]map[string]*groupSamples{}
+	}
+	s.cache[cacheKey] = groups
+	return groups, nil
+}
+
+// addUsage 累加一个采样，没有limit的采样不计入使用率
+func addUsage(avgs *[4]runningAvg, sample PodSample) {
+	if sample.CpuLimit > 0 {
+		avgs[0].add(sample.Cpu / sample.CpuLimit)
+	}
+	if sample.MemoryLimit > 0 {
+		avgs[1].add(sample.Memory / sample.MemoryLimit)
+	}
+	avgs[2].add(sample.Cpu)
+	avgs[3].add(sample.Memory)
+}
+
+// addPodUsage 累加一个pod级采样，pod内没有容器配置limit时不计入使用率
+func addPodUsage(avgs *[4]runningAvg, p *podTotals) {
+	if p.limited.CpuLimit > 0 {
+		avgs[0].add(p.limited.Cpu / p.limited.CpuLimit)
+	}
+	if p.limited.MemoryLimit > 0 {
+		avgs[1].add(p.limited.Memory / p.limited.MemoryLimit)
+	}
+	avgs[2].add(p.usage.Cpu)
+	avgs[3].add(p.usage.Memory)
+}
+
+// weekAvg 取某项指标的周平均值，percent为true时换算为百分比，与SQL数据源的口径一致
+func (s *FileMetricsSource) weekAvg(w ReportWindow, metric int, percent bool, groupNames []string) ([]GroupWeekAvg, error) {
+	groups, err := s.load(w)
+	if err != nil {
+		return nil, err
+	}
+	var wanted map[string]bool
+	if len(groupNames) > 0 {
+		wanted = make(map[string]bool, len(groupNames))
+		for _, name := range groupNames {
+			wanted[name] = true
+		}
+	}
+	scale, digits := 1.0, 2
+	if percent {
+		scale, digits = 100, 1
+	}
+	var result []GroupWeekAvg
+	for name, g := range groups {
+		if wanted != nil && !wanted[name] {
+			continue
+		}
+		container, ok1 := g.container[metric].value()
+		pod, ok2 := g.pod[metric].value()
+		if !ok1 || !ok2 {
+			continue
+		}
+		result = append(result, GroupWeekAvg{
+			GroupName:    name,
+			ContainerAvg: roundTo(container*scale, digits),
+			PodAvg:       roundTo(pod*scale, digits),
+			Container:    computeStats(g.container[metric].values).scaled(scale, digits),
+			Pod:          computeStats(g.pod[metric].values).scaled(scale, digits),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ContainerAvg != result[j].ContainerAvg {
+			return result[i].ContainerAvg > result[j].ContainerAvg
+		}
+		return result[i].GroupName < result[j].GroupName
+	})
+	return result, nil
+}
+
+func (s *FileMetricsSource) CpuLimitWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]GroupWeekAvg, error) {
+	return s.weekAvg(w, 0, true, groupNames)
+}
+
+func (s *FileMetricsSource) MemLimitWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]MemLimitWeekData, error) {
+	return s.weekAvg(w, 1, true, groupNames)
+}
+
+func (s *FileMetricsSource) CpuCoreWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]CpuCoreWeekData, error) {
+	return s.weekAvg(w, 2, false, groupNames)
+}
+
+func (s *FileMetricsSource) MemAvgWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]MemAvgWeekData, error) {
+	return s.weekAvg(w, 3, false, groupNames)
+}
+
+func (s *FileMetricsSource) AppInfos(ctx context.Context, w ReportWindow, groupNames []string) (map[string]MailAppInfo, error) {
+	groups, err := s.load(w)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]MailAppInfo, len(groupNames))
+	for _, name := range groupNames {
+		if g, ok := groups[name]; ok {
+			infos[name] = g.app
+		}
+	}
+	return infos, nil
+}
+
+// PodUsage 各部署组下每个pod的平均使用情况
+func (s *FileMetricsSource) PodUsage(ctx context.Context, w ReportWindow, groupNames []string) (map[string][]UsageRecord, error) {
+	groups, err := s.load(w)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]UsageRecord, len(groupNames))
+	for _, name := range groupNames {
+		g, ok := groups[name]
+		if !ok {
+			continue
+		}
+		for podID, p := range g.pods {
+			record := UsageRecord{GroupName: name, PodId: podID}
+			fields := []struct {
+				container, pod *float64
+				scale          float64
+				digits         int
+			}{
+				{&record.ContainerCpuAvg, &record.PodCpuAvg, 100, 1},
+				{&record.ContainerMemAvg, &record.PodMemAvg, 100, 1},
+				{&record.ContainerCoreAvg, &record.PodCoreAvg, 1, 2},
+				{&record.ContainerMemValue, &record.PodMemValue, 1, 2},
+			}
+			for i, f := range fields {
+				container, _ := p.container[i].value()
+				pod, _ := p.pod[i].value()
+				*f.container = roundTo(container*f.scale, f.digits)
+				*f.pod = roundTo(pod*f.scale, f.digits)
+			}
+			result[name] = append(result[name], record)
+		}
+		sort.Slice(result[name], func(i, j int) bool { return result[name][i].PodId < result[name][j].PodId })
+	}
+	return result, nil
+}
+
+// LoadReportFromFiles 直接从采样文件生成邮件正文和Excel附件数据
+func LoadReportFromFiles(ctx context.Context, paths []string, rules *RuleEngine, w ReportWindow, opts ReportOptions) (MailTotalDataInfo, MailExcelDataInfo, error) {
+	source := &FileMetricsSource{Paths: paths}
+	excelInfo, err := GenerateExcelData(ctx, source, rules, w, opts)
+	if err != nil {
+		return MailTotalDataInfo{}, excelInfo, err
+	}
+	return excelInfo.Truncate(bodyRecordLimit), excelInfo, nil
+}
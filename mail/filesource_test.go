@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadPodSamplesCSV(t *testing.T) {
+	samples, err := ReadPodSamples("testdata/pod_samples.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 6 {
+		t.Fatalf("got %d samples, want 6", len(samples))
+	}
+	want := PodSample{
+		ApplicationName: "finops",
+		SystemName:      "gp18ar",
+		GroupName:       "finops-api",
+		PodId:           "p1",
+		Container:       "app",
+		Cpu:             1.5,
+		Memory:          900,
+		CpuLimit:        2,
+		MemoryLimit:     1024,
+		Timestamp:       time.Date(2025, 5, 27, 10, 0, 0, 0, time.Local),
+	}
+	if !reflect.DeepEqual(samples[0], want) {
+		t.Errorf("samples[0] = %+v, want %+v", samples[0], want)
+	}
+	if samples[3].Recommend != "新建议" || samples[1].Container != "sidecar" {
+		t.Errorf("samples[1], samples[3] = %+v, %+v", samples[1], samples[3])
+	}
+}
+
+func TestReadPodSamplesJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.jsonl")
+	content := `{"group_name":"finops-api","pod_id":"p1","container":"app","cpu":1.5,"memory":900,"cpu_limit":2,"memory_limit":1024,"timestamp":"2025-05-27T10:00:00+08:00"}
+
+{"group_name":"finops-api","pod_id":"p2","container":"app","cpu":1.7,"memory":950,"cpu_limit":2,"memory_limit":1024,"timestamp":1748311200,"recommend":"新建议"}
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	samples, err := ReadPodSamples(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("got %d samples, want 2", len(samples))
+	}
+	if got, want := samples[0].Timestamp, time.Date(2025, 5, 27, 2, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("samples[0].Timestamp = %v, want %v", got, want)
+	}
+	if got := samples[1].Timestamp.Unix(); got != 1748311200 {
+		t.Errorf("samples[1].Timestamp = %d, want unix seconds 1748311200", got)
+	}
+	if samples[1].PodId != "p2" || samples[1].Cpu != 1.7 || samples[1].Recommend != "新建议" {
+		t.Errorf("samples[1] = %+v", samples[1])
+	}
+}
+
+func TestReadPodSamplesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"missing column", "a.csv", "group_name,pod_id,container,cpu,memory,cpu_limit,timestamp\n", "缺少列memory_limit"},
+		{"bad number", "b.csv", "group_name,pod_id,container,cpu,memory,cpu_limit,memory_limit,timestamp\ng,p,c,1,2,3,4,2025-05-27\ng,p,c,x,2,3,4,2025-05-27\n", "第3行"},
+		{"bad time", "c.csv", "group_name,pod_id,container,cpu,memory,cpu_limit,memory_limit,timestamp\ng,p,c,1,2,3,4,yesterday\n", "无法识别的时间"},
+		{"bad json", "d.jsonl", "{\"group_name\":\"g\",\"timestamp\":\"2025-05-27\"}\n{oops\n", "第2行"},
+		{"unsupported", "e.txt", "", "不支持的采样文件格式"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := ReadPodSamples(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileMetricsSource(t *testing.T) {
+	source := &FileMetricsSource{Paths: []string{"testdata/*.csv"}}
+	ctx := context.Background()
+
+	// claims-batch在窗口外的采样不计入；finops-api的pod级数据为同一时刻各容器之和
+	cpu, err := source.CpuLimitWeekData(ctx, fixtureWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cpu) != 2 || cpu[0].GroupName != "finops-api" || cpu[1].GroupName != "claims-batch" {
+		t.Fatalf("CpuLimitWeekData() = %+v", cpu)
+	}
+	if math.Abs(cpu[0].ContainerAvg-66.25) > 0.05 || cpu[0].PodAvg != 78 {
+		t.Errorf("finops-api cpu_limit = %v/%v, want 66.25/78", cpu[0].ContainerAvg, cpu[0].PodAvg)
+	}
+	if cpu[1].ContainerAvg != 5 || cpu[1].PodAvg != 5 || cpu[1].Container.Max != 5 {
+		t.Errorf("claims-batch cpu_limit = %+v", cpu[1])
+	}
+
+	cores, err := source.CpuCoreWeekData(ctx, fixtureWindow, "finops-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cores) != 1 || cores[0].ContainerAvg != 1.25 || cores[0].PodAvg != 1.67 {
+		t.Errorf("CpuCoreWeekData() = %+v, want finops-api 1.25/1.67", cores)
+	}
+
+	infos, err := source.AppInfos(ctx, fixtureWindow, []string{"finops-api", "claims-batch", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]MailAppInfo{
+		"finops-api":   {GroupName: "finops-api", ApplicationName: "finops", SystemName: "gp18ar", Recommend: "新建议"},
+		"claims-batch": {GroupName: "claims-batch", ApplicationName: "claims-portal", SystemName: "claims"},
+	}
+	if !reflect.DeepEqual(infos, want) {
+		t.Errorf("AppInfos() = %+v, want %+v", infos, want)
+	}
+
+	pods, err := source.PodUsage(ctx, fixtureWindow, []string{"finops-api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pods["finops-api"]; len(got) != 2 || got[0].PodId != "p1" || got[0].ContainerCpuAvg != 47.5 || got[0].PodCpuAvg != 64 || got[1].PodCpuAvg != 85 {
+		t.Errorf("PodUsage() = %+v", got)
+	}
+
+	if _, err := (&FileMetricsSource{Paths: []string{"testdata/missing-*.csv"}}).CpuLimitWeekData(ctx, fixtureWindow); err == nil {
+		t.Error("expected error for paths without matches")
+	}
+}
+
+func TestFileMetricsSourceUnlimitedContainers(t *testing.T) {
+	// sidecar没有配置limit，pod使用率只按app容器计算，使用量仍包含sidecar
+	path := filepath.Join(t.TempDir(), "samples.csv")
+	csv := `group_name,pod_id,container,cpu,memory,cpu_limit,memory_limit,timestamp
+finops-api,p1,app,1,512,2,1024,2025-05-27 10:00:00
+finops-api,p1,sidecar,1,256,0,0,2025-05-27 10:00:00
+`
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source := &FileMetricsSource{Paths: []string{path}}
+	ctx := context.Background()
+
+	cpu, err := source.CpuLimitWeekData(ctx, fixtureWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cpu) != 1 || cpu[0].ContainerAvg != 50 || cpu[0].PodAvg != 50 {
+		t.Errorf("CpuLimitWeekData() = %+v, want 50/50", cpu)
+	}
+	mem, err := source.MemLimitWeekData(ctx, fixtureWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mem) != 1 || mem[0].ContainerAvg != 50 || mem[0].PodAvg != 50 {
+		t.Errorf("MemLimitWeekData() = %+v, want 50/50", mem)
+	}
+	cores, err := source.CpuCoreWeekData(ctx, fixtureWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cores) != 1 || cores[0].PodAvg != 2 {
+		t.Errorf("CpuCoreWeekData() = %+v, want a pod average of 2", cores)
+	}
+	pods, err := source.PodUsage(ctx, fixtureWindow, []string{"finops-api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pods["finops-api"]; len(got) != 1 || got[0].PodCpuAvg != 50 || got[0].PodMemAvg != 50 {
+		t.Errorf("PodUsage() = %+v", got)
+	}
+}
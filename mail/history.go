@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 发送结果
+const (
+	HistorySent   = "sent"
+	HistoryFailed = "failed"
+)
+
+// historyTimeLayout created_at的存储格式，统一为UTC并固定9位小数
+// created_at按文本比较和排序，RFC3339Nano会省略末尾的0，长度不同时文本顺序与时间顺序不一致
+const historyTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// historyTime 转换为created_at的存储格式
+func historyTime(t time.Time) string {
+	return t.UTC().Format(historyTimeLayout)
+}
+
+// historySchema 报告记录表，report_history_groups和report_history_recipients用于按部署组和收件人查询
+const historySchema = `
+CREATE TABLE IF NOT EXISTS report_history (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    report_name       TEXT NOT NULL,
+    created_at        TEXT NOT NULL,
+    start_time        TEXT NOT NULL,
+    end_time          TEXT NOT NULL,
+    subject           TEXT NOT NULL,
+    recipients        TEXT NOT NULL,
+    message_id        TEXT NOT NULL,
+    attachment_name   TEXT NOT NULL,
+    attachment_sha256 TEXT NOT NULL,
+    attachments       TEXT NOT NULL,
+    report            TEXT NOT NULL,
+    excel_report      TEXT NOT NULL,
+    status            TEXT NOT NULL,
+    error             TEXT NOT NULL,
+    resend_of         INTEGER
+);
+CREATE INDEX IF NOT EXISTS report_history_created_at ON report_history (created_at);
+CREATE TABLE IF NOT EXISTS report_history_groups (
+    history_id INTEGER NOT NULL REFERENCES report_history (id),
+    group_name TEXT NOT NULL,
+    category   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS report_history_groups_name ON report_history_groups (group_name);
+CREATE TABLE IF NOT EXISTS report_history_recipients (
+    history_id INTEGER NOT NULL REFERENCES report_history (id),
+    address    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS report_history_recipients_address ON report_history_recipients (address);
+`
+
+// HistoryRecord 一次报告发送的记录，Report和ExcelReport用于重新渲染正文和附件
+type HistoryRecord struct {
+	ID               int64
+	ReportName       string
+	CreatedAt        time.Time
+	Subject          string
+	To               []string
+	Cc               []string
+	Bcc              []string
+	MessageID        string
+	AttachmentName   string
+	AttachmentSHA256 string   // Excel附件内容的SHA-256(十六进制)
+	Attachments      []string // 额外附件的路径
+	Report           MailTotalDataInfo
+	ExcelReport      MailExcelDataInfo
+	Status           string // sent或failed
+	Error            string
+	ResendOf         int64 // 重发时为原记录的ID
+}
+
+// historyRecipients recipients列的内容
+type historyRecipients struct {
+	To  []string `json:"to"`
+	Cc  []string `json:"cc,omitempty"`
+	Bcc []string `json:"bcc,omitempty"`
+}
+
+// NewHistoryRecord 根据已构建的邮件和报告数据创建记录，邮件没有Message-ID时先生成
+func NewHistoryRecord(reportName string, msg *Message, report MailTotalDataInfo, excelReport MailExcelDataInfo, attachmentName string, attachment []byte, attachments []string) *HistoryRecord {
+	if msg.MessageID == "" {
+		msg.MessageID = generateMessageID(msg.From.Address)
+	}
+	record := &HistoryRecord{
+		ReportName:     reportName,
+		CreatedAt:      time.Now(),
+		Subject:        msg.Subject,
+		To:             msg.To,
+		Cc:             msg.Cc,
+		Bcc:            msg.Bcc,
+		MessageID:      msg.MessageID,
+		AttachmentName: attachmentName,
+		Attachments:    attachments,
+		Report:         report,
+		ExcelReport:    excelReport,
+	}
+	if len(attachment) > 0 {
+		sum := sha256.Sum256(attachment)
+		record.AttachmentSHA256 = hex.EncodeToString(sum[:])
+	}
+	return record
+}
+
+// SetResult 记录发送结果
+func (r *HistoryRecord) SetResult(err error) {
+	if err != nil {
+		r.Status, r.Error = HistoryFailed, err.Error()
+		return
+	}
+	r.Status, r.Error = HistorySent, ""
+}
+
+// HistoryStore 基于SQLite文件的报告记录
+type HistoryStore struct {
+	DB *sql.DB
+}
+
+// OpenHistoryStore 打开记录文件，不存在时创建
+func OpenHistoryStore(path string) (*HistoryStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("创建报告记录目录失败: %w", err)
+		}
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("打开报告记录失败: %w", err)
+	}
+	// 同一文件只使用一个连接，避免SQLite的写锁冲突
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化报告记录失败: %w", err)
+	}
+	store := &HistoryStore{DB: db}
+	if err := store.backfillRecipients(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// backfillRecipients 为增加report_history_recipients之前保存的记录补齐收件人
+func (s *HistoryStore) backfillRecipients(ctx context.Context) error {
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, recipients FROM report_history
+WHERE id NOT IN (SELECT history_id FROM report_history_recipients)`)
+	if err != nil {
+		return fmt.Errorf("初始化报告记录失败: %w", err)
+	}
+	pending := map[int64]historyRecipients{}
+	for rows.Next() {
+		var id int64
+		var data string
+		var rcpt historyRecipients
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return fmt.Errorf("初始化报告记录失败: %w", err)
+		}
+		if json.Unmarshal([]byte(data), &rcpt) == nil {
+			pending[id] = rcpt
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("初始化报告记录失败: %w", err)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("初始化报告记录失败: %w", err)
+	}
+	defer tx.Rollback()
+	for id, rcpt := range pending {
+		if err := saveHistoryRecipients(ctx, tx, id, rcpt); err != nil {
+			return fmt.Errorf("初始化报告记录失败: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("初始化报告记录失败: %w", err)
+	}
+	return nil
+}
+
+// saveHistoryRecipients 以不区分大小写的纯地址形式保存收件人、抄送和密送，同一地址只保存一次
+func saveHistoryRecipients(ctx context.Context, tx *sql.Tx, id int64, rcpt historyRecipients) error {
+	seen := map[string]bool{}
+	for _, list := range [][]string{rcpt.To, rcpt.Cc, rcpt.Bcc} {
+		for _, addr := range list {
+			address := normalizeHistoryAddress(addr)
+			if seen[address] {
+				continue
+			}
+			seen[address] = true
+			if _, err := tx.ExecContext(ctx, `INSERT INTO report_history_recipients (history_id, address) VALUES (?, ?)`, id, address); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeHistoryAddress 取出"Name <addr>"中的地址并转为小写，无法解析时使用去掉空白的原值
+func normalizeHistoryAddress(addr string) string {
+	if address, err := envelopeAddress(addr); err == nil {
+		addr = address
+	}
+	return strings.ToLower(strings.TrimSpace(addr))
+}
+
+// OpenHistory 按history配置打开记录，未配置时返回nil
+func (c *Config) OpenHistory() (*HistoryStore, error) {
+	if c.History.Path == "" {
+		return nil, nil
+	}
+	return OpenHistoryStore(c.History.Path)
+}
+
+// Close 关闭记录文件
+func (s *HistoryStore) Close() error {
+	return s.DB.Close()
+}
+
+// Save 保存记录并写入ID，同时记录报告中各部署组的分类
+func (s *HistoryStore) Save(ctx context.Context, r *HistoryRecord) error {
+	recipients, err := json.Marshal(historyRecipients{To: r.To, Cc: r.Cc, Bcc: r.Bcc})
+	if err != nil {
+		return fmt.Errorf("保存报告记录失败: %w", err)
+	}
+	attachments, err := json.Marshal(r.Attachments)
+	if err != nil {
+		return fmt.Errorf("保存报告记录失败: %w", err)
+	}
+	report, err := json.Marshal(r.Report)
+	if err != nil {
+		return fmt.Errorf("保存报告记录失败: %w", err)
+	}
+	excelReport, err := json.Marshal(r.ExcelReport)
+	if err != nil {
+		return fmt.Errorf("保存报告记录失败: %w", err)
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	var resendOf sql.NullInt64
+	if r.ResendOf > 0 {
+		resendOf = sql.NullInt64{Int64: r.ResendOf, Valid: true}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("保存报告记录失败: %w", err)
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `INSERT INTO report_history
+(report_name, created_at, start_time, end_time, subject, recipients, message_id, attachment_name, attachment_sha256, attachments, report, excel_report, status, error, resend_of)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ReportName, historyTime(r.CreatedAt), r.Report.StartTime, r.Report.EndTime, r.Subject, string(recipients),
+		r.MessageID, r.AttachmentName, r.AttachmentSHA256, string(attachments), string(report), string(excelReport), r.Status, r.Error, resendOf)
+	if err != nil {
+		return fmt.Errorf("保存报告记录失败: %w", err)
+	}
+	if r.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("保存报告记录失败: %w", err)
+	}
+	if err := saveHistoryRecipients(ctx, tx, r.ID, historyRecipients{To: r.To, Cc: r.Cc, Bcc: r.Bcc}); err != nil {
+		return fmt.Errorf("保存报告记录失败: %w", err)
+	}
+	// 以Excel数据为准，它包含全部部署组
+	for _, section := range r.ExcelReport.Sections {
+		for _, record := range section.Records {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO report_history_groups (history_id, group_name, category) VALUES (?, ?, ?)`,
+				r.ID, record.GroupName, string(section.Category)); err != nil {
+				return fmt.Errorf("保存报告记录失败: %w", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("保存报告记录失败: %w", err)
+	}
+	return nil
+}
+
+// HistoryFilter 查询条件，为零值的条件不生效
+type HistoryFilter struct {
+	ReportName string
+	Since      time.Time // 包含
+	Until      time.Time // 不包含
+	Recipient  string    // 收件人、抄送或密送中有该地址(不区分大小写，可以写成"Name <addr>")
+	GroupName  string    // 报告中包含该部署组
+	Category   Category  // 与GroupName一起使用，部署组属于该分类
+	Status     string
+	Limit      int // 0为不限
+}
+
+// historyColumns List和Get读取的列
+const historyColumns = `h.id, h.report_name, h.created_at, h.subject, h.recipients, h.message_id, h.attachment_name, h.attachment_sha256, h.attachments, h.report, h.excel_report, h.status, h.error, h.resend_of`
+
+// List 按创建时间倒序查询记录
+func (s *HistoryStore) List(ctx context.Context, f HistoryFilter) ([]HistoryRecord, error) {
+	var where []string
+	var args []interface{}
+	if f.ReportName != "" {
+		where = append(where, "h.report_name = ?")
+		args = append(args, f.ReportName)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "h.created_at >= ?")
+		args = append(args, historyTime(f.Since))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "h.created_at < ?")
+		args = append(args, historyTime(f.Until))
+	}
+	if f.Recipient != "" {
+		where = append(where, "h.id IN (SELECT history_id FROM report_history_recipients WHERE address = ?)")
+		args = append(args, normalizeHistoryAddress(f.Recipient))
+	}
+	if f.GroupName != "" {
+		sub := "SELECT history_id FROM report_history_groups WHERE group_name = ?"
+		args = append(args, f.GroupName)
+		if f.Category != "" {
+			sub += " AND category = ?"
+			args = append(args, string(f.Category))
+		}
+		where = append(where, "h.id IN ("+sub+")")
+	}
+	if f.Status != "" {
+		where = append(where, "h.status = ?")
+		args = append(args, f.Status)
+	}
+	query := "SELECT " + historyColumns + " FROM report_history h"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY h.created_at DESC, h.id DESC"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询报告记录失败: %w", err)
+	}
+	defer rows.Close()
+	var records []HistoryRecord
+	for rows.Next() {
+		record, err := scanHistoryRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("查询报告记录失败: %w", err)
+	}
+	return records, nil
+}
+
+// Get 按ID查询记录
+func (s *HistoryStore) Get(ctx context.Context, id int64) (*HistoryRecord, error) {
+	row := s.DB.QueryRowContext(ctx, "SELECT "+historyColumns+" FROM report_history h WHERE h.id = ?", id)
+	record, err := scanHistoryRecord(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("报告记录%d不存在", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// scanHistoryRecord 读取一行记录，row为*sql.Row或*sql.Rows
+func scanHistoryRecord(row interface{ Scan(...interface{}) error }) (HistoryRecord, error) {
+	var r HistoryRecord
+	var createdAt, recipients, attachments, report, excelReport string
+	var resendOf sql.NullInt64
+	if err := row.Scan(&r.ID, &r.ReportName, &createdAt, &r.Subject, &recipients, &r.MessageID, &r.AttachmentName,
+		&r.AttachmentSHA256, &attachments, &report, &excelReport, &r.Status, &r.Error, &resendOf); err != nil {
+		if err == sql.ErrNoRows {
+			return r, err
+		}
+		return r, fmt.Errorf("读取报告记录失败: %w", err)
+	}
+	var err error
+	if r.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return r, fmt.Errorf("报告记录%d的时间格式错误: %w", r.ID, err)
+	}
+	r.CreatedAt = r.CreatedAt.Local()
+	r.ResendOf = resendOf.Int64
+	var rcpt historyRecipients
+	for _, field := range []struct {
+		data   string
+		target interface{}
+	}{
+		{recipients, &rcpt},
+		{attachments, &r.Attachments},
+		{report, &r.Report},
+		{excelReport, &r.ExcelReport},
+	} {
+		if err := json.Unmarshal([]byte(field.data), field.target); err != nil {
+			return r, fmt.Errorf("报告记录%d的内容格式错误: %w", r.ID, err)
+		}
+	}
+	r.To, r.Cc, r.Bcc = rcpt.To, rcpt.Cc, rcpt.Bcc
+	return r, nil
+}
+
+// Render 使用当前模板重新渲染记录中的正文和Excel附件
+func (r *HistoryRecord) Render(templates TemplateConfig) (html, fileName string, attachment []byte, err error) {
+	if html, err = RenderReportHTML(templates.HTML, r.Report); err != nil {
+		return "", "", nil, err
+	}
+	if fileName, attachment, err = CreateExcelAttachmentWithData(templates.Excel, r.ExcelReport); err != nil {
+		return "", "", nil, err
+	}
+	return html, fileName, attachment, nil
+}
+
+// Resend 重新渲染并发送记录中的报告，收件人和主题与原记录一致，发送结果作为新记录保存
+// 返回的记录中包含发送结果，err只表示渲染、构建或保存失败
+func (s *HistoryStore) Resend(ctx context.Context, transport Transport, id int64, mailServer *MailServerConfig, templates TemplateConfig) (*HistoryRecord, error) {
+	original, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	html, fileName, attachment, err := original.Render(templates)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := buildReportMessage(original.To, original.Cc, original.Subject, fileName, attachment, nil, original.Attachments, mailServer, html)
+	if err != nil {
+		return nil, fmt.Errorf("构建邮件失败: %w", err)
+	}
+	msg.Bcc = original.Bcc
+	if err := embedReportImages(msg, original.Report.Images); err != nil {
+		return nil, fmt.Errorf("嵌入报告图片失败: %w", err)
+	}
+	record := NewHistoryRecord(original.ReportName, msg, original.Report, original.ExcelReport, fileName, attachment, original.Attachments)
+	record.ResendOf = original.ID
+	record.SetResult(deliver(ctx, transport, msg))
+	if err := s.Save(ctx, record); err != nil {
+		return record, err
+	}
+	return record, nil
+}
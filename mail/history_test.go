@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestHistory(t *testing.T) *HistoryStore {
+	t.Helper()
+	store, err := OpenHistoryStore(filepath.Join(t.TempDir(), "history", "finops_history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// saveTestRecord 保存一条以sampleReport为内容的记录
+func saveTestRecord(t *testing.T, store *HistoryStore, subject string, to, cc, bcc []string, createdAt time.Time) *HistoryRecord {
+	t.Helper()
+	msg := &Message{Subject: subject, To: to, Cc: cc, Bcc: bcc}
+	msg.From.Address = "rpa@example.com"
+	report := sampleReport()
+	record := NewHistoryRecord(DailyReport, msg, report, report, "FinOps.xlsx", []byte("xlsx"), nil)
+	record.CreatedAt = createdAt
+	record.SetResult(nil)
+	if err := store.Save(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func historyIDs(records []HistoryRecord) []int64 {
+	ids := make([]int64, 0, len(records))
+	for _, r := range records {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestHistorySaveAndGet(t *testing.T) {
+	store := openTestHistory(t)
+	created := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	saved := saveTestRecord(t, store, "FinOps周报", []string{"ops@example.com"}, []string{"FinOps <finops@example.com>"}, []string{"audit@example.com"}, created)
+	if saved.ID == 0 || saved.MessageID == "" {
+		t.Fatalf("saved record = %+v, want an ID and a Message-ID", saved)
+	}
+
+	got, err := store.Get(context.Background(), saved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != saved.Subject || got.MessageID != saved.MessageID || got.Status != HistorySent || !got.CreatedAt.Equal(created) {
+		t.Errorf("Get() = %+v, want %+v", got, saved)
+	}
+	if strings.Join(got.Cc, ",") != "FinOps <finops@example.com>" || strings.Join(got.Bcc, ",") != "audit@example.com" {
+		t.Errorf("recipients = %v / %v / %v", got.To, got.Cc, got.Bcc)
+	}
+	if names := groupNames(got.Report.Records(CategoryBelow)); strings.Join(names, ",") != "claims-batch" {
+		t.Errorf("stored report below = %v", names)
+	}
+	if _, err := store.Get(context.Background(), saved.ID+1); err == nil {
+		t.Error("Get() of a missing record should fail")
+	}
+}
+
+func TestHistoryList(t *testing.T) {
+	store := openTestHistory(t)
+	day := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	first := saveTestRecord(t, store, "first", []string{"FinOps Team <Ops@Example.com>"}, nil, nil, day)
+	second := saveTestRecord(t, store, "second", []string{"ops_1@example.com"}, []string{"finops@example.com"}, nil, day.AddDate(0, 0, 1))
+	third := saveTestRecord(t, store, "third", []string{"opsx1@example.com"}, nil, []string{"audit@example.com"}, day.AddDate(0, 0, 2))
+
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		want   []int64 // 按时间倒序
+	}{
+		{"all", HistoryFilter{}, []int64{third.ID, second.ID, first.ID}},
+		{"limit", HistoryFilter{Limit: 1}, []int64{third.ID}},
+		{"since until", HistoryFilter{Since: day.AddDate(0, 0, 1), Until: day.AddDate(0, 0, 2)}, []int64{second.ID}},
+		{"display name is ignored", HistoryFilter{Recipient: "ops@example.com"}, []int64{first.ID}},
+		{"case insensitive", HistoryFilter{Recipient: "OPS@EXAMPLE.COM"}, []int64{first.ID}},
+		{"filter with a display name", HistoryFilter{Recipient: "Someone <ops@example.com>"}, []int64{first.ID}},
+		{"underscore is not a wildcard", HistoryFilter{Recipient: "ops_1@example.com"}, []int64{second.ID}},
+		{"percent is not a wildcard", HistoryFilter{Recipient: "%@example.com"}, nil},
+		{"cc", HistoryFilter{Recipient: "finops@example.com"}, []int64{second.ID}},
+		{"bcc", HistoryFilter{Recipient: "audit@example.com"}, []int64{third.ID}},
+		{"group", HistoryFilter{GroupName: "claims-batch"}, []int64{third.ID, second.ID, first.ID}},
+		{"group and category", HistoryFilter{GroupName: "claims-batch", Category: CategoryOver}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := store.List(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := historyIDs(records); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("List() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryBackfillsRecipients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "finops_history.db")
+	store, err := OpenHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := saveTestRecord(t, store, "legacy", []string{"FinOps <Ops@example.com>"}, nil, nil, time.Now())
+	// 模拟增加收件人表之前保存的记录
+	if _, err := store.DB.Exec(`DELETE FROM report_history_recipients`); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = OpenHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	records, err := store.List(context.Background(), HistoryFilter{Recipient: "ops@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != record.ID {
+		t.Errorf("List() after reopening = %v, want record %d", historyIDs(records), record.ID)
+	}
+}
+
+func TestHistoryResend(t *testing.T) {
+	store := openTestHistory(t)
+	original := saveTestRecord(t, store, "FinOps周报", []string{"ops@example.com"}, []string{"finops@example.com"}, []string{"audit@example.com"}, time.Now())
+	templates := TemplateConfig{HTML: "../template/finops_table_new.html", Excel: "../template/FinOps.xlsx"}
+	transport := &MemoryTransport{}
+
+	resent, err := store.Resend(context.Background(), transport, original.ID, &MailServerConfig{User: "rpa@example.com", Alias: "FinOps"}, templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resent.ID == original.ID || resent.ResendOf != original.ID || resent.Status != HistorySent {
+		t.Errorf("resent record = %+v", resent)
+	}
+	if resent.MessageID == original.MessageID {
+		t.Error("resent mail reuses the original Message-ID")
+	}
+
+	messages := transport.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.Subject != original.Subject || strings.Join(msg.To, ",") != "ops@example.com" || strings.Join(msg.Cc, ",") != "finops@example.com" || strings.Join(msg.Bcc, ",") != "audit@example.com" {
+		t.Errorf("resent message = %q to %v cc %v bcc %v", msg.Subject, msg.To, msg.Cc, msg.Bcc)
+	}
+	if !strings.Contains(msg.HTML, "claims-batch") || len(msg.Attachments) != 1 {
+		t.Errorf("resent message has no report body or attachment: %d attachments", len(msg.Attachments))
+	}
+
+	stored, err := store.List(context.Background(), HistoryFilter{Recipient: "audit@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := historyIDs(stored); len(got) != 2 || got[0] != resent.ID {
+		t.Errorf("List() after resend = %v, want the resent record first", got)
+	}
+	if _, err := store.Resend(context.Background(), transport, resent.ID+1, &MailServerConfig{User: "rpa@example.com"}, templates); err == nil {
+		t.Error("Resend() of a missing record should fail")
+	}
+}
@@ -2,20 +2,18 @@ package main
 
 import (
 	"bytes"
-	"encoding/base64"
-	"encoding/hex"
+	"context"
 
 	"fmt"
 	"github.com/xuri/excelize/v2"
 	"html/template"
+	"io"
 	"io/ioutil"
 	"log"
-	"mime"
 	"net/mail"
-	"net/smtp"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
 	"time"
 )
 
@@ -26,209 +24,327 @@ type MailServerConfig struct {
 	User       string
 	Password   string
 	Alias      string
+
+	// TLS相关配置，TLSMode为空时465端口使用隐式TLS，其余端口使用机会性STARTTLS
+	TLSMode            TLSMode
+	TLSServerName      string   // 证书校验使用的主机名，为空时使用SMTPServer
+	CAFile             string   // 自定义CA证书(PEM)，为空时使用系统证书库
+	ClientCertFile     string   // 客户端证书(PEM)
+	ClientKeyFile      string   // 客户端私钥(PEM)
+	PinnedCertSHA256   []string // 服务器证书SHA-256指纹(十六进制，可含冒号)，任一匹配即通过
+	InsecureSkipVerify bool     // 跳过证书链校验，仅在配置了指纹时建议使用
+	AllowInsecureAuth  bool     // 允许在未加密的连接上发送凭据
+
+	// 认证相关配置
+	AuthMechanisms []string    // 允许使用的SASL机制(按偏好排序)，为空时自动协商
+	TokenSource    TokenSource // XOAUTH2使用的访问令牌来源
 }
 
-/*use unSSL to link mail server*/
-type unencryptedAuth struct {
-	smtp.Auth
+// String 输出配置时隐藏密码
+func (c MailServerConfig) String() string {
+	if c.Password != "" {
+		c.Password = redactedText
+	}
+	type plain MailServerConfig
+	return fmt.Sprintf("%+v", plain(c))
 }
 
-func (a unencryptedAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
-	s := *server
-	s.TLS = true
-	_, resp, th := a.Auth.Start(&s)
-	return "LOGIN", resp, th
+// constructMIMEImage 读取正文中插入的图片，返回图片MIME类型和原始内容
+func constructMIMEImage(cid, imagePath string) (string, []byte, error) {
+	imageData, err := ioutil.ReadFile(imagePath)
+	if err != nil {
+		log.Println(fmt.Sprintf("正文嵌入内容：%s(cid:%s) 打开失败：%v", imagePath, cid, err))
+		return "", nil, err
+	}
+
+	return detectContentType(imagePath, imageData), imageData, nil
 }
 
-type loginAuth struct {
-	username, password string
+// DailySendEmail 生成每日报告并通过transport投递，收件人和模板取自GlobalConfig
+func DailySendEmail(transport Transport) {
+	if err := DailySendEmailAt(context.Background(), transport, time.Now()); err != nil {
+		log.Println(err)
+	}
 }
 
-func LoginAuth(username, password string) smtp.Auth {
-	return &loginAuth{username, password}
+// DailySendEmailAt 按now对应的报告窗口生成并投递每日报告，调度器补发时now为错过的计划时间
+func DailySendEmailAt(ctx context.Context, transport Transport, now time.Time) error {
+	report, err := PrepareReport(ctx, DailyReport, DefaultReportWindow(now))
+	if err != nil {
+		return err
+	}
+	return report.Send(ctx, transport)
 }
 
-func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
-	return "LOGIN", nil, nil
+// PreparedReport 已生成的报告，发送、预览和导出使用同一份数据
+type PreparedReport struct {
+	Name       string
+	Config     ReportConfig // 收件人可在发送前修改
+	Window     ReportWindow
+	Info       MailTotalDataInfo
+	Excel      MailExcelDataInfo
+	HTML       string
+	FileName   string
+	Attachment []byte
+	Routing    bool // 是否按负责人另外发送个人报告
 }
 
-func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
-	command := string(fromServer)
-	command = strings.TrimSpace(command)
-	command = strings.TrimSuffix(command, ":")
-	command = strings.ToLower(command)
-	if more {
-		if command == "username" {
-			return []byte(fmt.Sprintf("%s", a.username)), nil
-		} else if command == "password" {
-			return []byte(fmt.Sprintf("%s", a.password)), nil
-		} else {
-			// We've already sent everything.
-			return nil, fmt.Errorf("unexpected server challenge: %s", command)
+// PrepareReport 查询数据源，生成name报告在window内的正文和Excel附件
+func PrepareReport(ctx context.Context, name string, window ReportWindow) (*PreparedReport, error) {
+	if GlobalConfig == nil {
+		return nil, fmt.Errorf("未加载配置，请先调用InitConfig")
+	}
+	report, err := GlobalConfig.Report(name)
+	if err != nil {
+		return nil, fmt.Errorf("读取报告配置失败: %w", err)
+	}
+
+	//构建报告表格数据
+	source, err := GlobalConfig.OpenMetricsSource(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("连接数据源失败: %w", err)
+	}
+	if closer, ok := source.(io.Closer); ok {
+		defer closer.Close()
+	}
+	rules := GlobalConfig.RuleEngine()
+	opts := ReportOptions{PodDetails: report.PodDetails, TrendWeeks: report.TrendWeeks, Pricing: GlobalConfig.PricingModel(), Recommender: GlobalConfig.RecommenderModel()}
+	// 只查询一次，正文从完整数据中截取
+	excelInfo, err := GenerateExcelData(ctx, source, rules, window, opts)
+	if err != nil {
+		return nil, fmt.Errorf("查询报告数据失败: %w", err)
+	}
+	if excelInfo.Images, err = loadReportImages(report.Images); err != nil {
+		return nil, err
+	}
+	info := excelInfo.Truncate(bodyRecordLimit)
+	htmlBody, err := RenderReportHTML(GlobalConfig.Templates.HTML, info)
+	if err != nil {
+		return nil, err
+	}
+
+	// 构建Excel附件
+	templatePath := GlobalConfig.Templates.Excel
+	fileName, encodedFile, err := CreateExcelAttachmentWithData(templatePath, excelInfo)
+	if err != nil {
+		log.Println("构建Excel附件失败:", err)
+		// 回退到普通附件
+		fileName, encodedFile, err = ConstructAttachment(templatePath)
+		if err != nil {
+			log.Println("回退失败:", err)
 		}
 	}
-	return nil, nil
+	return &PreparedReport{
+		Name:       name,
+		Config:     report,
+		Window:     window,
+		Info:       info,
+		Excel:      excelInfo,
+		HTML:       htmlBody,
+		FileName:   fileName,
+		Attachment: encodedFile,
+		Routing:    GlobalConfig.Routing.Enabled(),
+	}, nil
 }
 
-// constructMIMEImage 创建正文中插入的图片
-func constructMIMEImage(cid, imagePath string) (string, []byte, error) {
-	imageData, err := ioutil.ReadFile(imagePath)
+// Subject 报告邮件主题
+func (p *PreparedReport) Subject() string {
+	return reportSubject(p.Config)
+}
+
+// Message 构建报告邮件，发件人取自mailServer
+func (p *PreparedReport) Message(mailServer *MailServerConfig) (*Message, error) {
+	msg, err := buildReportMessage(p.Config.To, p.Config.Cc, p.Subject(), p.FileName, p.Attachment, nil, p.Config.Attachments, mailServer, p.HTML)
 	if err != nil {
-		log.Println(fmt.Sprintf("正文嵌入内容：%s 打开失败：%v", imagePath, err))
-		return "", nil, err
+		return nil, fmt.Errorf("构建邮件失败: %w", err)
+	}
+	msg.Bcc = p.Config.Bcc
+	if err := embedReportImages(msg, p.Info.Images); err != nil {
+		return nil, fmt.Errorf("嵌入报告图片失败: %w", err)
 	}
+	return msg, nil
+}
 
-	// 获取图片MIME类型
-	ext := filepath.Ext(imagePath)
-	mimeType := mime.TypeByExtension(ext)
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+// Send 通过transport投递报告并保存发送记录
+// Routing为true且全局报告发送成功时另外向各负责人发送个人报告，返回的错误包含各负责人的失败
+func (p *PreparedReport) Send(ctx context.Context, transport Transport) error {
+	mailServer, err := mailServerFor(transport)
+	if err != nil {
+		return fmt.Errorf("读取邮件服务器配置失败: %w", err)
+	}
+	msg, err := p.Message(mailServer)
+	if err != nil {
+		return err
+	}
+	record := NewHistoryRecord(p.Name, msg, p.Info, p.Excel, p.FileName, p.Attachment, p.Config.Attachments)
+	if err := deliverReport(ctx, transport, msg, record, p.Excel); err != nil {
+		return err
 	}
 
-	// 编码图片为base64
-	encodedImage := base64.StdEncoding.EncodeToString(imageData)
+	// 全局报告发给FinOps团队，各负责人另外收到自己的部署组；全局报告失败时不再发送，避免重发时负责人收到重复的报告
+	if p.Routing {
+		return sendOwnerReports(ctx, transport, p, mailServer)
+	}
+	return nil
+}
 
-	return mimeType, []byte(encodedImage), nil
+// saveHistory 按history配置保存发送记录，保存失败只输出错误，不影响发送
+func saveHistory(ctx context.Context, record *HistoryRecord) {
+	store, err := GlobalConfig.OpenHistory()
+	if err != nil {
+		log.Println("打开报告记录失败:", err)
+		return
+	}
+	if store == nil {
+		return
+	}
+	defer store.Close()
+	if err := store.Save(ctx, record); err != nil {
+		log.Println(err)
+	}
 }
 
-// encodeSubject 编码邮件主题
-func encodeSubject(subject string) string {
-	// 简单的base64编码实现
-	data := []byte(subject)
-	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
-	base64.StdEncoding.Encode(encoded, data)
-	return fmt.Sprintf("=?UTF-8?B?%s?=", encoded)
+// reportSubject 报告邮件主题，未配置时使用默认主题
+func reportSubject(report ReportConfig) string {
+	if report.Subject != "" {
+		return report.Subject
+	}
+	return "FinOps系统资源使用分析报告"
 }
 
-// encodeFileName 编码文件名
-func encodeFileName(filename string) string {
-	// 简单的base64编码实现
-	data := []byte(filename)
-	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
-	base64.StdEncoding.Encode(encoded, data)
-	return fmt.Sprintf("=?UTF-8?B?%s?=", encoded)
+// configuredMailServer 从GlobalConfig解析邮件服务器配置
+func configuredMailServer() (*MailServerConfig, error) {
+	if GlobalConfig == nil {
+		return nil, fmt.Errorf("未加载配置，请先调用InitConfig")
+	}
+	return GlobalConfig.SMTP.MailServer()
 }
 
-func DailySendEmail() {
-	// 测试验证数据
-	toReceiverList := []string{"1", "2"}
-	ccReceiverList := []string{"1", "2"}
-	mailTitle := "FinOps系统资源使用分析报告"
-	// 定义模板文件路径
-	fmt.Println("==========")
-	log.Println("==========")
+// SendEmail 通过SMTP发送邮件，mailServer为nil时使用GlobalConfig中的配置；开启dry_run时只写入预览目录
+// 临时失败按smtp.retry重试，服务器拒绝时返回的错误可通过errors.As取得*SMTPError
+func SendEmail(toReceiverList, ccReceiverList []string, mailTitle, fileName string, encodedFile []byte, imageDict map[string][2]string, fileList []string, mailServer *MailServerConfig, htmlBody string) error {
+	if mailServer == nil && GlobalConfig != nil && GlobalConfig.DryRun.Enabled {
+		// 预览只需要发件人，不读取SMTP凭据
+		mailServer = GlobalConfig.SMTP.sender()
+	}
+	if mailServer == nil {
+		var err error
+		if mailServer, err = configuredMailServer(); err != nil {
+			return fmt.Errorf("读取邮件服务器配置失败: %w", err)
+		}
+	}
+	return SendEmailWithTransport(context.Background(), configuredTransport(mailServer), toReceiverList, ccReceiverList, mailTitle, fileName, encodedFile, imageDict, fileList, mailServer, htmlBody)
+}
 
-	//构建报告表格数据
-	//TODO cpu、内存使用量
-	info := MailTotalDataInfo{}
+// SendEmailWithTransport 构建邮件并通过指定的transport投递，mailServer为nil时按mailServerFor取发件人
+func SendEmailWithTransport(ctx context.Context, transport Transport, toReceiverList, ccReceiverList []string, mailTitle, fileName string, encodedFile []byte, imageDict map[string][2]string, fileList []string, mailServer *MailServerConfig, htmlBody string) error {
+	if mailServer == nil {
+		var err error
+		if mailServer, err = mailServerFor(transport); err != nil {
+			return fmt.Errorf("读取邮件服务器配置失败: %w", err)
+		}
+	}
+	msg, err := buildReportMessage(toReceiverList, ccReceiverList, mailTitle, fileName, encodedFile, imageDict, fileList, mailServer, htmlBody)
+	if err != nil {
+		return fmt.Errorf("构建邮件失败: %w", err)
+	}
+	return deliver(ctx, transport, msg)
+}
 
-	htmlBody := renderHTML("template/finops_table_new.html", info)
+// deliver 投递邮件并输出结果，返回投递错误
+func deliver(ctx context.Context, transport Transport, msg *Message) error {
+	if err := transport.Send(ctx, msg); err != nil {
+		log.Printf("邮件发送失败：%v", err)
+		return err
+	}
 
-	// 构建Excel附件|构建excel数据
-	//TODO cpu、内存使用量
-	excelInfo := MailExcelDataInfo{}
-	templatePath := "template/FinOps.xlsx"
-	fileName, encodedFile, err := CreateExcelAttachmentWithData(templatePath, excelInfo)
-	if err != nil {
-		fmt.Println("构建Excel附件失败:", err)
-		// 回退到普通附件
-		fileName, encodedFile, err = ConstructAttachment(templatePath)
-		if err != nil {
-			fmt.Println("回退失败:", err)
-		}
+	log.Println("邮件发送成功")
+	return nil
+}
+
+// buildReportMessage 构建带HTML正文和Excel附件的邮件
+// imageDict的key为Content-ID，value[0]为图片路径，value[1]为可选的显示文件名
+// fileList为额外附件的路径，MIME类型按扩展名和内容自动判断
+func buildReportMessage(toReceiverList, ccReceiverList []string, mailTitle, fileName string, encodedFile []byte, imageDict map[string][2]string, fileList []string, mailServer *MailServerConfig, htmlBody string) (*Message, error) {
+	msg := &Message{
+		From:    mail.Address{Name: mailServer.Alias, Address: mailServer.User},
+		To:      toReceiverList,
+		Cc:      ccReceiverList,
+		Subject: mailTitle,
+		HTML:    htmlBody,
+	}
+	if len(encodedFile) > 0 {
+		msg.Attach(fileName, encodedFile, "")
 	}
-	if err == nil {
-		fmt.Println("构建Excel附件失败")
+	for _, filePath := range fileList {
+		if err := msg.AttachFile(filePath); err != nil {
+			return nil, err
+		}
 	}
 
-	SendEmail(toReceiverList, ccReceiverList, mailTitle, fileName, encodedFile, nil, nil, nil, htmlBody)
+	cids := make([]string, 0, len(imageDict))
+	for cid := range imageDict {
+		cids = append(cids, cid)
+	}
+	sort.Strings(cids)
+	for _, cid := range cids {
+		image := imageDict[cid]
+		if err := msg.EmbedImageFile(cid, image[0]); err != nil {
+			return nil, err
+		}
+		if image[1] != "" {
+			msg.Inline[len(msg.Inline)-1].Filename = image[1]
+		}
+	}
+	return msg, nil
 }
 
-func SendEmail(toReceiverList, ccReceiverList []string, mailTitle, fileName string, encodedFile []byte, imageDict map[string][2]string, fileList []string, mailServer *MailServerConfig, htmlBody string) bool {
-	// 设置邮件服务器配置
-	var (
-		smtpServer string
-		smtpPort   int
-		user       string
-		passwd     string
-		alias      string
-	)
+// loadReportImages 读取报告配置中的图片，内容随报告保存，重发时不依赖原文件
+func loadReportImages(configs []ReportImageConfig) ([]ReportImage, error) {
+	var images []ReportImage
+	for _, c := range configs {
+		data, err := os.ReadFile(c.Path)
+		if err != nil {
+			return nil, fmt.Errorf("读取报告图片失败: %w", err)
+		}
+		images = append(images, ReportImage{CID: c.ContentID(), Title: c.Title, Path: c.Path, Data: data})
+	}
+	return images, nil
+}
 
-	if mailServer != nil {
-		smtpServer = mailServer.SMTPServer
-		smtpPort = mailServer.SMTPPort
-		user = mailServer.User
-		passwd = mailServer.Password
-		alias = mailServer.Alias
-	} else {
-		// 默认配置
-		smtpServer = "21.0.0.76"
-		smtpPort = 25
-		user = "RPA@cpic.com.cn"
-		passwd = "SXzdh@YsL719"
-		alias = "寿险运维自动化"
-	}
-
-	// 构建邮件头部
-	from := mail.Address{Name: alias, Address: user}
-	to := make([]string, 0)
-	receiverList := append(toReceiverList, ccReceiverList...)
-	for _, addr := range receiverList {
-		to = append(to, addr)
-	}
-
-	// 构建完整邮件体
-	boundary := "----" + hex.EncodeToString([]byte(time.Now().Format("20060102150405")))
-	buffer := new(bytes.Buffer)
-
-	// 邮件头部
-	buffer.WriteString(fmt.Sprintf("From: %s\r\n", from.String()))
-	buffer.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(toReceiverList, "; ")))
-	if len(ccReceiverList) > 0 {
-		buffer.WriteString(fmt.Sprintf("Cc: %s\r\n", strings.Join(ccReceiverList, "; ")))
-	}
-	buffer.WriteString(fmt.Sprintf("Subject: %s\r\n", encodeSubject(mailTitle)))
-	buffer.WriteString(fmt.Sprintf("MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n", boundary))
-
-	// HTML正文部分
-	buffer.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-	buffer.WriteString("Content-Type: text/html; charset=UTF-8\r\n\r\n")
-	buffer.WriteString(htmlBody)
-
-	// 附件excel部分
-	buffer.WriteString(fmt.Sprintf("\r\n--%s\r\n", boundary))
-	buffer.WriteString(fmt.Sprintf("Content-Type: application/vnd.openxmlformats-officedocument.spreadsheetml.sheet; charset=UTF-8; name=%q\r\n", fileName))
-	buffer.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=%q\r\n", fileName))
-	buffer.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
-	// 对文件内容进行base64编码后再写入
-	encodedData := make([]byte, base64.StdEncoding.EncodedLen(len(encodedFile)))
-	base64.StdEncoding.Encode(encodedData, encodedFile)
-	buffer.Write(encodedData)
-
-	buffer.WriteString(fmt.Sprintf("\r\n--%s--\r\n", boundary))
-
-	// 发送邮件
-	auth := LoginAuth(user, passwd)
-
-	smtpAddr := fmt.Sprintf("%s:%d", smtpServer, smtpPort)
-
-	err := smtp.SendMail(smtpAddr, auth, user, to, buffer.Bytes())
-	if err != nil {
-		fmt.Println(fmt.Sprintf("邮件发送失败：%v", err))
-		return false
+// embedReportImages 将报告中的图片内嵌到邮件中
+func embedReportImages(msg *Message, images []ReportImage) error {
+	for _, image := range images {
+		if image.Data != nil {
+			msg.EmbedImage(image.CID, image.FileName(), image.Data)
+			continue
+		}
+		if err := msg.EmbedImageFile(image.CID, image.Path); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	fmt.Println("邮件发送成功")
-	return true
+// reportTemplateFuncs 报告模板中可用的函数
+var reportTemplateFuncs = template.FuncMap{
+	// cid 生成引用内嵌图片的URL，html/template默认会拦截cid:协议
+	"cid": func(contentID string) template.URL {
+		return template.URL("cid:" + contentID)
+	},
 }
 
-func renderHTML(tplPath string, data MailTotalDataInfo) string {
-	tmpl := template.Must(template.ParseFiles(tplPath))
+// RenderReportHTML 使用模板渲染报告正文
+func RenderReportHTML(tplPath string, data MailTotalDataInfo) (string, error) {
+	tmpl, err := template.New(filepath.Base(tplPath)).Funcs(reportTemplateFuncs).ParseFiles(tplPath)
+	if err != nil {
+		return "", fmt.Errorf("解析模板失败: %w", err)
+	}
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
-		panic(err)
+		return "", fmt.Errorf("渲染模板失败: %w", err)
 	}
-	return buf.String()
+	return buf.String(), nil
 }
 
 // ConstructAttachment 创建邮件附件
@@ -238,13 +354,20 @@ func ConstructAttachment(filePath string) (string, []byte, error) {
 		return "", nil, err
 	}
 
-	// 获取文件名
+	// 获取文件名，内容的base64编码由邮件构建时完成
 	fileName := filepath.Base(filePath)
 
-	// 编码文件为base64
-	encodedFile := base64.StdEncoding.EncodeToString(fileData)
+	return fileName, fileData, nil
+}
 
-	return fileName, []byte(encodedFile), nil
+// excelSheets 每个分类写入的工作表及表头
+var excelSheets = []struct {
+	Category Category
+	Sheet    string
+	Headers  []string
+}{
+	{CategoryOver, "CPU资源使用率超过阈值", []string{"系统名称", "应用名称", "部署组名", "CPU Container(%)", "CPU Pod(%)", "内存 Container(%)", "内存 Pod(%)", "建议"}},
+	{CategoryBelow, "CPU资源使用率低于阈值", []string{"系统名称", "应用名称", "组名", "CPU Container(%)", "CPU Pod(%)", "内存 Container(%)", "内存 Pod(%)", "建议"}},
 }
 
 func CreateExcelAttachmentWithData(templatePath string, data MailExcelDataInfo) (string, []byte, error) {
@@ -254,80 +377,15 @@ func CreateExcelAttachmentWithData(templatePath string, data MailExcelDataInfo)
 		return "", nil, fmt.Errorf("打开模板失败: %w", err)
 	}
 
-	// 2-1、插入OverWeekExcelData数据
-	startRow := 1
-	headers := []string{"系统名称", "应用名称", "部署组名", "CPU Container(%)", "CPU Pod(%)", "内存 Container(%)", "内存 Pod(%)", "建议"}
-	for colIndex, header := range headers {
-		colName, err := excelize.ColumnNumberToName(colIndex + 1)
-		if err != nil {
-			return "", nil, fmt.Errorf("获取列名失败: %v", err)
-		}
-		col := colName + fmt.Sprintf("%d", startRow)
-		if err := f.SetCellValue("CPU资源使用率超过阈值", col, header); err != nil {
-			return "", nil, fmt.Errorf("设置表头失败: %v", err)
-		}
-	}
-
-	for i, item := range data.OverWeekExcelData {
-		row := startRow + i + 1
-		rowData := []interface{}{
-			item.SystemName,
-			item.ApplicationName,
-			item.GroupName,
-			item.ContainerCpuAvg,
-			item.PodCpuAvg,
-			item.ContainerMemAvg,
-			item.PodMemAvg,
-			item.Recommend,
-		}
-		for colIndex, cellData := range rowData {
-			colName, err := excelize.ColumnNumberToName(colIndex + 1)
-			if err != nil {
-				return "", nil, fmt.Errorf("获取列名失败: %v", err)
-			}
-			col := colName + fmt.Sprintf("%d", row)
-			if err := f.SetCellValue("CPU资源使用率超过阈值", col, cellData); err != nil {
-				return "", nil, fmt.Errorf("写入数据失败: %v", err)
-			}
-		}
-	}
-
-	// 2-2、处理BelowWeekExcelData（CPU资源使用率低于阈值）
-	startBelowRow := 1
-	belowHeaders := []string{"系统名称", "应用名称", "组名", "CPU Container(%)", "CPU Pod(%)", "内存 Container(%)", "内存 Pod(%)", "建议"}
-	for colIndex, header := range belowHeaders {
-		colName, err := excelize.ColumnNumberToName(colIndex + 1)
-		if err != nil {
-			return "", nil, fmt.Errorf("获取列名失败: %v", err)
-		}
-		col := colName + fmt.Sprintf("%d", startBelowRow)
-		if err := f.SetCellValue("CPU资源使用率低于阈值", col, header); err != nil {
-			return "", nil, fmt.Errorf("设置表头失败: %v", err)
+	// 2. 每个分类写入对应的工作表
+	for _, sheet := range excelSheets {
+		if err := writeExcelSection(f, sheet.Sheet, sheet.Headers, data.Records(sheet.Category)); err != nil {
+			return "", nil, err
 		}
 	}
-
-	// 插入BelowWeekExcelData数据
-	for i, item := range data.BelowWeekExcelData {
-		row := startBelowRow + i + 1
-		rowData := []interface{}{
-			item.SystemName,
-			item.ApplicationName,
-			item.GroupName,
-			item.ContainerCpuAvg,
-			item.PodCpuAvg,
-			item.ContainerMemAvg,
-			item.PodMemAvg,
-			item.Recommend,
-		}
-		for colIndex, cellData := range rowData {
-			colName, err := excelize.ColumnNumberToName(colIndex + 1)
-			if err != nil {
-				return "", nil, fmt.Errorf("获取列名失败: %v", err)
-			}
-			col := colName + fmt.Sprintf("%d", row)
-			if err := f.SetCellValue("CPU资源使用率低于阈值", col, cellData); err != nil {
-				return "", nil, fmt.Errorf("写入数据失败: %v", err)
-			}
+	if data.TrendWeeks > 0 {
+		if err := writeExcelChanges(f, trendSheet, data); err != nil {
+			return "", nil, err
 		}
 	}
 
@@ -351,433 +409,248 @@ func CreateExcelAttachmentWithData(templatePath string, data MailExcelDataInfo)
 	return "FinOps_系统资源使用分析数据.xlsx", fileBytes, nil
 }
 
-// 处理mailContext
-//func GenerateData() MailTotalDataInfo {
-//	//cpu和mem,或
-//	//cpu pod>0.4,container>0.6
-//	//mem container>0.95
-//	var info MailTotalDataInfo
-//	var CpuLimitOverWeekData []model.CpuLimitWeekData
-//	info.EndTime = time.Now().Add(-24 * time.Hour).Format("2006-01-02")
-//	info.StartTime = time.Now().Add(-24 * 7 * time.Hour).Format("2006-01-02")
-//	err := AUTOMATED_DB.Table("container_cpu_limit_week_values as c").
-//		Select("c.group_name as group_name, round(avg(c.average*100),1) as container_avg, round(avg(p.average*100),1) as pod_avg").
-//		Joins("JOIN pod_cpu_limit_week_values as p ON c.group_name = p.group_name").
-//		Where("p.average >= ? AND c.average >= ? AND c.creat_time >= ?", 0.4, 0.6, time.Now().Format("2006-01-02")).
-//		Group("c.group_name").
-//		Order("c.average desc").
-//		Limit(10).Scan(&CpuLimitOverWeekData)
-//	if err != nil {
-//		log.Println("err", err)
-//	}
-//	log.Println("1、CpuLimitOverWeekData ", CpuLimitOverWeekData)
-//
-//	// 2、初始化OverWeekData切片，长度与CpuOverWeekData相同
-//	OverWeekData := make([]model.OverWeekData, len(CpuLimitOverWeekData))
-//	for i, data := range CpuLimitOverWeekData {
-//		//根据group_name查application_name和cimsid
-//		var MailAppInfo model.MailAppInfo
-//		//log.Println("2、group_name", data.GroupName)
-//
-//		//err1 := AUTOMATED_DB.Table("app_groups ag").Joins("JOIN cims_systems_info c ON ag.cimsid=c.cimsid").Select("ag.application_name as application_name,ag.group_name as group_name,c.systemname as system_name").Where("ag.group_name =?", data.GroupName).Limit(1).Scan(&MailAppInfo).Error
-//		//var recommend string
-//		//AUTOMATED_DB.Table("agent_recommend_weeks").Select("recommend").Where("group_name =? and creat_time >=?", data.GroupName, time.Now().Format("2006-01-02")).Scan(&recommend)
-//
-//		err1 := AUTOMATED_DB.Table("app_groups ag").
-//			Joins("JOIN cims_systems_info c ON ag.cimsid=c.cimsid").
-//			Joins("LEFT JOIN agent_recommend_weeks arw ON ag.group_name = arw.group_name AND arw.creat_time >= ?", time.Now().Format("2006-01-02")).
-//			Select("ag.application_name as application_name,ag.group_name as group_name,c.systemname as system_name,arw.recommend as recommend").
-//			Where("ag.group_name =?", data.GroupName).Limit(1).Scan(&MailAppInfo).Error
-//		fmt.Println("---MailAppInfo---", MailAppInfo)
-//
-//		//TODO 3、mem数据有问题 >0.95
-//		var MemLimitWeekData model.MemLimitWeekData
-//		AUTOMATED_DB.Table("container_memory_limit_week_values c").
-//			Select("c.group_name as group_name, ROUND (avg(c.average * 100), 1)as container_avg, ROUND (avg(p.average * 100), 1)as pod_avg").
-//			Joins("JOIN pod_memory_limit_week_values p ON c.group_name = p.group_name").
-//			Where("c.group_name=? and c.creat_time >= ?", data.GroupName, time.Now().Format("2006-01-02")).
-//			Group("c.group_name").
-//			Order("c.average desc").
-//			Limit(10).Scan(&MemLimitWeekData)
-//		if err1 != nil {
-//			log.Println("err1", err1)
-//		}
-//		OverWeekData[i].ContainerCpuAvg = data.ContainerAvg
-//		OverWeekData[i].PodCpuAvg = data.PodAvg
-//		OverWeekData[i].ApplicationName = MailAppInfo.ApplicationName
-//		OverWeekData[i].SystemName = MailAppInfo.SystemName
-//		OverWeekData[i].GroupName = MailAppInfo.GroupName
-//		OverWeekData[i].PodMemAvg = MemLimitWeekData.PodAvg
-//		OverWeekData[i].ContainerMemAvg = MemLimitWeekData.ContainerAvg
-//		OverWeekData[i].Recommend = MailAppInfo.Recommend
-//	}
-//	info.OverWeekData = OverWeekData
-//	//log.Println("2、OverWeekData", OverWeekData)
-//
-//	//pod<0.15,container<0.3
-//	var CpuLimitBelowWeekData []model.CpuLimitWeekData
-//	AUTOMATED_DB.Table("container_cpu_limit_week_values as c").
-//		Select("c.group_name as group_name, round(avg(c.average*100),1) as container_avg, round(avg(p.average*100),1) as pod_avg").
-//		Joins("JOIN pod_cpu_limit_week_values as p ON c.group_name = p.group_name").
-//		Where("p.average >= ? AND p.average < ? AND c.average >= ? AND c.average < ? AND c.creat_time >= ?", 0.001, 0.15, 0.001, 0.3, time.Now().Format("2006-01-02")).
-//		Group("c.group_name").
-//		Order("c.average asc").
-//		Limit(10).Scan(&CpuLimitBelowWeekData)
-//
-//	if err != nil {
-//		log.Println("err", err)
-//	}
-//	//log.Print("3、CpuLimitBelowWeekData", CpuLimitBelowWeekData)
-//	// 4、初始化BelowWeekData切片，长度与CpuBelowWeekData相同
-//	BelowWeekData := make([]model.BelowWeekData, len(CpuLimitBelowWeekData))
-//	for i, data := range CpuLimitBelowWeekData {
-//		//根据group_name查application_name和systemname
-//		var MailAppInfo model.MailAppInfo
-//		AUTOMATED_DB.Table("app_groups ag").
-//			Joins("JOIN cims_systems_info c ON ag.cimsid=c.cimsid").
-//			Joins("LEFT JOIN agent_recommend_weeks arw ON ag.group_name = arw.group_name AND arw.creat_time >= ?", time.Now().Format("2006-01-02")).
-//			Select("ag.application_name as application_name,ag.group_name as group_name,c.systemname as system_name,arw.recommend as recommend").
-//			Where("ag.group_name =?", data.GroupName).Limit(1).Scan(&MailAppInfo)
-//		//fmt.Println("---MailAppInfo---", MailAppInfo)
-//
-//		var MemLimitWeekData model.MemLimitWeekData
-//		AUTOMATED_DB.Table("container_memory_limit_week_values c").
-//			Select("c.group_name as group_name, ROUND (avg(c.average * 100), 1)as container_avg, ROUND (avg(p.average * 100), 1)as pod_avg").
-//			Joins("JOIN pod_memory_limit_week_values p ON c.group_name = p.group_name").
-//			Where("c.group_name=? and c.creat_time >= ?", data.GroupName, time.Now().Format("2006-01-02")).
-//			Group("c.group_name").
-//			Order("c.average desc").
-//			Limit(10).Scan(&MemLimitWeekData)
-//		if err != nil {
-//			log.Println("err1", err)
-//		}
-//		BelowWeekData[i].ContainerCpuAvg = data.ContainerAvg
-//		BelowWeekData[i].PodCpuAvg = data.PodAvg
-//		BelowWeekData[i].ContainerMemAvg = MemLimitWeekData.ContainerAvg
-//		BelowWeekData[i].PodMemAvg = MemLimitWeekData.PodAvg
-//		BelowWeekData[i].ApplicationName = MailAppInfo.ApplicationName
-//		BelowWeekData[i].SystemName = MailAppInfo.SystemName
-//		BelowWeekData[i].GroupName = MailAppInfo.GroupName
-//		BelowWeekData[i].Recommend = MailAppInfo.Recommend
-//	}
-//	info.BelowWeekData = BelowWeekData
-//	//log.Print("4、BelowWeekData", BelowWeekData)
-//
-//	//log.Println("5、info", info)
-//
-//	return info
-//}
-
-// 处理ExcelData
-//func GenerateExcelData() model.MailExcelDataInfo {
-//	var info model.MailExcelDataInfo
-//	var CpuOverWeekData []model.CpuLimitWeekData
-//	//log.Println("查询1、CpuOverWeekData")
-//	err := AUTOMATED_DB.Table("container_cpu_limit_week_values as c").
-//		Select("c.group_name as group_name, round(avg(c.average*100),1) as container_avg, round(avg(p.average*100),1) as pod_avg").
-//		Joins("JOIN pod_cpu_limit_week_values as p ON c.group_name = p.group_name").
-//		Where("p.average >= ? AND c.average >= ? AND c.creat_time >= ?", 0.4, 0.6, time.Now().Format("2006-01-02")).
-//		Group("c.group_name").
-//		Order("c.average desc").
-//		Scan(&CpuOverWeekData)
-//	if err != nil {
-//		log.Println("err", err)
-//	}
-//	//fmt.Println("1、CpuOverWeekData ", CpuOverWeekData)
-//	// 2、初始化OverWeekExcelData切片，长度与CpuOverWeekData相同
-//	OverWeekExcelData := make([]model.OverWeekExcelData, len(CpuOverWeekData))
-//	for i, data := range CpuOverWeekData {
-//		//根据group_name查application_name和cimsid
-//		var MailAppInfo model.MailAppInfo
-//		log.Println("2、group_name", data.GroupName)
-//		err1 := AUTOMATED_DB.Table("app_groups ag").
-//			Joins("JOIN cims_systems_info c ON ag.cimsid=c.cimsid").
-//			Joins("LEFT JOIN agent_recommend_weeks arw ON ag.group_name = arw.group_name AND arw.creat_time >= ?", time.Now().Format("2006-01-02")).
-//			Select("ag.application_name as application_name,ag.group_name as group_name,c.systemname as system_name,arw.recommend as recommend").
-//			Where("ag.group_name =?", data.GroupName).Limit(1).Scan(&MailAppInfo).Error
-//		//fmt.Println("---MailAppInfo---", MailAppInfo)
-//
-//		var MemWeekData model.MemLimitWeekData
-//		AUTOMATED_DB.Table("container_memory_limit_week_values c").
-//			Select("c.group_name as group_name, ROUND (avg(c.average * 100), 1)as container_avg, ROUND (avg(p.average * 100), 1)as pod_avg").
-//			Joins("JOIN pod_memory_limit_week_values p ON c.group_name = p.group_name").
-//			Where("c.group_name=? and c.creat_time >= ?", data.GroupName, time.Now().Format("2006-01-02")).
-//			Group("c.group_name").
-//			Order("c.average desc").
-//			Scan(&MemWeekData)
-//		if err1 != nil {
-//			log.Println("err1", err1)
-//		}
-//		OverWeekExcelData[i].ContainerCpuAvg = data.ContainerAvg
-//		OverWeekExcelData[i].PodCpuAvg = data.PodAvg
-//		OverWeekExcelData[i].ApplicationName = MailAppInfo.ApplicationName
-//		OverWeekExcelData[i].SystemName = MailAppInfo.SystemName
-//		OverWeekExcelData[i].GroupName = MailAppInfo.GroupName
-//		OverWeekExcelData[i].PodMemAvg = MemWeekData.PodAvg
-//		OverWeekExcelData[i].ContainerMemAvg = MemWeekData.ContainerAvg
-//		OverWeekExcelData[i].Recommend = MailAppInfo.Recommend
-//	}
-//
-//	//TODO 使用量修改
-//	var CpuCoreWeekData []model.CpuCoreWeekData
-//	//log.Println("查询1、CpuOverWeekData")
-//	err = AUTOMATED_DB.Table("container_cpu_core_week_values as c").
-//		Select("c.group_name as group_name, round(avg(c.average*100),1) as container_avg, round(avg(p.average*100),1) as pod_avg").
-//		Joins("JOIN pod_cpu_core_week_values as p ON c.group_name = p.group_name").
-//		Where("p.average >= ? AND c.average >= ? AND c.creat_time >= ?", 0.4, 0.6, time.Now().Format("2006-01-02")).
-//		Group("c.group_name").
-//		Order("c.average desc").
-//		Scan(&CpuCoreWeekData)
-//	if err != nil {
-//		log.Println("err", err)
-//	}
-//	for i, data := range CpuCoreWeekData {
-//		//根据group_name查application_name和cimsid
-//		var MailAppInfo model.MailAppInfo
-//		log.Println("2、group_name", data.GroupName)
-//		err1 := AUTOMATED_DB.Table("app_groups ag").
-//			Joins("JOIN cims_systems_info c ON ag.cimsid=c.cimsid").
-//			Joins("LEFT JOIN agent_recommend_weeks arw ON ag.group_name = arw.group_name AND arw.creat_time >= ?", time.Now().Format("2006-01-02")).
-//			Select("ag.application_name as application_name,ag.group_name as group_name,c.systemname as system_name,arw.recommend as recommend").
-//			Where("ag.group_name =?", data.GroupName).Limit(1).Scan(&MailAppInfo).Error
-//		//fmt.Println("---MailAppInfo---", MailAppInfo)
-//
-//		var MemWeekData model.MemLimitWeekData
-//		AUTOMATED_DB.Table("container_memory_limit_week_values c").
-//			Select("c.group_name as group_name, ROUND (avg(c.average * 100), 1)as container_avg, ROUND (avg(p.average * 100), 1)as pod_avg").
-//			Joins("JOIN pod_memory_limit_week_values p ON c.group_name = p.group_name").
-//			Where("c.group_name=? and c.creat_time >= ?", data.GroupName, time.Now().Format("2006-01-02")).
-//			Group("c.group_name").
-//			Order("c.average desc").
-//			Scan(&MemWeekData)
-//		if err1 != nil {
-//			log.Println("err1", err1)
-//		}
-//		OverWeekExcelData[i].ContainerCpuAvg = data.ContainerAvg
-//		OverWeekExcelData[i].PodCpuAvg = data.PodAvg
-//		OverWeekExcelData[i].ApplicationName = MailAppInfo.ApplicationName
-//		OverWeekExcelData[i].SystemName = MailAppInfo.SystemName
-//		OverWeekExcelData[i].GroupName = MailAppInfo.GroupName
-//		OverWeekExcelData[i].PodMemAvg = MemWeekData.PodAvg
-//		OverWeekExcelData[i].ContainerMemAvg = MemWeekData.ContainerAvg
-//		OverWeekExcelData[i].Recommend = MailAppInfo.Recommend
-//	}
-//
-//	info.OverWeekExcelData = OverWeekExcelData
-//	//log.Println("2、OverWeekData", OverWeekData)
-//
-//	//pod<0.15,container<0.3
-//	var CpuBelowWeekData []model.CpuLimitWeekData
-//	//log.Println("查询3、CpuBelowWeekData")
-//
-//	AUTOMATED_DB.Table("container_cpu_limit_week_values as c").
-//		Select("c.group_name as group_name, round(avg(c.average*100),1) as container_avg, round(avg(p.average*100),1) as pod_avg").
-//		Joins("JOIN pod_cpu_limit_week_values as p ON c.group_name = p.group_name").
-//		Where("p.average >= ? AND p.average < ? AND c.average >= ? AND c.average < ? AND c.creat_time >= ?", 0.001, 0.15, 0.001, 0.3, time.Now().Format("2006-01-02")).
-//		Group("c.group_name").
-//		Order("c.average asc").
-//		Scan(&CpuBelowWeekData)
-//
-//	if err != nil {
-//		log.Println("err", err)
-//	}
-//
-//	//TODO 使用量
-//
-//	//log.Print("3、CpuBelowWeekData", CpuBelowWeekData)
-//	// 4、初始化BelowWeekExcelData切片，长度与CpuBelowWeekData相同
-//	BelowWeekExcelData := make([]model.BelowWeekExcelData, len(CpuBelowWeekData))
-//	for i, data := range CpuBelowWeekData {
-//		//根据group_name查application_name和systemname
-//		var MailAppInfo model.MailAppInfo
-//		AUTOMATED_DB.Table("app_groups ag").
-//			Joins("JOIN cims_systems_info c ON ag.cimsid=c.cimsid").
-//			Joins("LEFT JOIN agent_recommend_weeks arw ON ag.group_name = arw.group_name AND arw.creat_time >= ?", time.Now().Format("2006-01-02")).
-//			Select("ag.application_name as application_name,ag.group_name as group_name,c.systemname as system_name,arw.recommend as recommend").
-//			Where("ag.group_name =?", data.GroupName).Limit(1).Scan(&MailAppInfo)
-//		//fmt.Println("---MailAppInfo---", MailAppInfo)
-//
-//		var MemLimitWeekData model.MemLimitWeekData
-//		AUTOMATED_DB.Table("container_memory_limit_week_values c").
-//			Select("c.group_name as group_name, ROUND (avg(c.average * 100), 1)as container_avg, ROUND (avg(p.average * 100), 1)as pod_avg").
-//			Joins("JOIN pod_memory_limit_week_values p ON c.group_name = p.group_name").
-//			Where("c.group_name=? and c.creat_time >= ?", data.GroupName, time.Now().Format("2006-01-02")).
-//			Group("c.group_name").
-//			Order("c.average desc").
-//			Limit(10).Scan(&MemLimitWeekData)
-//		if err != nil {
-//			log.Println("err1", err)
-//		}
-//		BelowWeekExcelData[i].ContainerCpuAvg = data.ContainerAvg
-//		BelowWeekExcelData[i].PodCpuAvg = data.PodAvg
-//		BelowWeekExcelData[i].ContainerMemAvg = MemLimitWeekData.ContainerAvg
-//		BelowWeekExcelData[i].PodMemAvg = MemLimitWeekData.PodAvg
-//		BelowWeekExcelData[i].ApplicationName = MailAppInfo.ApplicationName
-//		BelowWeekExcelData[i].SystemName = MailAppInfo.SystemName
-//		BelowWeekExcelData[i].GroupName = MailAppInfo.GroupName
-//		BelowWeekExcelData[i].Recommend = MailAppInfo.Recommend
-//	}
-//	info.BelowWeekExcelData = BelowWeekExcelData
-//	//log.Print("4、BelowWeekData", BelowWeekData)
-//
-//	log.Println("5、info", info)
-//
-//	return info
-//}
-
-func DailySendEmail1() {
-	// 测试验证数据
-	toReceiverList := []string{"1", "2"}
-	ccReceiverList := []string{"1", "2"}
-	mailTitle := "FinOps系统资源使用分析报告"
-	// 定义模板文件路径
-	fmt.Println("==========")
-	log.Println("==========")
-
-	var info MailTotalDataInfo
-	var week1 = OverWeekData{
-		ApplicationName: "finops",
-		SystemName:      "gp18ar",
-		GroupName:       "ops",
-		ContainerCpuAvg: 0.1,
-		PodCpuAvg:       0.2,
-		ContainerMemAvg: 0.3,
-		PodMemAvg:       0.4,
-	}
-	var week3 = OverWeekData{
-		ApplicationName: "finops",
-		SystemName:      "gp18ar",
-		GroupName:       "ops",
-		ContainerCpuAvg: 0.1,
-		PodCpuAvg:       0.2,
-		ContainerMemAvg: 0.3,
-		PodMemAvg:       0.4,
-	}
-	var week2 = BelowWeekData{
-		ApplicationName: "finops2",
-		SystemName:      "gp18ar2",
-		GroupName:       "ops2",
-		ContainerCpuAvg: 0.5,
-		PodCpuAvg:       0.6,
-	}
-	info.OverWeekData = append(info.OverWeekData, week1)
-	info.OverWeekData = append(info.OverWeekData, week3)
-	info.BelowWeekData = append(info.BelowWeekData, week2)
-
-	htmlBody := renderHTML("template/finops_table_new.html", info)
-	// 构建带数据的Excel附件
-	templatePath := "template/FinOps.xlsx"
-
-	var info1 MailExcelDataInfo
-	var week4 = OverWeekExcelData{
-		ApplicationName: "finops",
-		SystemName:      "gp18ar",
-		GroupName:       "ops",
-		ContainerCpuAvg: 0.1,
-		PodCpuAvg:       0.2,
-		ContainerMemAvg: 0.3,
-		PodMemAvg:       0.4,
-	}
-	var week5 = OverWeekExcelData{
-		ApplicationName: "finops",
-		SystemName:      "gp18ar",
-		GroupName:       "ops",
-		ContainerCpuAvg: 0.1,
-		PodCpuAvg:       0.2,
-		ContainerMemAvg: 0.3,
-		PodMemAvg:       0.4,
-	}
-	var week6 = BelowWeekExcelData{
-		ApplicationName: "finops2",
-		SystemName:      "gp18ar2",
-		GroupName:       "ops2",
-		PodId:           "222",
-		ContainerCpuAvg: 0.5,
-		PodCpuAvg:       0.6,
-	}
-	info1.OverWeekExcelData = append(info1.OverWeekExcelData, week4)
-	info1.OverWeekExcelData = append(info1.OverWeekExcelData, week5)
-	info1.BelowWeekExcelData = append(info1.BelowWeekExcelData, week6)
-
-	fileName, encodedFile, err := CreateExcelAttachmentWithData(templatePath, info1)
-	fmt.Println("===================")
-	if err != nil {
-		fmt.Println("构建Excel附件失败:", err)
-		// 回退到普通附件
-		fileName, encodedFile, err = ConstructAttachment(templatePath)
-		if err != nil {
-			fmt.Println("回退附件失败:", err)
+// trendSheet 与上周相比的变化，模板中没有时自动创建
+const trendSheet = "较上周变化"
+
+// excelStatsMetrics 工作表中展示分位数的指标
+var excelStatsMetrics = []struct {
+	metric string
+	name   string
+}{
+	{MetricCpuLimit, "CPU"},
+	{MetricMemLimit, "内存"},
+}
+
+// excelColumns 分类工作表中按数据决定是否出现的列
+type excelColumns struct {
+	pod   bool // 部署组名后的Pod ID列
+	stats bool // 使用率后的container分位数和峰值
+	trend bool // 建议前的较上周变化和连续周数
+	cost  bool // 建议前的费用估算
+	size  bool // 建议前的request/limit建议值
+}
+
+// writeExcelSection 在工作表中写入表头和记录
+// 记录带有pod明细时在部署组名后增加Pod ID列，pod行紧跟在部署组下方并折叠为下一级
+// 记录带有趋势、费用估算时在建议前增加对应的列，有费用时末尾增加合计行
+func writeExcelSection(f *excelize.File, sheet string, headers []string, records []UsageRecord) error {
+	var columns excelColumns
+	for _, item := range records {
+		columns.pod = columns.pod || len(item.Pods) > 0
+		columns.stats = columns.stats || len(item.Stats) > 0
+		columns.trend = columns.trend || item.Trend != nil
+		columns.cost = columns.cost || item.Cost != nil
+		columns.size = columns.size || item.Recommendation.Resources() != ""
+	}
+	last := len(headers) - 1
+	extra := append([]string(nil), headers[:last]...)
+	if columns.stats {
+		for _, metric := range excelStatsMetrics {
+			for _, stat := range []string{"P50", "P95", "P99", "峰值"} {
+				extra = append(extra, fmt.Sprintf("%s Container %s(%%)", metric.name, stat))
+			}
+		}
+	}
+	if columns.trend {
+		extra = append(extra, "CPU较上周(%)", "内存较上周(%)", "连续周数")
+	}
+	if columns.cost {
+		extra = append(extra, "估算副本数", "周浪费", "可节省")
+	}
+	if columns.size {
+		extra = append(extra, "建议CPU request", "建议CPU limit", "建议内存 request", "建议内存 limit")
+	}
+	headers = append(extra, headers[last])
+	if columns.pod {
+		headers = append(headers[:3:3], append([]string{"Pod ID"}, headers[3:]...)...)
+		// 汇总行在明细上方
+		summaryBelow := false
+		if err := f.SetSheetProps(sheet, &excelize.SheetPropsOptions{OutlineSummaryBelow: &summaryBelow}); err != nil {
+			return fmt.Errorf("设置工作表属性失败: %v", err)
 		}
 	}
 
-	SendEmail2(toReceiverList, ccReceiverList, mailTitle, fileName, encodedFile, nil, nil, nil, htmlBody)
+	startRow := 1
+	if err := writeExcelRow(f, sheet, startRow, toCells(headers)); err != nil {
+		return fmt.Errorf("设置表头失败: %v", err)
+	}
+
+	row := startRow
+	var total CostEstimate
+	for _, item := range records {
+		row++
+		if err := writeExcelRow(f, sheet, row, excelRowData(item, columns)); err != nil {
+			return fmt.Errorf("写入数据失败: %v", err)
+		}
+		if item.Cost != nil {
+			total.WeeklyWaste += item.Cost.WeeklyWaste
+			total.PotentialSavings += item.Cost.PotentialSavings
+		}
+		for _, pod := range item.Pods {
+			row++
+			if err := writeExcelRow(f, sheet, row, excelRowData(pod, columns)); err != nil {
+				return fmt.Errorf("写入数据失败: %v", err)
+			}
+			if err := f.SetRowOutlineLevel(sheet, row, 1); err != nil {
+				return fmt.Errorf("设置分组失败: %v", err)
+			}
+			if err := f.SetRowVisible(sheet, row, false); err != nil {
+				return fmt.Errorf("设置分组失败: %v", err)
+			}
+		}
+	}
+	if columns.cost {
+		// 合计行的费用与上方的费用列对齐
+		costColumn := len(headers) - 3
+		if columns.size {
+			costColumn -= 4
+		}
+		totalRow := make([]interface{}, costColumn+2)
+		totalRow[0] = "合计"
+		totalRow[costColumn] = roundTo(total.WeeklyWaste, 2)
+		totalRow[costColumn+1] = roundTo(total.PotentialSavings, 2)
+		if err := writeExcelRow(f, sheet, row+1, totalRow); err != nil {
+			return fmt.Errorf("写入合计失败: %v", err)
+		}
+	}
+	return nil
 }
 
-func SendEmail2(toReceiverList, ccReceiverList []string, mailTitle, fileName string, encodedFile []byte, imageDict map[string][2]string, fileList []string, mailServer *MailServerConfig, htmlBody string) bool {
-	// 设置邮件服务器配置
-	var (
-		smtpServer string
-		smtpPort   int
-		user       string
-		passwd     string
-		alias      string
+// excelRowData 记录在工作表中的一行，与writeExcelSection的表头对应
+func excelRowData(item UsageRecord, columns excelColumns) []interface{} {
+	rowData := []interface{}{item.SystemName, item.ApplicationName, item.GroupName}
+	if columns.pod {
+		rowData = append(rowData, item.PodId)
+	}
+	rowData = append(rowData,
+		item.ContainerCpuAvg,
+		item.PodCpuAvg,
+		item.ContainerMemAvg,
+		item.PodMemAvg,
 	)
+	if columns.stats {
+		for _, metric := range excelStatsMetrics {
+			if stats, ok := item.Stats[statsKey(metric.metric, ScopeContainer)]; ok {
+				rowData = append(rowData, stats.P50, stats.P95, stats.P99, stats.Max)
+			} else {
+				rowData = append(rowData, "", "", "", "")
+			}
+		}
+	}
+	if columns.trend {
+		rowData = append(rowData, excelTrendData(item.Trend)...)
+	}
+	if columns.cost {
+		if item.Cost != nil {
+			rowData = append(rowData, item.Cost.Replicas, item.Cost.WeeklyWaste, item.Cost.PotentialSavings)
+		} else {
+			rowData = append(rowData, "", "", "")
+		}
+	}
+	if columns.size {
+		rowData = append(rowData, excelSizeData(item.Recommendation)...)
+	}
+	return append(rowData, item.Recommend)
+}
 
-	if mailServer != nil {
-		smtpServer = mailServer.SMTPServer
-		smtpPort = mailServer.SMTPPort
-		user = mailServer.User
-		passwd = mailServer.Password
-		alias = mailServer.Alias
-	} else {
-		// 默认配置
-		smtpServer = "21.0.0.76"
-		smtpPort = 25
-		user = "RPA@cpic.com.cn"
-		passwd = "SXzdh@YsL719"
-		alias = "寿险运维自动化"
-	}
-
-	// 构建邮件头部
-	from := mail.Address{Name: alias, Address: user}
-	to := make([]string, 0)
-	receiverList := append(toReceiverList, ccReceiverList...)
-	for _, addr := range receiverList {
-		to = append(to, addr)
-	}
-
-	var buffer bytes.Buffer
-	// 邮件头部
-	buffer.WriteString(fmt.Sprintf("From: %s\n", from.String()))
-	buffer.WriteString(fmt.Sprintf("To: %s\n", strings.Join(toReceiverList, ";")))
-	if len(ccReceiverList) > 0 {
-		buffer.WriteString(fmt.Sprintf("Cc: %s\n", strings.Join(ccReceiverList, ";")))
-	}
-	buffer.WriteString(fmt.Sprintf("Subject: %s\n", encodeSubject(mailTitle)))
-	buffer.WriteString("MIME-Version: 1.0\n")
-	buffer.WriteString("Content-Type: text/html; charset=UTF-8\n")
-	// 添加一个空行分隔头部和正文
-	buffer.WriteString("\n")
-	buffer.WriteString(htmlBody)
-	//TODO 附件excel
-	buffer.WriteString("Content-Type: application/vnd.ms-excel\r\n")
-	buffer.WriteString("Content-Transfer-Encoding: base64\r\n")
-	buffer.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"\r\n", fileName))
-	buffer.WriteString("\r\n")
-	buffer.Write(encodedFile)
-	buffer.WriteString("\r\n")
-
-	// 发送邮件
-	auth := LoginAuth(user, passwd)
-
-	smtpAddr := fmt.Sprintf("%s:%d", smtpServer, smtpPort)
-
-	err := smtp.SendMail(smtpAddr, auth, user, to, buffer.Bytes())
-	if err != nil {
-		fmt.Println(fmt.Sprintf("邮件发送失败：%v", err))
-		return false
+// excelSizeData 建议的CPU、内存request和limit，以Kubernetes的格式表示
+func excelSizeData(r *Recommendation) []interface{} {
+	cells := []interface{}{"", "", "", ""}
+	if r == nil || r.Source != RecommendBuiltin {
+		return cells
+	}
+	if r.CpuRequest > 0 {
+		cells[0], cells[1] = CpuQuantity(r.CpuRequest), CpuQuantity(r.CpuLimit)
+	}
+	if r.MemoryRequest > 0 {
+		cells[2], cells[3] = MemoryQuantity(r.MemoryRequest), MemoryQuantity(r.MemoryLimit)
+	}
+	return cells
+}
+
+// writeExcelRow 从A列开始写入一行，nil的单元格跳过
+func writeExcelRow(f *excelize.File, sheet string, row int, cells []interface{}) error {
+	for colIndex, cellData := range cells {
+		if cellData == nil {
+			continue
+		}
+		colName, err := excelize.ColumnNumberToName(colIndex + 1)
+		if err != nil {
+			return fmt.Errorf("获取列名失败: %v", err)
+		}
+		if err := f.SetCellValue(sheet, colName+fmt.Sprintf("%d", row), cellData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toCells(values []string) []interface{} {
+	cells := make([]interface{}, len(values))
+	for i, v := range values {
+		cells[i] = v
+	}
+	return cells
+}
+
+// excelTrendData 较上周CPU、内存的变化及连续周数，上周没有数据时变化量留空
+func excelTrendData(t *Trend) []interface{} {
+	if t == nil {
+		return []interface{}{"", "", ""}
+	}
+	if !t.HasPrevious() {
+		return []interface{}{"", "", t.Streak}
+	}
+	return []interface{}{t.CpuDelta, t.MemDelta, t.Streak}
+}
+
+// writeExcelChanges 写入与上周相比新增和已恢复正常的部署组
+func writeExcelChanges(f *excelize.File, sheet string, data MailExcelDataInfo) error {
+	if index, err := f.GetSheetIndex(sheet); err != nil || index < 0 {
+		if _, err := f.NewSheet(sheet); err != nil {
+			return fmt.Errorf("创建工作表失败: %v", err)
+		}
+	}
+	headers := []string{"变化", "系统名称", "应用名称", "部署组名", "上周", "本周", "CPU Container(%)", "内存 Container(%)", "CPU较上周(%)", "内存较上周(%)"}
+	if err := writeExcelRow(f, sheet, 1, toCells(headers)); err != nil {
+		return fmt.Errorf("设置表头失败: %v", err)
 	}
+	row := 1
+	for _, change := range []struct {
+		label   string
+		records []UsageRecord
+	}{
+		{"新增", data.NewlyFlagged},
+		{"已恢复", data.Resolved},
+	} {
+		for _, item := range change.records {
+			row++
+			previous := "无数据"
+			if item.Trend.HasPrevious() {
+				previous = item.Trend.PreviousCategory.Label()
+			}
+			trend := excelTrendData(item.Trend)
+			rowData := []interface{}{change.label, item.SystemName, item.ApplicationName, item.GroupName, previous, item.Category.Label(), item.ContainerCpuAvg, item.ContainerMemAvg, trend[0], trend[1]}
+			if err := writeExcelRow(f, sheet, row, rowData); err != nil {
+				return fmt.Errorf("写入数据失败: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// bodyRecordLimit 邮件正文中每类最多列出的部署组数
+const bodyRecordLimit = 10
+
+// GenerateData 生成邮件正文数据，每类只取前10个部署组，忽略opts.Limit
+// 同时需要Excel数据时应调用GenerateExcelData后用Truncate截取，避免重复查询
+func GenerateData(ctx context.Context, source MetricsSource, rules *RuleEngine, w ReportWindow, opts ReportOptions) (MailTotalDataInfo, error) {
+	opts.Limit = bodyRecordLimit
+	return BuildReport(ctx, source, rules, w, opts)
+}
 
-	fmt.Println("邮件发送成功")
-	return true
+// GenerateExcelData 生成Excel附件数据，包含全部超过和低于阈值的部署组，忽略opts.Limit
+func GenerateExcelData(ctx context.Context, source MetricsSource, rules *RuleEngine, w ReportWindow, opts ReportOptions) (MailExcelDataInfo, error) {
+	opts.Limit = 0
+	return BuildReport(ctx, source, rules, w, opts)
 }
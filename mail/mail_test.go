@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// sampleReport 超过阈值、低于阈值各一个部署组的报告
+func sampleReport() Report {
+	report := Report{StartTime: "2025-05-26", EndTime: "2025-06-01"}
+	report.Add(CategoryOver, UsageRecord{SystemName: "gp18ar", ApplicationName: "finops", GroupName: "finops-api", ContainerCpuAvg: 72, PodCpuAvg: 55, ContainerMemAvg: 60, PodMemAvg: 50, Recommend: "建议CPU limit调整为4核"})
+	report.Add(CategoryBelow, UsageRecord{SystemName: "claims", ApplicationName: "claims-portal", GroupName: "claims-batch", ContainerCpuAvg: 12, PodCpuAvg: 8})
+	report.Section(CategoryOver).Rule = "container CPU使用率 >= 60%"
+	return report
+}
+
+func TestReportSections(t *testing.T) {
+	report := sampleReport()
+	report.Add(CategoryOver, UsageRecord{GroupName: "finops-job", Category: CategoryBelow})
+	if len(report.Sections) != 2 {
+		t.Fatalf("got %d sections, want 2", len(report.Sections))
+	}
+	over := report.Records(CategoryOver)
+	if names := groupNames(over); strings.Join(names, ",") != "finops-api,finops-job" {
+		t.Errorf("over = %v", names)
+	}
+	// Add按段落设置分类，覆盖记录中原有的值
+	for _, r := range over {
+		if r.Category != CategoryOver {
+			t.Errorf("%s category = %s, want over", r.GroupName, r.Category)
+		}
+	}
+	if report.Section(CategoryNormal) != nil || report.Records(CategoryNormal) != nil || report.Rule(CategoryNormal) != "" {
+		t.Error("missing section should be empty")
+	}
+	// 模板和旧代码使用的访问方法与Records一致
+	if len(report.OverWeekData()) != 2 || len(report.BelowWeekExcelData()) != 1 || report.OverRule() != "container CPU使用率 >= 60%" || report.BelowRule() != "" {
+		t.Errorf("legacy accessors = %d/%d/%q/%q", len(report.OverWeekData()), len(report.BelowWeekExcelData()), report.OverRule(), report.BelowRule())
+	}
+}
+
+func TestRenderReportTemplate(t *testing.T) {
+	html := renderTestHTML(t, sampleReport())
+	for _, want := range []string{"2025-05-26 到 2025-06-01", "资源使用超过阈值（container CPU使用率 &gt;= 60%）", "建议CPU limit调整为4核", "CPU资源使用低于阈值（container: &lt; 30% pod: &lt; 15%）"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("rendered HTML does not contain %q", want)
+		}
+	}
+	// 每个部署组出现在所属分类的表格中
+	api, belowTitle, batch := strings.Index(html, "finops-api"), strings.Index(html, "低于阈值"), strings.Index(html, "claims-batch")
+	if api < 0 || batch < 0 || !(api < belowTitle && belowTitle < batch) {
+		t.Errorf("rows are not in their sections: finops-api@%d, below title@%d, claims-batch@%d", api, belowTitle, batch)
+	}
+}
+
+func TestCreateExcelAttachmentWithData(t *testing.T) {
+	name, data, err := CreateExcelAttachmentWithData("../template/FinOps.xlsx", sampleReport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "FinOps_系统资源使用分析数据.xlsx" {
+		t.Errorf("file name = %q", name)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tests := []struct {
+		sheet string
+		rows  [][]string
+	}{
+		{"CPU资源使用率超过阈值", [][]string{
+			{"系统名称", "应用名称", "部署组名", "CPU Container(%)", "CPU Pod(%)", "内存 Container(%)", "内存 Pod(%)", "建议"},
+			{"gp18ar", "finops", "finops-api", "72", "55", "60", "50", "建议CPU limit调整为4核"},
+		}},
+		{"CPU资源使用率低于阈值", [][]string{
+			{"系统名称", "应用名称", "组名", "CPU Container(%)", "CPU Pod(%)", "内存 Container(%)", "内存 Pod(%)", "建议"},
+			{"claims", "claims-portal", "claims-batch", "12", "8", "0", "0"},
+		}},
+	}
+	for _, tt := range tests {
+		rows, err := f.GetRows(tt.sheet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != len(tt.rows) {
+			t.Fatalf("%s: got %d rows, want %d: %v", tt.sheet, len(rows), len(tt.rows), rows)
+		}
+		for i := range rows {
+			if strings.Join(rows[i], "|") != strings.Join(tt.rows[i], "|") {
+				t.Errorf("%s row %d = %v, want %v", tt.sheet, i+1, rows[i], tt.rows[i])
+			}
+		}
+	}
+}
+
+func TestBuildReportPodDetails(t *testing.T) {
+	source := &FileMetricsSource{Paths: []string{"testdata/pod_samples.csv"}}
+	rules := NewRuleEngine(RulesConfig{}, defaultConfig().Thresholds)
+	report, err := BuildReport(context.Background(), source, rules, fixtureWindow, ReportOptions{PodDetails: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只有低于阈值的部署组附带pod明细，明细行继承部署组的应用信息和分类
+	if over := report.Records(CategoryOver); len(over) != 1 || over[0].Pods != nil {
+		t.Errorf("over = %+v, want finops-api without pods", over)
+	}
+	below := report.Records(CategoryBelow)
+	if len(below) != 1 || len(below[0].Pods) != 1 {
+		t.Fatalf("below = %+v, want claims-batch with one pod", below)
+	}
+	pod := below[0].Pods[0]
+	if pod.PodId != "c1" || pod.ApplicationName != "claims-portal" || pod.SystemName != "claims" || pod.Category != CategoryBelow || pod.PodCpuAvg != 5 {
+		t.Errorf("pod = %+v", pod)
+	}
+
+	plain, err := BuildReport(context.Background(), source, rules, fixtureWindow, ReportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pods := plain.Records(CategoryBelow)[0].Pods; pods != nil {
+		t.Errorf("pods without PodDetails = %+v", pods)
+	}
+}
+
+func TestPodDrillDownOutput(t *testing.T) {
+	report := sampleReport()
+	below := report.Section(CategoryBelow)
+	below.Records[0].Pods = []UsageRecord{
+		{GroupName: "claims-batch", PodId: "c1", PodCpuAvg: 3},
+		{GroupName: "claims-batch", PodId: "c2", PodCpuAvg: 13},
+	}
+
+	html := renderTestHTML(t, report)
+	if !strings.Contains(html, "claims-batch 共2个pod明细") || !strings.Contains(html, "<td>c2</td>") {
+		t.Error("rendered HTML does not contain the pod details")
+	}
+
+	_, data, err := CreateExcelAttachmentWithData("../template/FinOps.xlsx", report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sheet := "CPU资源使用率低于阈值"
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 部署组行之后是折叠的pod行，Pod ID列插在组名之后
+	if len(rows) != 4 || rows[0][3] != "Pod ID" || rows[1][2] != "claims-batch" || rows[1][3] != "" || rows[2][3] != "c1" || rows[3][3] != "c2" {
+		t.Fatalf("rows = %v", rows)
+	}
+	for row, want := range map[int]bool{2: true, 3: false, 4: false} {
+		visible, err := f.GetRowVisible(sheet, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		level, err := f.GetRowOutlineLevel(sheet, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visible != want || (level == 1) == want {
+			t.Errorf("row %d visible = %v, outline level = %d", row, visible, level)
+		}
+	}
+	// 没有pod明细的工作表保持原有的列
+	overRows, err := f.GetRows("CPU资源使用率超过阈值")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overRows[0][3] != "CPU Container(%)" {
+		t.Errorf("over header = %v", overRows[0])
+	}
+}
+
+func TestTrendExcelOutput(t *testing.T) {
+	rules := NewRuleEngine(RulesConfig{}, defaultConfig().Thresholds)
+	report, err := BuildReport(context.Background(), trendSource(), rules, fixtureWindow, ReportOptions{TrendWeeks: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := CreateExcelAttachmentWithData("../template/FinOps.xlsx", report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// 趋势列插在建议之前，上周没有数据时变化量留空
+	rows, err := f.GetRows("CPU资源使用率超过阈值")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(rows[0][7:], ","); got != "CPU较上周(%),内存较上周(%),连续周数,建议" {
+		t.Errorf("header = %v", rows[0])
+	}
+	if got := strings.Join(rows[1][2:10], ","); got != "d,80,60,30,0,,,1" {
+		t.Errorf("d row = %v", rows[1])
+	}
+	if got := strings.Join(rows[2][2:10], ","); got != "a,70,50,40,0,5,4.5,3" {
+		t.Errorf("a row = %v", rows[2])
+	}
+
+	changes, err := f.GetRows(trendSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"变化,系统名称,应用名称,部署组名,上周,本周,CPU Container(%),内存 Container(%),CPU较上周(%),内存较上周(%)",
+		"新增,sys,app-d,d,无数据,超过阈值,80,30",
+		"新增,sys,app-b,b,正常,低于阈值,10,20,-30,0",
+		"已恢复,sys,app-c,c,超过阈值,正常,40,50,-35,0",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v", changes)
+	}
+	for i := range want {
+		if got := strings.Join(changes[i], ","); got != want[i] {
+			t.Errorf("changes row %d = %q, want %q", i+1, got, want[i])
+		}
+	}
+}
+
+func TestStatsExcelColumns(t *testing.T) {
+	report := sampleReport()
+	report.Section(CategoryOver).Records[0].Stats = map[string]Stats{
+		statsKey(MetricCpuLimit, ScopeContainer): {P50: 70, P95: 88, P99: 93, Max: 97},
+	}
+	_, data, err := CreateExcelAttachmentWithData("../template/FinOps.xlsx", report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows("CPU资源使用率超过阈值")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 分位数列在使用率之后，没有内存分位数时留空
+	if got := strings.Join(rows[0][7:15], ","); got != "CPU Container P50(%),CPU Container P95(%),CPU Container P99(%),CPU Container 峰值(%),内存 Container P50(%),内存 Container P95(%),内存 Container P99(%),内存 Container 峰值(%)" {
+		t.Errorf("header = %v", rows[0])
+	}
+	if got := strings.Join(rows[1][7:], ","); got != "70,88,93,97,,,,,建议CPU limit调整为4核" {
+		t.Errorf("row = %v", rows[1])
+	}
+	if html := renderTestHTML(t, report); !strings.Contains(html, "88 / 97") {
+		t.Error("rendered HTML does not contain the P95 / peak column")
+	}
+}
+
+// renderTestHTML 使用仓库中的模板渲染报告正文
+func renderTestHTML(t *testing.T, data Report) string {
+	t.Helper()
+	html, err := RenderReportHTML("../template/finops_table_new.html", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return html
+}
+
+// useConfig 测试期间将GlobalConfig替换为cfg
+func useConfig(t *testing.T, cfg *Config) {
+	t.Helper()
+	previous := GlobalConfig
+	GlobalConfig = cfg
+	t.Cleanup(func() { GlobalConfig = previous })
+}
+
+// testConfig 使用仓库中模板的默认配置，daily报告通过smtp.example.com发送给ops@example.com
+func testConfig() *Config {
+	cfg := defaultConfig()
+	cfg.SMTP.Host = "smtp.example.com"
+	cfg.Templates = TemplateConfig{HTML: "../template/finops_table_new.html", Excel: "../template/FinOps.xlsx"}
+	cfg.Reports[DailyReport] = ReportConfig{To: []string{"ops@example.com"}}
+	return cfg
+}
+
+func TestPrepareReportQueriesOnce(t *testing.T) {
+	// 低于阈值的部署组超过sqlInChunkSize，应用信息需要分两批查询
+	groups := sqlInChunkSize + 100
+	cfg := testConfig()
+	cfg.Database = DatabaseConfig{Driver: "sqlite-counting", DSN: writeManyGroupsFixture(t, groups)}
+	useConfig(t, cfg)
+
+	before := queryCounter.queries.Load()
+	report, err := PrepareReport(context.Background(), DailyReport, fixtureWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 四项指标各查询平均值和container、pod分位数，应用信息按sqlInChunkSize分批
+	want := int64(4*3 + (groups+sqlInChunkSize)/sqlInChunkSize)
+	if got := queryCounter.queries.Load() - before; got != want {
+		t.Errorf("PrepareReport() ran %d queries, want %d", got, want)
+	}
+	below := report.Excel.Records(CategoryBelow)
+	if len(below) != groups+1 {
+		t.Errorf("Excel has %d below groups, want %d", len(below), groups+1)
+	}
+	if n := len(report.Info.Records(CategoryBelow)); n != bodyRecordLimit {
+		t.Errorf("body has %d below groups, want %d", n, bodyRecordLimit)
+	}
+	for _, r := range below {
+		if r.ApplicationName == "" {
+			t.Fatalf("%s has no application info", r.GroupName)
+		}
+	}
+}
+
+func TestPrepareReportImages(t *testing.T) {
+	chart := filepath.Join(t.TempDir(), "cpu_trend.png")
+	if err := os.WriteFile(chart, pngHeader, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.Database = DatabaseConfig{Driver: "sqlite", DSN: writeManyGroupsFixture(t, 0)}
+	report := cfg.Reports[DailyReport]
+	report.Images = []ReportImageConfig{{Path: chart, Title: "CPU使用率趋势"}}
+	cfg.Reports[DailyReport] = report
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, cfg)
+
+	prepared, err := PrepareReport(context.Background(), DailyReport, fixtureWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := prepared.Message(&MailServerConfig{User: "rpa@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, parts := parseMessage(t, msg)
+	var html string
+	var image *mimePart
+	for i, p := range parts {
+		switch partPath(p) {
+		case "multipart/mixed > multipart/related > text/html":
+			html = string(p.body)
+		case "multipart/mixed > multipart/related > image/png":
+			image = &parts[i]
+		}
+	}
+	if image == nil {
+		t.Fatalf("no image inside multipart/related: %v", parts)
+	}
+	if got := image.header.Get("Content-Id"); got != "<cpu_trend.png>" {
+		t.Errorf("Content-ID = %q, want <cpu_trend.png>", got)
+	}
+	if !bytes.Equal(image.body, pngHeader) {
+		t.Errorf("image body = %q", image.body)
+	}
+	if !strings.Contains(html, `src="cid:cpu_trend.png"`) || !strings.Contains(html, "CPU使用率趋势") {
+		t.Errorf("HTML does not reference the image:\n%s", html)
+	}
+}
+
+func TestValidateReportImages(t *testing.T) {
+	chart := filepath.Join(t.TempDir(), "chart.png")
+	if err := os.WriteFile(chart, pngHeader, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	report := cfg.Reports[DailyReport]
+	report.Images = []ReportImageConfig{{Path: chart}, {Path: chart}, {Path: chart, CID: "a b"}, {}}
+	cfg.Reports[DailyReport] = report
+	err := cfg.Validate()
+	for _, key := range []string{"reports.daily.images[1].cid", "reports.daily.images[2].cid", "reports.daily.images[3].path"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("Validate() = %v, want an error for %s", err, key)
+		}
+	}
+	if err != nil && strings.Contains(err.Error(), "images[0]") {
+		t.Errorf("Validate() rejected a valid image: %v", err)
+	}
+}
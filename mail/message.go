@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message 结构化的邮件，由Bytes按RFC 5322/2045构建为MIME报文
+type Message struct {
+	From        mail.Address
+	To          []string
+	Cc          []string
+	Bcc         []string // 只出现在信封中，不写入邮件头
+	Subject     string
+	HTML        string
+	Text        string
+	Attachments []Attachment
+	Inline      []Attachment      // 通过Content-ID被HTML正文引用的内嵌资源
+	Headers     map[string]string // 额外的邮件头
+	MessageID   string            // 为空时在首次构建时生成并保存，之后多次构建保持不变
+	Date        time.Time         // 为零值时在构建时取当前时间
+}
+
+// Attachment 邮件附件或内嵌资源
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string // 仅内嵌资源使用，不含尖括号
+	Data        []byte
+}
+
+// EmbedImage 注册内存中的图片为内嵌资源，HTML正文中通过cid:<cid>引用
+func (m *Message) EmbedImage(cid, filename string, data []byte) {
+	m.Inline = append(m.Inline, Attachment{
+		Filename:    filename,
+		ContentType: detectContentType(filename, data),
+		ContentID:   cid,
+		Data:        data,
+	})
+}
+
+// EmbedImageFile 读取图片文件并注册为内嵌资源
+func (m *Message) EmbedImageFile(cid, imagePath string) error {
+	mimeType, data, err := constructMIMEImage(cid, imagePath)
+	if err != nil {
+		return err
+	}
+	m.Inline = append(m.Inline, Attachment{
+		Filename:    filepath.Base(imagePath),
+		ContentType: mimeType,
+		ContentID:   cid,
+		Data:        data,
+	})
+	return nil
+}
+
+// Attach 添加内存中的附件，contentType为空时根据文件名和内容自动判断
+func (m *Message) Attach(filename string, data []byte, contentType string) {
+	if contentType == "" {
+		contentType = detectContentType(filename, data)
+	}
+	m.Attachments = append(m.Attachments, Attachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Data:        data,
+	})
+}
+
+// AttachFile 读取文件并添加为附件
+func (m *Message) AttachFile(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("读取附件%s失败: %w", filePath, err)
+	}
+	m.Attach(filepath.Base(filePath), data, "")
+	return nil
+}
+
+// AttachReader 从io.Reader读取附件内容
+// 内容会被完整读入内存，以便同一封邮件可以重复构建(如失败重发)
+func (m *Message) AttachReader(filename string, r io.Reader, contentType string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("读取附件%s失败: %w", filename, err)
+	}
+	m.Attach(filename, data, contentType)
+	return nil
+}
+
+// clone 深拷贝邮件，修改副本的收件人、附件或邮件头不会影响原邮件
+func (m *Message) clone() *Message {
+	cp := *m
+	cp.To = append([]string(nil), m.To...)
+	cp.Cc = append([]string(nil), m.Cc...)
+	cp.Bcc = append([]string(nil), m.Bcc...)
+	cp.Attachments = cloneAttachments(m.Attachments)
+	cp.Inline = cloneAttachments(m.Inline)
+	if m.Headers != nil {
+		cp.Headers = make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			cp.Headers[k] = v
+		}
+	}
+	return &cp
+}
+
+func cloneAttachments(attachments []Attachment) []Attachment {
+	if attachments == nil {
+		return nil
+	}
+	cp := make([]Attachment, len(attachments))
+	for i, a := range attachments {
+		a.Data = append([]byte(nil), a.Data...)
+		cp[i] = a
+	}
+	return cp
+}
+
+// Recipients 返回信封收件人(To+Cc+Bcc)，有无法解析的地址时返回错误
+func (m *Message) Recipients() ([]string, error) {
+	rcpts := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	for _, list := range [][]string{m.To, m.Cc, m.Bcc} {
+		for _, addr := range list {
+			rcpt, err := envelopeAddress(addr)
+			if err != nil {
+				return nil, err
+			}
+			rcpts = append(rcpts, rcpt)
+		}
+	}
+	return rcpts, nil
+}
+
+// Bytes 构建完整的邮件报文(头部+正文)，行尾统一为CRLF
+func (m *Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.writeTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeTo 写出报文，MessageID为空时生成并保存到m，重复构建和重试时Message-ID不变
+func (m *Message) writeTo(w io.Writer) error {
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		if !validHeaderKey(k) {
+			return fmt.Errorf("无效的邮件头名称%q", k)
+		}
+		keys = append(keys, textproto.CanonicalMIMEHeaderKey(k))
+	}
+	sort.Strings(keys)
+	to, err := formatAddressList(m.To)
+	if err != nil {
+		return err
+	}
+	cc, err := formatAddressList(m.Cc)
+	if err != nil {
+		return err
+	}
+	if m.MessageID == "" {
+		m.MessageID = generateMessageID(m.From.Address)
+	}
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	h := newHeaderWriter(w)
+	h.write("From", m.From.String())
+	if to != "" {
+		h.write("To", to)
+	}
+	if cc != "" {
+		h.write("Cc", cc)
+	}
+	h.write("Subject", encodeHeader(m.Subject))
+	h.write("Date", date.Format(time.RFC1123Z))
+	h.write("Message-ID", "<"+m.MessageID+">")
+	h.write("MIME-Version", "1.0")
+
+	for _, k := range keys {
+		if reservedHeaders[k] {
+			continue
+		}
+		h.write(k, encodeHeader(headerValue(m.Headers, k)))
+	}
+
+	root := m.mimeTree()
+	for _, k := range sortedKeys(root.header) {
+		h.write(k, root.header.Get(k))
+	}
+	if h.err != nil {
+		return h.err
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+	return root.writeBody(w)
+}
+
+var reservedHeaders = map[string]bool{
+	"From": true, "To": true, "Cc": true, "Bcc": true, "Subject": true, "Date": true,
+	"Message-Id": true, "Mime-Version": true, "Content-Type": true, "Content-Transfer-Encoding": true,
+}
+
+// validHeaderKey 邮件头名称只能由除冒号外的可打印ASCII字符组成(RFC 5322 2.2)
+func validHeaderKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c < '!' || c > '~' || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func headerValue(headers map[string]string, canonicalKey string) string {
+	for k, v := range headers {
+		if textproto.CanonicalMIMEHeaderKey(k) == canonicalKey {
+			return v
+		}
+	}
+	return ""
+}
+
+// mimeTree 构建正文结构：
+//
+//	multipart/mixed
+//	├── multipart/related
+//	│   ├── multipart/alternative
+//	│   │   ├── text/plain
+//	│   │   └── text/html
+//	│   └── 内嵌资源...
+//	└── 附件...
+//
+// 只有一个子节点的层级会被省略
+func (m *Message) mimeTree() *mimeNode {
+	var bodies []*mimeNode
+	if m.Text != "" || m.HTML == "" {
+		bodies = append(bodies, textNode("text/plain", m.Text))
+	}
+	if m.HTML != "" {
+		bodies = append(bodies, textNode("text/html", m.HTML))
+	}
+	body := multipartNode("alternative", bodies)
+
+	if len(m.Inline) > 0 {
+		related := []*mimeNode{body}
+		for _, a := range m.Inline {
+			related = append(related, attachmentNode(a, true))
+		}
+		body = multipartNode("related", related)
+	}
+
+	if len(m.Attachments) > 0 {
+		mixed := []*mimeNode{body}
+		for _, a := range m.Attachments {
+			mixed = append(mixed, attachmentNode(a, false))
+		}
+		body = multipartNode("mixed", mixed)
+	}
+	return body
+}
+
+// mimeNode MIME树中的一个实体
+type mimeNode struct {
+	header   textproto.MIMEHeader
+	boundary string
+	children []*mimeNode
+	content  []byte
+}
+
+func textNode(contentType, text string) *mimeNode {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"charset": "UTF-8"}))
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	return &mimeNode{header: h, content: []byte(text)}
+}
+
+func attachmentNode(a Attachment, inline bool) *mimeNode {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h := textproto.MIMEHeader{}
+	typeParams := map[string]string{}
+	if a.Filename != "" {
+		// name参数沿用RFC 2047编码，兼容只识别该参数的旧客户端
+		typeParams["name"] = encodeHeader(a.Filename)
+	}
+	h.Set("Content-Type", mime.FormatMediaType(contentType, typeParams))
+	h.Set("Content-Transfer-Encoding", "base64")
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+		h.Set("Content-Id", "<"+a.ContentID+">")
+	}
+	dispParams := map[string]string{}
+	if a.Filename != "" {
+		// 非ASCII文件名按RFC 2231编码为filename*=utf-8''...
+		dispParams["filename"] = a.Filename
+	}
+	h.Set("Content-Disposition", mime.FormatMediaType(disposition, dispParams))
+	return &mimeNode{header: h, content: a.Data}
+}
+
+func multipartNode(subtype string, children []*mimeNode) *mimeNode {
+	if len(children) == 1 {
+		return children[0]
+	}
+	boundary := randomBoundary()
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", fmt.Sprintf("multipart/%s; boundary=%q", subtype, boundary))
+	return &mimeNode{header: h, boundary: boundary, children: children}
+}
+
+func (n *mimeNode) writeBody(w io.Writer) error {
+	if n.boundary == "" {
+		return n.writeContent(w)
+	}
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(n.boundary); err != nil {
+		return err
+	}
+	for _, child := range n.children {
+		pw, err := mw.CreatePart(child.header)
+		if err != nil {
+			return err
+		}
+		if err := child.writeBody(pw); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func (n *mimeNode) writeContent(w io.Writer) error {
+	switch n.header.Get("Content-Transfer-Encoding") {
+	case "quoted-printable":
+		qw := quotedprintable.NewWriter(w)
+		if _, err := qw.Write(n.content); err != nil {
+			return err
+		}
+		return qw.Close()
+	default:
+		lw := &lineWrapper{w: w, width: 76}
+		enc := base64.NewEncoder(base64.StdEncoding, lw)
+		if _, err := enc.Write(n.content); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		return lw.finish()
+	}
+}
+
+// lineWrapper 每width个字符插入一次CRLF，用于base64按76列折行
+type lineWrapper struct {
+	w     io.Writer
+	width int
+	col   int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := l.width - l.col
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.col += n
+		p = p[n:]
+		if l.col == l.width {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.col = 0
+		}
+	}
+	return written, nil
+}
+
+func (l *lineWrapper) finish() error {
+	if l.col == 0 {
+		return nil
+	}
+	l.col = 0
+	_, err := io.WriteString(l.w, "\r\n")
+	return err
+}
+
+// attachmentTypes 系统MIME库中常缺失的办公文件类型
+var attachmentTypes = map[string]string{
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".xls":  "application/vnd.ms-excel",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".doc":  "application/msword",
+	".csv":  "text/csv",
+	".pdf":  "application/pdf",
+	".zip":  "application/zip",
+	".txt":  "text/plain",
+}
+
+// detectContentType 先按扩展名，再按文件内容判断附件的MIME类型
+func detectContentType(filename string, data []byte) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if contentType, ok := attachmentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(data)
+}
+
+// headerWriter 逐行写邮件头，记录第一个错误
+type headerWriter struct {
+	w   io.Writer
+	err error
+}
+
+func newHeaderWriter(w io.Writer) *headerWriter {
+	return &headerWriter{w: w}
+}
+
+func (h *headerWriter) write(key, value string) {
+	if h.err != nil {
+		return
+	}
+	_, h.err = fmt.Fprintf(h.w, "%s: %s\r\n", key, foldHeader(value))
+}
+
+// foldHeader 在编码字之间折行，避免单行超过78个字符
+func foldHeader(value string) string {
+	return strings.ReplaceAll(value, "?= =?", "?=\r\n =?")
+}
+
+// encodeHeader 对含非ASCII字符的头部值做RFC 2047编码
+func encodeHeader(value string) string {
+	return mime.BEncoding.Encode("UTF-8", value)
+}
+
+// formatAddressList 格式化收件人列表，一行放不下时在", "处折行，收件人很多时每行也不会超过998个字符
+// 无法解析的地址返回错误而不是原样输出，避免地址中的换行被用来注入邮件头
+func formatAddressList(list []string) (string, error) {
+	var b strings.Builder
+	lineLen := len("To: ")
+	for i, addr := range list {
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil {
+			return "", fmt.Errorf("无效的邮箱地址%q: %w", addr, err)
+		}
+		formatted := parsed.String()
+		if i > 0 {
+			b.WriteString(",")
+			lineLen++
+			if lineLen+1+len(formatted) > maxHeaderLineLen {
+				b.WriteString("\r\n ")
+				lineLen = 1
+			} else {
+				b.WriteString(" ")
+				lineLen++
+			}
+		}
+		b.WriteString(formatted)
+		lineLen += len(formatted)
+	}
+	return b.String(), nil
+}
+
+// maxHeaderLineLen 邮件头折行的目标行长(RFC 5322 2.1.1建议不超过78个字符)
+const maxHeaderLineLen = 78
+
+// envelopeAddress 取出地址中用于SMTP信封的部分
+func envelopeAddress(addr string) (string, error) {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil {
+		return "", fmt.Errorf("无效的邮箱地址%q: %w", addr, err)
+	}
+	return parsed.Address, nil
+}
+
+func sortedKeys(h textproto.MIMEHeader) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func randomBoundary() string {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return "finops_" + hex.EncodeToString(b[:])
+}
+
+func generateMessageID(from string) string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 && i < len(from)-1 {
+		domain = from[i+1:]
+	} else if hostname, err := os.Hostname(); err == nil {
+		domain = hostname
+	}
+	return fmt.Sprintf("%d.%s@%s", time.Now().UnixNano(), hex.EncodeToString(b[:]), domain)
+}
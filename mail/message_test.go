@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mimePart 解析后的叶子实体，path为从根到该实体的Content-Type
+type mimePart struct {
+	path   []string
+	header textproto.MIMEHeader
+	body   []byte // 已按Content-Transfer-Encoding解码
+}
+
+// parseMessage 构建并解析报文，返回邮件头和所有叶子实体
+func parseMessage(t *testing.T, msg *Message) (*mail.Message, []mimePart) {
+	t.Helper()
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, line := range strings.Split(string(raw), "\r\n") {
+		if strings.Contains(line, "\n") {
+			t.Fatalf("line %d contains a bare LF: %q", i+1, line)
+		}
+		if len(line) > 998 {
+			t.Fatalf("line %d is longer than 998 characters", i+1)
+		}
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(parsed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed, collectParts(t, nil, textproto.MIMEHeader(parsed.Header), body)
+}
+
+func collectParts(t *testing.T, path []string, header textproto.MIMEHeader, body []byte) []mimePart {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Content-Type %q: %v", header.Get("Content-Type"), err)
+	}
+	path = append(append([]string(nil), path...), mediaType)
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return []mimePart{{path: path, header: header, body: decodeBody(t, header, body)}}
+	}
+	var parts []mimePart
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		// NextRawPart不解码quoted-printable，由decodeBody统一处理
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, collectParts(t, path, part.Header, content)...)
+	}
+	return parts
+}
+
+func decodeBody(t *testing.T, header textproto.MIMEHeader, body []byte) []byte {
+	t.Helper()
+	switch header.Get("Content-Transfer-Encoding") {
+	case "base64":
+		for _, line := range strings.Split(strings.TrimRight(string(body), "\r\n"), "\r\n") {
+			if len(line) > 76 {
+				t.Errorf("base64 line longer than 76 characters: %d", len(line))
+			}
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(body), "\r\n", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decoded
+	case "quoted-printable":
+		for _, line := range strings.Split(string(body), "\r\n") {
+			if len(line) > 76 {
+				t.Errorf("quoted-printable line longer than 76 characters: %q", line)
+			}
+		}
+		decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decoded
+	}
+	return body
+}
+
+func partPath(p mimePart) string {
+	return strings.Join(p.path, " > ")
+}
+
+func TestMessageHeaders(t *testing.T) {
+	msg := &Message{
+		From:      mail.Address{Name: "寿险运维自动化", Address: "rpa@example.com"},
+		To:        []string{"张三 <zhangsan@example.com>", "ops@example.com"},
+		Cc:        []string{"finops@example.com"},
+		Bcc:       []string{"audit@example.com"},
+		Subject:   "FinOps系统资源使用分析报告",
+		Text:      "hello",
+		Headers:   map[string]string{"x-report": "daily", "Bcc": "leak@example.com"},
+		MessageID: "fixed@example.com",
+		Date:      time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC),
+	}
+	parsed, _ := parseMessage(t, msg)
+	h := parsed.Header
+
+	dec := new(mime.WordDecoder)
+	if subject, err := dec.DecodeHeader(h.Get("Subject")); err != nil || subject != msg.Subject {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, msg.Subject)
+	}
+	from, err := h.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Name != "寿险运维自动化" || from[0].Address != "rpa@example.com" {
+		t.Errorf("From = %v (%v)", from, err)
+	}
+	to, err := h.AddressList("To")
+	if err != nil || len(to) != 2 || to[0].Name != "张三" || to[1].Address != "ops@example.com" {
+		t.Errorf("To = %v (%v)", to, err)
+	}
+	if h.Get("Cc") != "<finops@example.com>" {
+		t.Errorf("Cc = %q", h.Get("Cc"))
+	}
+	if h.Get("Bcc") != "" {
+		t.Errorf("Bcc must not be written to the headers, got %q", h.Get("Bcc"))
+	}
+	if h.Get("X-Report") != "daily" {
+		t.Errorf("X-Report = %q", h.Get("X-Report"))
+	}
+	if h.Get("Message-Id") != "<fixed@example.com>" || h.Get("Date") != "Mon, 02 Jun 2025 09:00:00 +0000" {
+		t.Errorf("Message-ID = %q, Date = %q", h.Get("Message-Id"), h.Get("Date"))
+	}
+	if h.Get("Mime-Version") != "1.0" {
+		t.Errorf("MIME-Version = %q", h.Get("Mime-Version"))
+	}
+
+	rcpts, err := msg.Recipients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"zhangsan@example.com", "ops@example.com", "finops@example.com", "audit@example.com"}
+	if strings.Join(rcpts, ",") != strings.Join(want, ",") {
+		t.Errorf("Recipients() = %v, want %v", rcpts, want)
+	}
+}
+
+func TestMessageIDIsStable(t *testing.T) {
+	msg := &Message{From: mail.Address{Address: "rpa@example.com"}, To: []string{"ops@example.com"}, Text: "hi"}
+	first, _ := parseMessage(t, msg)
+	second, _ := parseMessage(t, msg)
+	id := first.Header.Get("Message-Id")
+	if !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("generated Message-ID = %q", id)
+	}
+	// 生成的Message-ID保存在msg中，之后的构建保持不变
+	if msg.MessageID == "" || "<"+msg.MessageID+">" != id {
+		t.Errorf("MessageID = %q, header %q", msg.MessageID, id)
+	}
+	if second.Header.Get("Message-Id") != id {
+		t.Errorf("Message-ID changed between builds: %q, %q", id, second.Header.Get("Message-Id"))
+	}
+
+	// 构建失败时不生成
+	invalid := &Message{From: mail.Address{Address: "rpa@example.com"}, To: []string{"not an address"}, Text: "hi"}
+	if _, err := invalid.Bytes(); err == nil || invalid.MessageID != "" {
+		t.Errorf("Bytes() = %v, MessageID = %q", err, invalid.MessageID)
+	}
+}
+
+func TestMessageRejectsInvalidHeaderKeys(t *testing.T) {
+	for _, key := range []string{"X-Report:Bcc", "X-Report\r\nBcc", "X Report", "X-Rep\x00ort", "X-报告", ""} {
+		msg := &Message{From: mail.Address{Address: "rpa@example.com"}, To: []string{"ops@example.com"}, Text: "hi", Headers: map[string]string{key: "v"}}
+		if raw, err := msg.Bytes(); err == nil {
+			t.Errorf("Bytes() accepted header key %q:\n%s", key, raw)
+		}
+	}
+	msg := &Message{From: mail.Address{Address: "rpa@example.com"}, To: []string{"ops@example.com"}, Text: "hi", Headers: map[string]string{"x-finops-report": "daily\r\nBcc: victim@example.com"}}
+	parsed, _ := parseMessage(t, msg)
+	if parsed.Header.Get("Bcc") != "" || parsed.Header.Get("X-Finops-Report") == "" {
+		t.Errorf("header = %v", parsed.Header)
+	}
+}
+
+func TestMessageFoldsLongAddressLists(t *testing.T) {
+	var to []string
+	for i := 0; i < 200; i++ {
+		to = append(to, fmt.Sprintf("收件人%d <user%03d@example.com>", i, i))
+	}
+	msg := &Message{From: mail.Address{Address: "rpa@example.com"}, To: to, Cc: to[:50], Text: "hi"}
+	// parseMessage检查每行不超过998个字符
+	parsed, _ := parseMessage(t, msg)
+	for _, field := range []string{"To", "Cc"} {
+		list, err := parsed.Header.AddressList(field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := len(to)
+		if field == "Cc" {
+			want = 50
+		}
+		if len(list) != want || list[want-1].Address != fmt.Sprintf("user%03d@example.com", want-1) || list[1].Name != "收件人1" {
+			t.Errorf("%s has %d addresses, want %d", field, len(list), want)
+		}
+	}
+	raw, _ := msg.Bytes()
+	header, _, _ := strings.Cut(string(raw), "\r\n\r\n")
+	for _, line := range strings.Split(header, "\r\n") {
+		if len(line) > maxHeaderLineLen {
+			t.Errorf("header line longer than %d characters: %q", maxHeaderLineLen, line)
+		}
+	}
+}
+
+func TestMessageRejectsInvalidAddresses(t *testing.T) {
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		msg := &Message{From: mail.Address{Address: "rpa@example.com"}, To: []string{"ops@example.com"}, Text: "hi"}
+		injected := []string{"ops@example.com\r\nBcc: victim@example.com"}
+		switch field {
+		case "To":
+			msg.To = injected
+		case "Cc":
+			msg.Cc = injected
+		case "Bcc":
+			msg.Bcc = injected
+		}
+		if _, err := msg.Recipients(); err == nil {
+			t.Errorf("%s: Recipients() accepted %q", field, injected[0])
+		}
+		if raw, err := msg.Bytes(); field != "Bcc" && err == nil {
+			t.Errorf("%s: Bytes() accepted %q:\n%s", field, injected[0], raw)
+		}
+	}
+}
+
+func TestMessageBodyStructure(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   Message
+		paths []string
+	}{
+		{
+			name:  "text only",
+			msg:   Message{Text: "plain"},
+			paths: []string{"text/plain"},
+		},
+		{
+			name:  "empty body",
+			msg:   Message{},
+			paths: []string{"text/plain"},
+		},
+		{
+			name:  "html only",
+			msg:   Message{HTML: "<p>html</p>"},
+			paths: []string{"text/html"},
+		},
+		{
+			name:  "alternative",
+			msg:   Message{Text: "plain", HTML: "<p>html</p>"},
+			paths: []string{"multipart/alternative > text/plain", "multipart/alternative > text/html"},
+		},
+		{
+			name: "attachment",
+			msg:  Message{HTML: "<p>html</p>", Attachments: []Attachment{{Filename: "a.csv", ContentType: "text/csv", Data: []byte("a,b\n")}}},
+			paths: []string{
+				"multipart/mixed > text/html",
+				"multipart/mixed > text/csv",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.msg
+			msg.From = mail.Address{Address: "rpa@example.com"}
+			msg.To = []string{"ops@example.com"}
+			_, parts := parseMessage(t, &msg)
+			var paths []string
+			for _, p := range parts {
+				paths = append(paths, partPath(p))
+			}
+			if strings.Join(paths, "\n") != strings.Join(tt.paths, "\n") {
+				t.Errorf("parts =\n%s\nwant\n%s", strings.Join(paths, "\n"), strings.Join(tt.paths, "\n"))
+			}
+		})
+	}
+}
+
+func TestMessageQuotedPrintableBody(t *testing.T) {
+	html := "<table>" + strings.Repeat("<tr><td>部署组finops-api的CPU使用率为72%</td></tr>", 20) + "</table>\n结尾=等号 "
+	msg := &Message{From: mail.Address{Address: "rpa@example.com"}, To: []string{"ops@example.com"}, HTML: html}
+	_, parts := parseMessage(t, msg)
+	if len(parts) != 1 {
+		t.Fatalf("got %d parts, want 1", len(parts))
+	}
+	p := parts[0]
+	if p.header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+		t.Errorf("Content-Transfer-Encoding = %q", p.header.Get("Content-Transfer-Encoding"))
+	}
+	if _, params, _ := mime.ParseMediaType(p.header.Get("Content-Type")); params["charset"] != "UTF-8" {
+		t.Errorf("Content-Type = %q, want charset=UTF-8", p.header.Get("Content-Type"))
+	}
+	if got := string(p.body); got != strings.ReplaceAll(html, "\n", "\r\n") {
+		t.Errorf("decoded body differs from HTML:\n%q", got)
+	}
+}
+
+// pngHeader 足以让内容识别为image/png的最小数据
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestMessageInlineImages(t *testing.T) {
+	dir := t.TempDir()
+	chart := filepath.Join(dir, "trend.png")
+	if err := os.WriteFile(chart, pngHeader, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{
+		From: mail.Address{Address: "rpa@example.com"},
+		To:   []string{"ops@example.com"},
+		Text: "plain",
+		HTML: `<img src="cid:logo"><img src="cid:trend">`,
+	}
+	msg.EmbedImage("logo", "logo", pngHeader)
+	if err := msg.EmbedImageFile("trend", chart); err != nil {
+		t.Fatal(err)
+	}
+	msg.Attach("report.xlsx", []byte("xlsx"), "")
+	if err := msg.EmbedImageFile("missing", filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("EmbedImageFile() accepted a missing file")
+	}
+
+	_, parts := parseMessage(t, msg)
+	var paths []string
+	for _, p := range parts {
+		paths = append(paths, partPath(p))
+	}
+	want := []string{
+		"multipart/mixed > multipart/related > multipart/alternative > text/plain",
+		"multipart/mixed > multipart/related > multipart/alternative > text/html",
+		"multipart/mixed > multipart/related > image/png",
+		"multipart/mixed > multipart/related > image/png",
+		"multipart/mixed > application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	}
+	if strings.Join(paths, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("parts =\n%s\nwant\n%s", strings.Join(paths, "\n"), strings.Join(want, "\n"))
+	}
+	for i, cid := range []string{"logo", "trend"} {
+		p := parts[2+i]
+		if got := p.header.Get("Content-Id"); got != "<"+cid+">" {
+			t.Errorf("image %d Content-ID = %q, want <%s>", i, got, cid)
+		}
+		if disposition, _, _ := mime.ParseMediaType(p.header.Get("Content-Disposition")); disposition != "inline" {
+			t.Errorf("image %d Content-Disposition = %q", i, p.header.Get("Content-Disposition"))
+		}
+		if !bytes.Equal(p.body, pngHeader) {
+			t.Errorf("image %d body = %q", i, p.body)
+		}
+	}
+	if _, params, _ := mime.ParseMediaType(parts[3].header.Get("Content-Disposition")); params["filename"] != "trend.png" {
+		t.Errorf("file image filename = %q", params["filename"])
+	}
+}
+
+func TestBuildReportMessageImages(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.png", "b.png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), pngHeader, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := &MailServerConfig{User: "rpa@example.com", Alias: "FinOps"}
+	imageDict := map[string][2]string{
+		"zeta":  {filepath.Join(dir, "a.png"), ""},
+		"alpha": {filepath.Join(dir, "b.png"), "图表.png"},
+	}
+	msg, err := buildReportMessage([]string{"ops@example.com"}, nil, "报告", "", nil, imageDict, nil, server, `<img src="cid:alpha">`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// imageDict按cid排序嵌入，第二项为显示的文件名
+	if len(msg.Inline) != 2 || msg.Inline[0].ContentID != "alpha" || msg.Inline[0].Filename != "图表.png" || msg.Inline[1].Filename != "a.png" {
+		t.Errorf("Inline = %+v", msg.Inline)
+	}
+
+	if err := embedReportImages(msg, []ReportImage{{CID: "mem", Data: pngHeader}, {CID: "file", Path: filepath.Join(dir, "b.png")}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Inline) != 4 || msg.Inline[2].Filename != "mem" || msg.Inline[3].Filename != "b.png" || msg.Inline[3].ContentType != "image/png" {
+		t.Errorf("Inline after embedReportImages = %+v", msg.Inline)
+	}
+	if err := embedReportImages(msg, []ReportImage{{CID: "missing", Path: filepath.Join(dir, "missing.png")}}); err == nil {
+		t.Error("embedReportImages() accepted a missing file")
+	}
+}
+
+func TestMessageAttachmentFilenames(t *testing.T) {
+	msg := &Message{From: mail.Address{Address: "rpa@example.com"}, To: []string{"ops@example.com"}, Text: "plain"}
+	msg.Attach("FinOps_系统资源使用分析数据.xlsx", []byte("xlsx"), "")
+	msg.Attach("plain name.csv", []byte("a,b\n"), "")
+	if err := msg.AttachReader("备注.txt", strings.NewReader("说明"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 非ASCII文件名在Content-Disposition中按RFC 2231编码，ASCII文件名保持原样
+	if !strings.Contains(string(raw), `filename*=utf-8''FinOps_%E7%B3%BB%E7%BB%9F`) {
+		t.Errorf("RFC 2231 filename* parameter not found in:\n%s", raw)
+	}
+	if !strings.Contains(string(raw), `filename="plain name.csv"`) {
+		t.Errorf("quoted ASCII filename not found in:\n%s", raw)
+	}
+
+	_, parts := parseMessage(t, msg)
+	if len(parts) != 4 {
+		t.Fatalf("got %d parts, want 4", len(parts))
+	}
+	dec := new(mime.WordDecoder)
+	for i, want := range []struct {
+		filename, contentType, body string
+	}{
+		{"FinOps_系统资源使用分析数据.xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx"},
+		{"plain name.csv", "text/csv", "a,b\n"},
+		{"备注.txt", "text/plain", "说明"},
+	} {
+		p := parts[i+1]
+		disposition, params, err := mime.ParseMediaType(p.header.Get("Content-Disposition"))
+		if err != nil || disposition != "attachment" || params["filename"] != want.filename {
+			t.Errorf("attachment %d Content-Disposition = %q (%v), want filename %q", i, p.header.Get("Content-Disposition"), err, want.filename)
+		}
+		contentType, typeParams, err := mime.ParseMediaType(p.header.Get("Content-Type"))
+		if err != nil || contentType != want.contentType {
+			t.Errorf("attachment %d Content-Type = %q (%v), want %s", i, p.header.Get("Content-Type"), err, want.contentType)
+		}
+		// name参数为RFC 2047编码，供只识别name的旧客户端使用
+		if name, err := dec.DecodeHeader(typeParams["name"]); err != nil || name != want.filename {
+			t.Errorf("attachment %d name = %q (%v), want %q", i, typeParams["name"], err, want.filename)
+		}
+		if string(p.body) != want.body {
+			t.Errorf("attachment %d body = %q, want %q", i, p.body, want.body)
+		}
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     []byte
+		want     string
+	}{
+		{"report.XLSX", nil, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"legacy.xls", nil, "application/vnd.ms-excel"},
+		{"summary.pdf", nil, "application/pdf"},
+		{"chart.png", nil, "image/png"},
+		{"chart", pngHeader, "image/png"},
+		{"notes", []byte("plain text"), "text/plain; charset=utf-8"},
+		{"blob.unknownext", []byte{0x00, 0x01, 0x02}, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		if got := detectContentType(tt.filename, tt.data); got != tt.want {
+			t.Errorf("detectContentType(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestBuildReportMessageFileList(t *testing.T) {
+	dir := t.TempDir()
+	pdf := filepath.Join(dir, "周报说明.pdf")
+	if err := os.WriteFile(pdf, []byte("%PDF-1.4"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server := &MailServerConfig{User: "rpa@example.com"}
+	msg, err := buildReportMessage([]string{"ops@example.com"}, nil, "报告", "FinOps.xlsx", []byte("xlsx"), nil, []string{pdf}, server, "<p>html</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Attachments) != 2 {
+		t.Fatalf("got %d attachments, want 2", len(msg.Attachments))
+	}
+	if a := msg.Attachments[1]; a.Filename != "周报说明.pdf" || a.ContentType != "application/pdf" || string(a.Data) != "%PDF-1.4" {
+		t.Errorf("file attachment = %+v", a)
+	}
+
+	if _, err := buildReportMessage([]string{"ops@example.com"}, nil, "报告", "", nil, nil, []string{filepath.Join(dir, "missing.pdf")}, server, ""); err == nil {
+		t.Error("buildReportMessage() accepted a missing attachment")
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ReportWindow 报告统计的时间窗口，按天计，包含Start和End两天
+type ReportWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// DefaultReportWindow 截止到昨天的最近一周，与原先的统计口径一致
+func DefaultReportWindow(now time.Time) ReportWindow {
+	return ReportWindow{
+		Start: now.Add(-24 * 7 * time.Hour),
+		End:   now.Add(-24 * time.Hour),
+	}
+}
+
+// StartTime 开始日期，格式为2006-01-02
+func (w ReportWindow) StartTime() string {
+	return w.Start.Format("2006-01-02")
+}
+
+// EndTime 结束日期，格式为2006-01-02
+func (w ReportWindow) EndTime() string {
+	return w.End.Format("2006-01-02")
+}
+
+// bounds 窗口对应的时间范围[Start当天0点, End次日0点)
+func (w ReportWindow) bounds() (start, end time.Time) {
+	start = time.Date(w.Start.Year(), w.Start.Month(), w.Start.Day(), 0, 0, 0, 0, w.Start.Location())
+	end = time.Date(w.End.Year(), w.End.Month(), w.End.Day(), 0, 0, 0, 0, w.End.Location()).AddDate(0, 0, 1)
+	return start, end
+}
+
+// Previous 向前平移weeks周的窗口
+func (w ReportWindow) Previous(weeks int) ReportWindow {
+	return ReportWindow{Start: w.Start.AddDate(0, 0, -7*weeks), End: w.End.AddDate(0, 0, -7*weeks)}
+}
+
+// MetricsSource 报告数据来源，返回窗口内各部署组的周平均值
+// groupNames为空时返回全部部署组，否则只返回指定的部署组
+type MetricsSource interface {
+	CpuLimitWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]CpuLimitWeekData, error)
+	MemLimitWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]MemLimitWeekData, error)
+	CpuCoreWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]CpuCoreWeekData, error)
+	MemAvgWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]MemAvgWeekData, error)
+	AppInfoSource
+}
+
+// PodMetricsSource 能提供pod明细的数据源，结果以部署组为key
+// 明细行的Pod*字段为该pod的平均值，Container*字段为该pod内容器的平均值
+type PodMetricsSource interface {
+	PodUsage(ctx context.Context, w ReportWindow, groupNames []string) (map[string][]UsageRecord, error)
+}
+
+// AppInfoSource 应用信息来源
+type AppInfoSource interface {
+	// AppInfos 批量查询部署组所属的应用、系统及本周的调整建议，结果以部署组为key，找不到的部署组不在结果中
+	AppInfos(ctx context.Context, w ReportWindow, groupNames []string) (map[string]MailAppInfo, error)
+}
+
+// OpenMetricsSource 按metrics.source创建数据源，使用完毕后若实现了io.Closer需要关闭
+func (c *Config) OpenMetricsSource(ctx context.Context) (MetricsSource, error) {
+	switch c.Metrics.Source {
+	case "", "sql":
+		return newSQLMetricsSource(ctx, c.Database)
+	case "prometheus":
+		// 应用信息仍然从数据库查询，未配置数据库时报告中只有部署组名
+		var apps *SQLMetricsSource
+		if c.Database.Driver != "" {
+			var err error
+			if apps, err = newSQLMetricsSource(ctx, c.Database); err != nil {
+				return nil, err
+			}
+		}
+		source, err := NewPrometheusMetricsSource(c.Metrics.Prometheus, nil)
+		if err != nil {
+			if apps != nil {
+				apps.Close()
+			}
+			return nil, err
+		}
+		if apps != nil {
+			source.Apps = apps
+		}
+		return source, nil
+	case "file":
+		return &FileMetricsSource{Paths: c.Metrics.File.Paths}, nil
+	}
+	return nil, &ConfigError{Key: "metrics.source", Msg: fmt.Sprintf("不支持的数据源%q", c.Metrics.Source)}
+}
+
+// collectUsage 汇总各项指标，以CPU limit数据中的部署组为准
+func collectUsage(ctx context.Context, source MetricsSource, w ReportWindow) ([]UsageRecord, error) {
+	cpuLimit, err := source.CpuLimitWeekData(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+	memLimit, err := source.MemLimitWeekData(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+	cpuCore, err := source.CpuCoreWeekData(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+	memAvg, err := source.MemAvgWeekData(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]UsageRecord, len(cpuLimit))
+	index := make(map[string]*UsageRecord, len(cpuLimit))
+	for i, data := range cpuLimit {
+		records[i] = UsageRecord{GroupName: data.GroupName, ContainerCpuAvg: data.ContainerAvg, PodCpuAvg: data.PodAvg}
+		records[i].setStats(MetricCpuLimit, data)
+		index[data.GroupName] = &records[i]
+	}
+	for _, data := range memLimit {
+		if r, ok := index[data.GroupName]; ok {
+			r.ContainerMemAvg, r.PodMemAvg = data.ContainerAvg, data.PodAvg
+			r.setStats(MetricMemLimit, data)
+		}
+	}
+	for _, data := range cpuCore {
+		if r, ok := index[data.GroupName]; ok {
+			r.ContainerCoreAvg, r.PodCoreAvg = data.ContainerAvg, data.PodAvg
+			r.setStats(MetricCpuCore, data)
+		}
+	}
+	for _, data := range memAvg {
+		if r, ok := index[data.GroupName]; ok {
+			r.ContainerMemValue, r.PodMemValue = data.ContainerAvg, data.PodAvg
+			r.setStats(MetricMemValue, data)
+		}
+	}
+	return records, nil
+}
+
+// setStats 记录指标的分位数，数据源没有提供时跳过
+func (r *UsageRecord) setStats(metric string, data GroupWeekAvg) {
+	for scope, stats := range map[string]*Stats{ScopeContainer: data.Container, ScopePod: data.Pod} {
+		if stats == nil {
+			continue
+		}
+		if r.Stats == nil {
+			r.Stats = map[string]Stats{}
+		}
+		r.Stats[statsKey(metric, scope)] = *stats
+	}
+}
+
+// ReportOptions 生成报告的选项
+type ReportOptions struct {
+	Limit       int                // 每类最多保留的部署组数，0为不限
+	PodDetails  bool               // 为低于阈值的部署组附带pod明细，数据源需实现PodMetricsSource
+	TrendWeeks  int                // 与之前几周对比，0为不对比
+	Pricing     *PricingConfig     // 资源单价，为nil时不估算费用
+	Recommender *RecommenderConfig // 内置建议，为nil时只使用外部建议
+}
+
+// BuildReport 查询数据、按规则分类并补充应用信息
+func BuildReport(ctx context.Context, source MetricsSource, rules *RuleEngine, w ReportWindow, opts ReportOptions) (Report, error) {
+	report := Report{StartTime: w.StartTime(), EndTime: w.EndTime()}
+	records, err := collectUsage(ctx, source, w)
+	if err != nil {
+		return report, err
+	}
+	over, below := rules.Classify(records)
+	var history []map[string]UsageRecord
+	var newly, resolved []UsageRecord
+	if opts.TrendWeeks > 0 {
+		if history, err = collectHistory(ctx, source, rules, w, opts.TrendWeeks); err != nil {
+			return report, err
+		}
+		newly, resolved = trendChanges(records, rules, history[0])
+		report.TrendWeeks = opts.TrendWeeks
+	}
+	over, below = limitRecords(over, opts.Limit), limitRecords(below, opts.Limit)
+	newly, resolved = limitRecords(newly, opts.Limit), limitRecords(resolved, opts.Limit)
+
+	// 各类部署组的应用信息一次查出，避免逐个部署组查询
+	var names []string
+	for _, list := range [][]UsageRecord{over, below, newly, resolved} {
+		for _, r := range list {
+			names = append(names, r.GroupName)
+		}
+	}
+	infos, err := source.AppInfos(ctx, w, names)
+	if err != nil {
+		return report, err
+	}
+	if opts.PodDetails && len(below) > 0 {
+		if err := attachPodDetails(ctx, source, w, below); err != nil {
+			return report, err
+		}
+	}
+	if history != nil {
+		for _, list := range [][]UsageRecord{over, below, newly, resolved} {
+			applyTrend(list, rules, history)
+		}
+		fillAppInfo(newly, infos)
+		fillAppInfo(resolved, infos)
+		report.NewlyFlagged, report.Resolved = newly, resolved
+	}
+	for _, section := range []struct {
+		category Category
+		rule     RuleSet
+		records  []UsageRecord
+	}{
+		{CategoryOver, rules.Over, over},
+		{CategoryBelow, rules.Below, below},
+	} {
+		fillAppInfo(section.records, infos)
+		for i := range section.records {
+			for j := range section.records[i].Pods {
+				section.records[i].Pods[j].Category = section.category
+			}
+		}
+		if opts.Recommender != nil {
+			for i := range section.records {
+				opts.Recommender.Apply(&section.records[i])
+			}
+		}
+		if opts.Pricing != nil {
+			start, end := w.bounds()
+			hours := end.Sub(start).Hours()
+			for i := range section.records {
+				section.records[i].Cost = opts.Pricing.Estimate(section.records[i], hours)
+			}
+			report.Currency = opts.Pricing.Currency
+		}
+		report.Add(section.category, section.records...)
+		report.Section(section.category).Rule = section.rule.String()
+	}
+	return report, nil
+}
+
+// limitRecords 最多保留limit条记录，0为不限
+func limitRecords(records []UsageRecord, limit int) []UsageRecord {
+	if limit > 0 && len(records) > limit {
+		return records[:limit]
+	}
+	return records
+}
+
+// fillAppInfo 填充应用信息，pod明细行与所属部署组一致
+func fillAppInfo(records []UsageRecord, infos map[string]MailAppInfo) {
+	for i := range records {
+		info := infos[records[i].GroupName]
+		records[i].ApplicationName = info.ApplicationName
+		records[i].SystemName = info.SystemName
+		records[i].Recommend = info.Recommend
+		for j := range records[i].Pods {
+			records[i].Pods[j].ApplicationName, records[i].Pods[j].SystemName = info.ApplicationName, info.SystemName
+		}
+	}
+}
+
+// collectHistory 查询之前weeks周的数据并分类，第i项为向前i+1周，以部署组为key
+func collectHistory(ctx context.Context, source MetricsSource, rules *RuleEngine, w ReportWindow, weeks int) ([]map[string]UsageRecord, error) {
+	history := make([]map[string]UsageRecord, weeks)
+	for i := range history {
+		records, err := collectUsage(ctx, source, w.Previous(i+1))
+		if err != nil {
+			return nil, fmt.Errorf("查询%d周前的数据失败: %w", i+1, err)
+		}
+		history[i] = make(map[string]UsageRecord, len(records))
+		for _, r := range records {
+			r.Category = rules.Category(r)
+			history[i][r.GroupName] = r
+		}
+	}
+	return history, nil
+}
+
+// trendChanges 与上周相比新进入超过或低于阈值的部署组，以及从超过或低于阈值恢复正常的部署组
+// 从超过阈值变为低于阈值(或相反)计入新进入，本周没有数据的部署组不计入恢复
+func trendChanges(records []UsageRecord, rules *RuleEngine, lastWeek map[string]UsageRecord) (newly, resolved []UsageRecord) {
+	for _, r := range records {
+		r.Category = rules.Category(r)
+		previous := lastWeek[r.GroupName].Category
+		switch {
+		case r.Category != CategoryNormal && r.Category != previous:
+			newly = append(newly, r)
+		case r.Category == CategoryNormal && (previous == CategoryOver || previous == CategoryBelow):
+			resolved = append(resolved, r)
+		}
+	}
+	// 新进入的部署组与各分类段落的顺序一致：先超过阈值(降序)，再低于阈值(升序)
+	sort.SliceStable(newly, func(i, j int) bool {
+		if newly[i].Category != newly[j].Category {
+			return newly[i].Category == CategoryOver
+		}
+		if newly[i].Category == CategoryOver {
+			return newly[i].ContainerCpuAvg > newly[j].ContainerCpuAvg
+		}
+		return newly[i].ContainerCpuAvg < newly[j].ContainerCpuAvg
+	})
+	sort.SliceStable(resolved, func(i, j int) bool { return resolved[i].GroupName < resolved[j].GroupName })
+	return newly, resolved
+}
+
+// applyTrend 计算与上周的变化量及连续处于当前分类的周数
+func applyTrend(records []UsageRecord, rules *RuleEngine, history []map[string]UsageRecord) {
+	for i := range records {
+		r := &records[i]
+		category := rules.Category(*r)
+		trend := &Trend{Streak: 1}
+		if previous, ok := history[0][r.GroupName]; ok {
+			trend.PreviousCategory = previous.Category
+			trend.CpuDelta = roundTo(r.ContainerCpuAvg-previous.ContainerCpuAvg, 1)
+			trend.MemDelta = roundTo(r.ContainerMemAvg-previous.ContainerMemAvg, 1)
+		}
+		for _, week := range history {
+			previous, ok := week[r.GroupName]
+			if !ok || previous.Category != category {
+				break
+			}
+			trend.Streak++
+		}
+		r.Trend = trend
+	}
+}
+
+// attachPodDetails 查询pod明细并挂到对应部署组下，数据源不支持时忽略
+func attachPodDetails(ctx context.Context, source MetricsSource, w ReportWindow, records []UsageRecord) error {
+	podSource, ok := source.(PodMetricsSource)
+	if !ok {
+		return nil
+	}
+	names := make([]string, len(records))
+	for i, r := range records {
+		names[i] = r.GroupName
+	}
+	pods, err := podSource.PodUsage(ctx, w, names)
+	if err != nil {
+		return err
+	}
+	for i := range records {
+		records[i].Pods = pods[records[i].GroupName]
+		// 最空闲的pod排在前面
+		sort.SliceStable(records[i].Pods, func(a, b int) bool {
+			return records[i].Pods[a].PodCpuAvg < records[i].Pods[b].PodCpuAvg
+		})
+	}
+	return nil
+}
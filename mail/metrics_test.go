@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// weekSource 按窗口起始日期返回预设数据的内存数据源
+type weekSource struct {
+	weeks map[string][]UsageRecord
+}
+
+func (s *weekSource) records(w ReportWindow, groupNames []string) []UsageRecord {
+	var result []UsageRecord
+	for _, r := range s.weeks[w.StartTime()] {
+		if len(groupNames) == 0 || contains(groupNames, r.GroupName) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+func (s *weekSource) weekAvg(w ReportWindow, groupNames []string, value func(UsageRecord) (float64, float64)) []GroupWeekAvg {
+	var data []GroupWeekAvg
+	for _, r := range s.records(w, groupNames) {
+		container, pod := value(r)
+		data = append(data, GroupWeekAvg{GroupName: r.GroupName, ContainerAvg: container, PodAvg: pod})
+	}
+	return data
+}
+
+func (s *weekSource) CpuLimitWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]CpuLimitWeekData, error) {
+	return s.weekAvg(w, groupNames, func(r UsageRecord) (float64, float64) { return r.ContainerCpuAvg, r.PodCpuAvg }), nil
+}
+
+func (s *weekSource) MemLimitWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]MemLimitWeekData, error) {
+	return s.weekAvg(w, groupNames, func(r UsageRecord) (float64, float64) { return r.ContainerMemAvg, r.PodMemAvg }), nil
+}
+
+func (s *weekSource) CpuCoreWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]CpuCoreWeekData, error) {
+	return s.weekAvg(w, groupNames, func(r UsageRecord) (float64, float64) { return r.ContainerCoreAvg, r.PodCoreAvg }), nil
+}
+
+func (s *weekSource) MemAvgWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]MemAvgWeekData, error) {
+	return s.weekAvg(w, groupNames, func(r UsageRecord) (float64, float64) { return r.ContainerMemValue, r.PodMemValue }), nil
+}
+
+func (s *weekSource) AppInfos(ctx context.Context, w ReportWindow, groupNames []string) (map[string]MailAppInfo, error) {
+	infos := map[string]MailAppInfo{}
+	for _, name := range groupNames {
+		infos[name] = MailAppInfo{GroupName: name, ApplicationName: "app-" + name, SystemName: "sys"}
+	}
+	return infos, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// trendSource 本周及之前三周的数据：
+// a连续三周超过阈值；b本周新低于阈值；c上周超过阈值、本周恢复正常；d本周超过阈值、上周没有数据
+func trendSource() *weekSource {
+	week := func(n int) string { return fixtureWindow.Previous(n).StartTime() }
+	return &weekSource{weeks: map[string][]UsageRecord{
+		week(0): {
+			{GroupName: "a", ContainerCpuAvg: 70, PodCpuAvg: 50, ContainerMemAvg: 40},
+			{GroupName: "b", ContainerCpuAvg: 10, PodCpuAvg: 5, ContainerMemAvg: 20},
+			{GroupName: "c", ContainerCpuAvg: 40, PodCpuAvg: 30, ContainerMemAvg: 50},
+			{GroupName: "d", ContainerCpuAvg: 80, PodCpuAvg: 60, ContainerMemAvg: 30},
+		},
+		week(1): {
+			{GroupName: "a", ContainerCpuAvg: 65, PodCpuAvg: 45, ContainerMemAvg: 35.5},
+			{GroupName: "b", ContainerCpuAvg: 40, PodCpuAvg: 30, ContainerMemAvg: 20},
+			{GroupName: "c", ContainerCpuAvg: 75, PodCpuAvg: 55, ContainerMemAvg: 50},
+		},
+		week(2): {
+			{GroupName: "a", ContainerCpuAvg: 66, PodCpuAvg: 45, ContainerMemAvg: 30},
+		},
+		week(3): {
+			{GroupName: "a", ContainerCpuAvg: 40, PodCpuAvg: 30, ContainerMemAvg: 30},
+		},
+	}}
+}
+
+func TestBuildReportTrend(t *testing.T) {
+	rules := NewRuleEngine(RulesConfig{}, defaultConfig().Thresholds)
+	report, err := BuildReport(context.Background(), trendSource(), rules, fixtureWindow, ReportOptions{TrendWeeks: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TrendWeeks != 3 {
+		t.Errorf("TrendWeeks = %d", report.TrendWeeks)
+	}
+	over := report.Records(CategoryOver)
+	if names := groupNames(over); !reflect.DeepEqual(names, []string{"d", "a"}) {
+		t.Fatalf("over = %v, want [d a]", names)
+	}
+	// 第4周前a正常，连续周数只统计到此为止
+	if got, want := *over[1].Trend, (Trend{PreviousCategory: CategoryOver, CpuDelta: 5, MemDelta: 4.5, Streak: 3}); got != want {
+		t.Errorf("a trend = %+v, want %+v", got, want)
+	}
+	if got := over[0].Trend; got.HasPrevious() || got.Streak != 1 || got.CpuDeltaText() != "-" {
+		t.Errorf("d trend = %+v, want no previous week", got)
+	}
+
+	// 新进入的部署组先超过阈值后低于阈值，并补充了应用信息
+	if names := groupNames(report.NewlyFlagged); !reflect.DeepEqual(names, []string{"d", "b"}) {
+		t.Errorf("NewlyFlagged = %v, want [d b]", names)
+	}
+	b := report.NewlyFlagged[1]
+	if b.Category != CategoryBelow || b.ApplicationName != "app-b" || b.Trend.PreviousCategory != CategoryNormal || b.Trend.CpuDeltaText() != "-30.0" {
+		t.Errorf("b = %+v, trend %+v", b, b.Trend)
+	}
+	if names := groupNames(report.Resolved); !reflect.DeepEqual(names, []string{"c"}) {
+		t.Fatalf("Resolved = %v, want [c]", names)
+	}
+	if c := report.Resolved[0]; c.Category != CategoryNormal || c.Trend.PreviousCategory != CategoryOver || c.Trend.MemDeltaText() != "0.0" {
+		t.Errorf("c = %+v, trend %+v", c, c.Trend)
+	}
+
+	plain, err := BuildReport(context.Background(), trendSource(), rules, fixtureWindow, ReportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.TrendWeeks != 0 || plain.NewlyFlagged != nil || plain.Records(CategoryOver)[0].Trend != nil {
+		t.Error("trend computed without TrendWeeks")
+	}
+}
+
+func TestTrendDeltaText(t *testing.T) {
+	tests := []struct {
+		trend *Trend
+		want  string
+	}{
+		{nil, "-"},
+		{&Trend{Streak: 1}, "-"},
+		{&Trend{PreviousCategory: CategoryOver, CpuDelta: 3.46}, "+3.5"},
+		{&Trend{PreviousCategory: CategoryOver, CpuDelta: -2}, "-2.0"},
+		{&Trend{PreviousCategory: CategoryNormal}, "0.0"},
+	}
+	for _, tt := range tests {
+		if got := tt.trend.CpuDeltaText(); got != tt.want {
+			t.Errorf("CpuDeltaText(%+v) = %q, want %q", tt.trend, got, tt.want)
+		}
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	if computeStats(nil) != nil {
+		t.Error("computeStats(nil) should be nil")
+	}
+	if got := *computeStats([]float64{7}); got != (Stats{P50: 7, P95: 7, P99: 7, Max: 7}) {
+		t.Errorf("single value = %+v", got)
+	}
+	// 线性插值：1..101中第q*100个位置
+	values := make([]float64, 0, 101)
+	for i := 101; i >= 1; i-- {
+		values = append(values, float64(i))
+	}
+	if got := *computeStats(values); got != (Stats{P50: 51, P95: 96, P99: 100, Max: 101}) {
+		t.Errorf("1..101 = %+v", got)
+	}
+	if got := *computeStats([]float64{0.4, 0.1}).scaled(1, 6); got != (Stats{P50: 0.25, P95: 0.385, P99: 0.397, Max: 0.4}) {
+		t.Errorf("two values = %+v", got)
+	}
+	if got := *computeStats([]float64{0.123, 0.456}).scaled(100, 1); got != (Stats{P50: 29, P95: 43.9, P99: 45.3, Max: 45.6}) {
+		t.Errorf("scaled = %+v", got)
+	}
+}
+
+func TestUsageRecordStats(t *testing.T) {
+	r := UsageRecord{ContainerCpuAvg: 60, Stats: map[string]Stats{
+		statsKey(MetricCpuLimit, ScopeContainer): {P50: 55, P95: 88.5, P99: 93, Max: 97},
+	}}
+	tests := []struct {
+		metric, scope, stat string
+		want                float64
+		ok                  bool
+	}{
+		{MetricCpuLimit, ScopeContainer, "", 60, true},
+		{MetricCpuLimit, ScopeContainer, StatAvg, 60, true},
+		{MetricCpuLimit, ScopeContainer, StatP95, 88.5, true},
+		{MetricCpuLimit, ScopeContainer, StatMax, 97, true},
+		{MetricCpuLimit, ScopePod, StatP95, 0, false},
+		{MetricCpuLimit, ScopeContainer, "p90", 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := r.Value(tt.metric, tt.scope, tt.stat); got != tt.want || ok != tt.ok {
+			t.Errorf("Value(%s, %s, %q) = %v, %v, want %v, %v", tt.metric, tt.scope, tt.stat, got, ok, tt.want, tt.ok)
+		}
+	}
+	if got := r.PeakText(MetricCpuLimit); got != "88.5 / 97" {
+		t.Errorf("PeakText() = %q", got)
+	}
+	if got := r.PeakText(MetricMemLimit); got != "-" {
+		t.Errorf("PeakText() without stats = %q", got)
+	}
+}
+
+func TestBuildReportStats(t *testing.T) {
+	source := openFixtureSource(t)
+	rules := NewRuleEngine(RulesConfig{}, defaultConfig().Thresholds)
+	report, err := BuildReport(context.Background(), source, rules, fixtureWindow, ReportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.HasStats() {
+		t.Fatal("SQL source should provide stats")
+	}
+	api := report.Records(CategoryOver)[0]
+	if got := api.Stats[statsKey(MetricCpuLimit, ScopeContainer)]; got.Max != 72 {
+		t.Errorf("finops-api cpu_limit/container stats = %+v", got)
+	}
+	if got := api.Stats[statsKey(MetricCpuCore, ScopeContainer)]; got.Max != 2.88 {
+		t.Errorf("finops-api cpu_core/container stats = %+v", got)
+	}
+	if (Report{}).HasStats() {
+		t.Error("empty report should not have stats")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"path"
+)
+
+// PricingConfig 资源单价，用于把未使用的limit换算为费用
+// 部署组按Pools的顺序匹配，都不匹配时使用默认单价
+type PricingConfig struct {
+	Currency          string      `json:"currency"`
+	CpuHour           float64     `json:"cpu_hour"`           // 每vCPU小时
+	MemoryGiBHour     float64     `json:"memory_gib_hour"`    // 每GiB小时
+	TargetUtilization float64     `json:"target_utilization"` // 计算可节省费用时，limit调整到使用量/该值
+	Pools             []PricePool `json:"pools"`
+}
+
+// PricePool 集群或节点池的单价，Groups、Systems为部署组名、系统名的通配符，满足任一即匹配
+type PricePool struct {
+	Name          string   `json:"name"`
+	CpuHour       float64  `json:"cpu_hour"`
+	MemoryGiBHour float64  `json:"memory_gib_hour"`
+	Groups        []string `json:"groups"`
+	Systems       []string `json:"systems"`
+}
+
+// CostEstimate 部署组在报告窗口内的费用估算
+// 按container平均值估算单个副本的费用再乘以副本数，副本数取自pod明细，没有pod明细时按单个副本计算
+type CostEstimate struct {
+	Pool             string  `json:"pool,omitempty"`    // 匹配的单价，默认单价时为空
+	Replicas         int     `json:"replicas"`          // 估算使用的副本数
+	WeeklyWaste      float64 `json:"weekly_waste"`      // limit中未使用部分的费用
+	PotentialSavings float64 `json:"potential_savings"` // limit调整到目标使用率后可节省的费用
+}
+
+// Enabled 是否配置了单价
+func (c PricingConfig) Enabled() bool {
+	return c.CpuHour > 0 || c.MemoryGiBHour > 0 || len(c.Pools) > 0
+}
+
+// PricingModel 返回配置的单价，未配置时返回nil
+func (c *Config) PricingModel() *PricingConfig {
+	if !c.Pricing.Enabled() {
+		return nil
+	}
+	return &c.Pricing
+}
+
+// price 部署组适用的单价
+func (c PricingConfig) price(r UsageRecord) (pool string, cpuHour, memoryGiBHour float64) {
+	for _, p := range c.Pools {
+		if matchAny(p.Groups, r.GroupName) || matchAny(p.Systems, r.SystemName) {
+			return p.Name, p.CpuHour, p.MemoryGiBHour
+		}
+	}
+	return "", c.CpuHour, c.MemoryGiBHour
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Estimate 估算hours小时内的浪费和可节省费用
+// limit由使用量和使用率反推：CPU limit = ContainerCoreAvg / ContainerCpuAvg%，内存同理(ContainerMemValue为MiB)
+// 记录带有pod明细时按pod数量计算全部副本的费用
+func (c PricingConfig) Estimate(r UsageRecord, hours float64) *CostEstimate {
+	pool, cpuHour, memoryGiBHour := c.price(r)
+	target := c.TargetUtilization
+	if target <= 0 || target > 1 {
+		target = 1
+	}
+	estimate := &CostEstimate{Pool: pool, Replicas: 1}
+	if len(r.Pods) > 0 {
+		estimate.Replicas = len(r.Pods)
+	}
+	hours *= float64(estimate.Replicas)
+	for _, resource := range []struct {
+		used, percent, price float64
+	}{
+		{r.ContainerCoreAvg, r.ContainerCpuAvg, cpuHour},
+		{r.ContainerMemValue / 1024, r.ContainerMemAvg, memoryGiBHour},
+	} {
+		if resource.percent <= 0 || resource.used <= 0 {
+			continue
+		}
+		limit := resource.used / (resource.percent / 100)
+		if unused := limit - resource.used; unused > 0 {
+			estimate.WeeklyWaste += unused * resource.price * hours
+		}
+		if reducible := limit - resource.used/target; reducible > 0 {
+			estimate.PotentialSavings += reducible * resource.price * hours
+		}
+	}
+	estimate.WeeklyWaste = roundTo(estimate.WeeklyWaste, 2)
+	estimate.PotentialSavings = roundTo(estimate.PotentialSavings, 2)
+	return estimate
+}
+
+// validate 校验单价配置，错误通过add报告
+func (c PricingConfig) validate(add func(key, format string, args ...interface{})) {
+	if c.CpuHour < 0 {
+		add("pricing.cpu_hour", "不能小于0")
+	}
+	if c.MemoryGiBHour < 0 {
+		add("pricing.memory_gib_hour", "不能小于0")
+	}
+	if c.TargetUtilization <= 0 || c.TargetUtilization > 1 {
+		add("pricing.target_utilization", "应在(0, 1]之间，当前为%v", c.TargetUtilization)
+	}
+	for i, p := range c.Pools {
+		key := fmt.Sprintf("pricing.pools[%d]", i)
+		if p.Name == "" {
+			add(key+".name", "不能为空")
+		}
+		if p.CpuHour < 0 {
+			add(key+".cpu_hour", "不能小于0")
+		}
+		if p.MemoryGiBHour < 0 {
+			add(key+".memory_gib_hour", "不能小于0")
+		}
+		if len(p.Groups) == 0 && len(p.Systems) == 0 {
+			add(key, "groups和systems至少配置一项")
+		}
+		for field, patterns := range map[string][]string{"groups": p.Groups, "systems": p.Systems} {
+			for j, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					add(fmt.Sprintf("%s.%s[%d]", key, field, j), "通配符格式错误: %v", err)
+				}
+			}
+		}
+	}
+}
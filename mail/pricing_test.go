@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestPricingEstimate(t *testing.T) {
+	pricing := PricingConfig{
+		Currency:          "CNY",
+		CpuHour:           0.2,
+		MemoryGiBHour:     0.01,
+		TargetUtilization: 0.5,
+		Pools:             []PricePool{{Name: "highmem", CpuHour: 0.4, Groups: []string{"claims-*"}}},
+	}
+	// CPU limit为4核，使用1核；内存limit为2GiB，使用1GiB
+	record := UsageRecord{GroupName: "finops-api", ContainerCoreAvg: 1, ContainerCpuAvg: 25, ContainerMemValue: 1024, ContainerMemAvg: 50}
+
+	got := pricing.Estimate(record, 168)
+	want := CostEstimate{Replicas: 1, WeeklyWaste: 3*0.2*168 + 1*0.01*168, PotentialSavings: 2 * 0.2 * 168}
+	if *got != want {
+		t.Errorf("Estimate() = %+v, want %+v", *got, want)
+	}
+
+	// 带有pod明细时按副本数计算
+	record.Pods = []UsageRecord{{PodId: "a"}, {PodId: "b"}, {PodId: "c"}}
+	got = pricing.Estimate(record, 168)
+	want = CostEstimate{Replicas: 3, WeeklyWaste: roundTo(3*want.WeeklyWaste, 2), PotentialSavings: roundTo(3*want.PotentialSavings, 2)}
+	if *got != want {
+		t.Errorf("Estimate() with pods = %+v, want %+v", *got, want)
+	}
+
+	record = UsageRecord{GroupName: "claims-batch", ContainerCoreAvg: 1, ContainerCpuAvg: 25}
+	if got := pricing.Estimate(record, 168); got.Pool != "highmem" || got.WeeklyWaste != roundTo(3*0.4*168, 2) {
+		t.Errorf("Estimate() in a pool = %+v", *got)
+	}
+	if got := pricing.Estimate(UsageRecord{GroupName: "unknown"}, 168); got.WeeklyWaste != 0 || got.PotentialSavings != 0 {
+		t.Errorf("Estimate() without usage = %+v", *got)
+	}
+}
+
+func TestCostExcelColumns(t *testing.T) {
+	records := []UsageRecord{
+		{GroupName: "claims-batch", Recommend: "降低limit", Cost: &CostEstimate{Replicas: 3, WeeklyWaste: 30, PotentialSavings: 12}},
+		{GroupName: "finops-api", Recommend: "降低limit", Cost: &CostEstimate{Replicas: 1, WeeklyWaste: 10, PotentialSavings: 4}},
+	}
+	f := excelize.NewFile()
+	defer f.Close()
+	headers := []string{"系统名", "应用名", "部署组名", "Container CPU使用率(%)", "Pod CPU使用率(%)", "Container内存使用率(%)", "Pod内存使用率(%)", "建议"}
+	if err := writeExcelSection(f, "Sheet1", headers, records); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := f.GetRows("Sheet1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := rows[0]
+	if got := header[len(header)-4:]; got[0] != "估算副本数" || got[1] != "周浪费" || got[2] != "可节省" {
+		t.Errorf("cost headers = %v", got)
+	}
+	if got := rows[1][len(header)-4]; got != "3" {
+		t.Errorf("replicas = %q, want 3", got)
+	}
+	total := rows[3]
+	if total[0] != "合计" || total[len(header)-3] != "40" || total[len(header)-2] != "16" {
+		t.Errorf("total row = %v", total)
+	}
+}
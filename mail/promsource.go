@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PromQueries 各指标的PromQL，$group会被替换为部署组标签名
+// 查询结果需按部署组聚合，即每个部署组一条时间序列
+type PromQueries struct {
+	CpuLimitContainer string `json:"cpu_limit_container"`
+	CpuLimitPod       string `json:"cpu_limit_pod"`
+	MemLimitContainer string `json:"mem_limit_container"`
+	MemLimitPod       string `json:"mem_limit_pod"`
+	CpuCoreContainer  string `json:"cpu_core_container"`
+	CpuCorePod        string `json:"cpu_core_pod"`
+	MemValueContainer string `json:"mem_value_container"`
+	MemValuePod       string `json:"mem_value_pod"`
+}
+
+// defaultPromQueries 基于cAdvisor和kube-state-metrics的默认查询，要求两者都带有部署组标签
+// limit类查询结果为比例，cpu_core为核数，mem_value为MiB
+var defaultPromQueries = PromQueries{
+	CpuLimitContainer: `avg by ($group) (sum by ($group, namespace, pod, container) (rate(container_cpu_usage_seconds_total{container!="", container!="POD"}[5m])) / sum by ($group, namespace, pod, container) (kube_pod_container_resource_limits{resource="cpu"}))`,
+	CpuLimitPod:       `avg by ($group) (sum by ($group, namespace, pod) (rate(container_cpu_usage_seconds_total{container!="", container!="POD"}[5m])) / sum by ($group, namespace, pod) (kube_pod_container_resource_limits{resource="cpu"}))`,
+	MemLimitContainer: `avg by ($group) (sum by ($group, namespace, pod, container) (container_memory_working_set_bytes{container!="", container!="POD"}) / sum by ($group, namespace, pod, container) (kube_pod_container_resource_limits{resource="memory"}))`,
+	MemLimitPod:       `avg by ($group) (sum by ($group, namespace, pod) (container_memory_working_set_bytes{container!="", container!="POD"}) / sum by ($group, namespace, pod) (kube_pod_container_resource_limits{resource="memory"}))`,
+	CpuCoreContainer:  `avg by ($group) (sum by ($group, namespace, pod, container) (rate(container_cpu_usage_seconds_total{container!="", container!="POD"}[5m])))`,
+	CpuCorePod:        `avg by ($group) (sum by ($group, namespace, pod) (rate(container_cpu_usage_seconds_total{container!="", container!="POD"}[5m])))`,
+	MemValueContainer: `avg by ($group) (sum by ($group, namespace, pod, container) (container_memory_working_set_bytes{container!="", container!="POD"})) / 1048576`,
+	MemValuePod:       `avg by ($group) (sum by ($group, namespace, pod) (container_memory_working_set_bytes{container!="", container!="POD"})) / 1048576`,
+}
+
+// PrometheusMetricsSource 通过Prometheus HTTP API的范围查询计算窗口内的周平均值
+// 应用信息不在Prometheus中，由Apps提供，为nil时报告中只有部署组名
+type PrometheusMetricsSource struct {
+	URL         string
+	GroupLabel  string        // 部署组标签名
+	Step        time.Duration // 范围查询的步长
+	BearerToken string
+	Queries     PromQueries
+	Client      *http.Client
+	Apps        AppInfoSource
+}
+
+// NewPrometheusMetricsSource 按配置创建Prometheus数据源，未配置的查询使用默认查询
+func NewPrometheusMetricsSource(cfg PrometheusConfig, apps AppInfoSource) (*PrometheusMetricsSource, error) {
+	step, err := time.ParseDuration(cfg.Step)
+	if err != nil {
+		return nil, &ConfigError{Key: "metrics.prometheus.step", Msg: err.Error()}
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return nil, &ConfigError{Key: "metrics.prometheus.timeout", Msg: err.Error()}
+	}
+	queries := cfg.Queries
+	fillDefault := func(value *string, def string) {
+		if *value == "" {
+			*value = def
+		}
+	}
+	fillDefault(&queries.CpuLimitContainer, defaultPromQueries.CpuLimitContainer)
+	fillDefault(&queries.CpuLimitPod, defaultPromQueries.CpuLimitPod)
+	fillDefault(&queries.MemLimitContainer, defaultPromQueries.MemLimitContainer)
+	fillDefault(&queries.MemLimitPod, defaultPromQueries.MemLimitPod)
+	fillDefault(&queries.CpuCoreContainer, defaultPromQueries.CpuCoreContainer)
+	fillDefault(&queries.CpuCorePod, defaultPromQueries.CpuCorePod)
+	fillDefault(&queries.MemValueContainer, defaultPromQueries.MemValueContainer)
+	fillDefault(&queries.MemValuePod, defaultPromQueries.MemValuePod)
+	return &PrometheusMetricsSource{
+		URL:         cfg.URL,
+		GroupLabel:  cfg.GroupLabel,
+		Step:        step,
+		BearerToken: cfg.BearerToken,
+		Queries:     queries,
+		Client:      &http.Client{Timeout: timeout},
+		Apps:        apps,
+	}, nil
+}
+
+// promResponse Prometheus HTTP API的响应
+type promResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][2]interface{}  `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// queryRange 执行范围查询，返回每个部署组在窗口内的所有采样值
+func (s *PrometheusMetricsSource) queryRange(ctx context.Context, query string, w ReportWindow) (map[string][]float64, error) {
+	start, end := w.bounds()
+	form := url.Values{
+		"query": {strings.ReplaceAll(query, "$group", s.GroupLabel)},
+		"start": {strconv.FormatInt(start.Unix(), 10)},
+		"end":   {strconv.FormatInt(end.Add(-s.Step).Unix(), 10)},
+		"step":  {strconv.FormatFloat(s.Step.Seconds(), 'f', -1, 64)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.URL, "/")+"/api/v1/query_range", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if s.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.BearerToken)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求Prometheus失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取Prometheus响应失败: %w", err)
+	}
+	var result promResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("Prometheus响应格式错误(HTTP %d): %w", resp.StatusCode, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("Prometheus查询失败(%s): %s", result.ErrorType, result.Error)
+	}
+	if result.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("Prometheus查询结果类型应为matrix，实际为%s", result.Data.ResultType)
+	}
+
+	values := map[string][]float64{}
+	for _, series := range result.Data.Result {
+		group, ok := series.Metric[s.GroupLabel]
+		if !ok {
+			continue
+		}
+		for _, point := range series.Values {
+			text, _ := point[1].(string)
+			value, err := strconv.ParseFloat(text, 64)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			values[group] = append(values[group], value)
+		}
+	}
+	return values, nil
+}
+
+// sampleSummary 采样值的平均值和分位数
+type sampleSummary struct {
+	avg   float64
+	stats *Stats
+}
+
+// queryRangeSummary 执行范围查询，返回每个部署组在窗口内所有采样点的平均值和分位数
+func (s *PrometheusMetricsSource) queryRangeSummary(ctx context.Context, query string, w ReportWindow) (map[string]sampleSummary, error) {
+	values, err := s.queryRange(ctx, query, w)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make(map[string]sampleSummary, len(values))
+	for group, v := range values {
+		var sum float64
+		for _, x := range v {
+			sum += x
+		}
+		summaries[group] = sampleSummary{avg: sum / float64(len(v)), stats: computeStats(v)}
+	}
+	return summaries, nil
+}
+
+// queryWeekAvg 查询container和pod两个查询并按部署组合并，只保留两者都有数据的部署组
+// percent为true时结果换算为百分比，与SQL数据源的口径一致
+func (s *PrometheusMetricsSource) queryWeekAvg(ctx context.Context, containerQuery, podQuery string, percent bool, w ReportWindow, groupNames []string) ([]GroupWeekAvg, error) {
+	container, err := s.queryRangeSummary(ctx, containerQuery, w)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := s.queryRangeSummary(ctx, podQuery, w)
+	if err != nil {
+		return nil, err
+	}
+	var wanted map[string]bool
+	if len(groupNames) > 0 {
+		wanted = make(map[string]bool, len(groupNames))
+		for _, name := range groupNames {
+			wanted[name] = true
+		}
+	}
+	scale, digits := 1.0, 2
+	if percent {
+		scale, digits = 100, 1
+	}
+	var result []GroupWeekAvg
+	for group, c := range container {
+		p, ok := pod[group]
+		if !ok || (wanted != nil && !wanted[group]) {
+			continue
+		}
+		result = append(result, GroupWeekAvg{
+			GroupName:    group,
+			ContainerAvg: roundTo(c.avg*scale, digits),
+			PodAvg:       roundTo(p.avg*scale, digits),
+			Container:    c.stats.scaled(scale, digits),
+			Pod:          p.stats.scaled(scale, digits),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ContainerAvg != result[j].ContainerAvg {
+			return result[i].ContainerAvg > result[j].ContainerAvg
+		}
+		return result[i].GroupName < result[j].GroupName
+	})
+	return result, nil
+}
+
+// roundTo 四舍五入到digits位小数
+func roundTo(value float64, digits int) float64 {
+	p := math.Pow10(digits)
+	return math.Round(value*p) / p
+}
+
+func (s *PrometheusMetricsSource) CpuLimitWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]GroupWeekAvg, error) {
+	return s.queryWeekAvg(ctx, s.Queries.CpuLimitContainer, s.Queries.CpuLimitPod, true, w, groupNames)
+}
+
+func (s *PrometheusMetricsSource) MemLimitWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]MemLimitWeekData, error) {
+	return s.queryWeekAvg(ctx, s.Queries.MemLimitContainer, s.Queries.MemLimitPod, true, w, groupNames)
+}
+
+func (s *PrometheusMetricsSource) CpuCoreWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]CpuCoreWeekData, error) {
+	return s.queryWeekAvg(ctx, s.Queries.CpuCoreContainer, s.Queries.CpuCorePod, false, w, groupNames)
+}
+
+func (s *PrometheusMetricsSource) MemAvgWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]MemAvgWeekData, error) {
+	return s.queryWeekAvg(ctx, s.Queries.MemValueContainer, s.Queries.MemValuePod, false, w, groupNames)
+}
+
+func (s *PrometheusMetricsSource) AppInfos(ctx context.Context, w ReportWindow, groupNames []string) (map[string]MailAppInfo, error) {
+	if s.Apps == nil {
+		return map[string]MailAppInfo{}, nil
+	}
+	return s.Apps.AppInfos(ctx, w, groupNames)
+}
+
+// Close 关闭应用信息来源
+func (s *PrometheusMetricsSource) Close() error {
+	if closer, ok := s.Apps.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
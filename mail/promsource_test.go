@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newPromServer 模拟Prometheus范围查询接口，results以查询语句为key返回对应的响应
+func newPromServer(t *testing.T, results map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" || r.Method != http.MethodPost {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		start, end := fixtureWindow.Start, fixtureWindow.End.AddDate(0, 0, 1)
+		if got := r.PostForm.Get("start"); got != strconv.FormatInt(start.Unix(), 10) {
+			t.Errorf("start = %s", got)
+		}
+		if got := r.PostForm.Get("end"); got != strconv.FormatInt(end.Unix()-3600, 10) {
+			t.Errorf("end = %s, want last step before window end", got)
+		}
+		if got := r.PostForm.Get("step"); got != "3600" {
+			t.Errorf("step = %s", got)
+		}
+		body, ok := results[r.PostForm.Get("query")]
+		if !ok {
+			t.Errorf("unexpected query %q", r.PostForm.Get("query"))
+			body = `{"status":"error","errorType":"bad_data","error":"unknown query"}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// promMatrix 构造matrix类型的成功响应，series依次为部署组名和采样值
+func promMatrix(series ...[]string) string {
+	var parts []string
+	for _, s := range series {
+		var values []string
+		for i, v := range s[1:] {
+			values = append(values, fmt.Sprintf(`[%d,%q]`, 1748188800+3600*i, v))
+		}
+		metric := `{"team":"ops"}`
+		if s[0] != "" {
+			metric = fmt.Sprintf(`{"group_name":%q}`, s[0])
+		}
+		parts = append(parts, fmt.Sprintf(`{"metric":%s,"values":[%s]}`, metric, strings.Join(values, ",")))
+	}
+	return `{"status":"success","data":{"resultType":"matrix","result":[` + strings.Join(parts, ",") + `]}}`
+}
+
+func newTestPromSource(t *testing.T, url string) *PrometheusMetricsSource {
+	t.Helper()
+	source, err := NewPrometheusMetricsSource(PrometheusConfig{
+		URL:         url + "/",
+		GroupLabel:  "group_name",
+		Step:        "1h",
+		Timeout:     "5s",
+		BearerToken: "token",
+		Queries: PromQueries{
+			CpuLimitContainer: `container_cpu{$group=~".+"}`,
+			CpuLimitPod:       `pod_cpu{$group=~".+"}`,
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+func TestPrometheusCpuLimitWeekData(t *testing.T) {
+	server := newPromServer(t, map[string]string{
+		`container_cpu{group_name=~".+"}`: promMatrix(
+			[]string{"finops-api", "0.5", "0.7", "0.9"},
+			[]string{"claims-batch", "0.1", "NaN", "0.2"},
+			[]string{"only-container", "0.3"},
+			[]string{"", "0.99"}, // 没有部署组标签的序列被忽略
+		),
+		`pod_cpu{group_name=~".+"}`: promMatrix(
+			[]string{"finops-api", "0.4", "0.6"},
+			[]string{"claims-batch", "0.05", "+Inf", "0.1"},
+		),
+	})
+	source := newTestPromSource(t, server.URL)
+
+	data, err := source.CpuLimitWeekData(context.Background(), fixtureWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只保留container和pod都有数据的部署组，按container平均值降序，NaN和Inf采样点不计入
+	if len(data) != 2 {
+		t.Fatalf("got %d groups, want 2: %+v", len(data), data)
+	}
+	api, batch := data[0], data[1]
+	if api.GroupName != "finops-api" || api.ContainerAvg != 70 || api.PodAvg != 50 {
+		t.Errorf("data[0] = %+v, want finops-api 70/50", api)
+	}
+	if api.Container == nil || api.Container.Max != 90 {
+		t.Errorf("finops-api container stats = %+v, want max 90", api.Container)
+	}
+	if batch.GroupName != "claims-batch" || batch.ContainerAvg != 15 || batch.PodAvg != 7.5 {
+		t.Errorf("data[1] = %+v, want claims-batch 15/7.5", batch)
+	}
+
+	filtered, err := source.CpuLimitWeekData(context.Background(), fixtureWindow, "claims-batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) != 1 || filtered[0].GroupName != "claims-batch" {
+		t.Errorf("filtered = %+v, want only claims-batch", filtered)
+	}
+
+	infos, err := source.AppInfos(context.Background(), fixtureWindow, []string{"finops-api"})
+	if err != nil || len(infos) != 0 {
+		t.Errorf("AppInfos() without Apps = %v, %v", infos, err)
+	}
+}
+
+func TestPrometheusQueryErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"error status", `{"status":"error","errorType":"bad_data","error":"parse error"}`, "bad_data"},
+		{"vector result", `{"status":"success","data":{"resultType":"vector","result":[]}}`, "matrix"},
+		{"not json", `<html>bad gateway</html>`, "响应格式错误"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newPromServer(t, map[string]string{
+				`container_cpu{group_name=~".+"}`: tt.body,
+				`pod_cpu{group_name=~".+"}`:       tt.body,
+			})
+			_, err := newTestPromSource(t, server.URL).CpuLimitWeekData(context.Background(), fixtureWindow)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
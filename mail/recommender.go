@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 建议的来源
+const (
+	RecommendBuiltin  = "builtin"  // 内置规则按使用量计算
+	RecommendExternal = "external" // agent_recommend_weeks等外部来源
+)
+
+// RecommenderConfig 内置的request/limit建议，使用量均为单个container的平均值
+// request = 使用量 * headroom，limit = max(request * limit_factor, 峰值 * headroom)，结果限制在cpu、memory的上下限内
+// 数据源没有峰值时limit只按request计算
+type RecommenderConfig struct {
+	Enabled     bool           `json:"enabled"`
+	Headroom    float64        `json:"headroom"`
+	LimitFactor float64        `json:"limit_factor"`
+	Cpu         ResourceBounds `json:"cpu"`    // 单位为核
+	Memory      ResourceBounds `json:"memory"` // 单位为MiB
+}
+
+// ResourceBounds 建议值的上下限，为0时不限制
+type ResourceBounds struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// Recommendation 部署组的资源调整建议，Cpu*为核，Memory*为MiB，为0表示没有对应建议
+type Recommendation struct {
+	Source             string  `json:"source"`
+	Text               string  `json:"text"`
+	CpuRequest         float64 `json:"cpu_request,omitempty"`
+	CpuLimit           float64 `json:"cpu_limit,omitempty"`
+	MemoryRequest      float64 `json:"memory_request,omitempty"`
+	MemoryLimit        float64 `json:"memory_limit,omitempty"`
+	CurrentCpuLimit    float64 `json:"current_cpu_limit,omitempty"`
+	CurrentMemoryLimit float64 `json:"current_memory_limit,omitempty"`
+	External           string  `json:"external,omitempty"` // 外部来源的建议原文
+}
+
+// RecommenderModel 返回启用的建议配置，未启用时返回nil
+func (c *Config) RecommenderModel() *RecommenderConfig {
+	if !c.Recommender.Enabled {
+		return nil
+	}
+	return &c.Recommender
+}
+
+// Apply 为记录生成建议并写入Recommend，无法计算时保留外部建议
+func (c RecommenderConfig) Apply(r *UsageRecord) {
+	if rec := c.Recommend(*r); rec != nil {
+		rec.External = r.Recommend
+		r.Recommendation, r.Recommend = rec, rec.Text
+		return
+	}
+	if r.Recommend != "" {
+		r.Recommendation = &Recommendation{Source: RecommendExternal, Text: r.Recommend, External: r.Recommend}
+	}
+}
+
+// Recommend 按container的平均使用量和峰值计算建议，CPU和内存都没有使用量时返回nil
+func (c RecommenderConfig) Recommend(r UsageRecord) *Recommendation {
+	rec := &Recommendation{Source: RecommendBuiltin}
+	var parts []string
+	if r.ContainerCoreAvg > 0 {
+		rec.CpuRequest, rec.CpuLimit = c.size(r.ContainerCoreAvg, peak(r, MetricCpuCore), c.Cpu, 0.01)
+		rec.CurrentCpuLimit = impliedLimit(r.ContainerCoreAvg, r.ContainerCpuAvg)
+		parts = append(parts, describeRecommendation("CPU", CpuQuantity(rec.CpuRequest), CpuQuantity(rec.CpuLimit), rec.CurrentCpuLimit, CpuQuantity))
+	}
+	if r.ContainerMemValue > 0 {
+		rec.MemoryRequest, rec.MemoryLimit = c.size(r.ContainerMemValue, peak(r, MetricMemValue), c.Memory, 16)
+		rec.CurrentMemoryLimit = impliedLimit(r.ContainerMemValue, r.ContainerMemAvg)
+		parts = append(parts, describeRecommendation("内存", MemoryQuantity(rec.MemoryRequest), MemoryQuantity(rec.MemoryLimit), rec.CurrentMemoryLimit, MemoryQuantity))
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	rec.Text = "建议" + strings.Join(parts, "；")
+	return rec
+}
+
+// peak container的使用量峰值，没有分位数时为0
+func peak(r UsageRecord, metric string) float64 {
+	value, _ := r.Value(metric, ScopeContainer, StatMax)
+	return value
+}
+
+// size 计算request和limit，按step向上取整后限制在bounds内
+func (c RecommenderConfig) size(used, peak float64, bounds ResourceBounds, step float64) (request, limit float64) {
+	headroom, factor := c.Headroom, c.LimitFactor
+	if headroom <= 0 {
+		headroom = 1
+	}
+	if factor < 1 {
+		factor = 1
+	}
+	request = bounds.clamp(ceilTo(used*headroom, step))
+	limit = bounds.clamp(ceilTo(math.Max(request*factor, peak*headroom), step))
+	return request, math.Max(request, limit)
+}
+
+func (b ResourceBounds) clamp(v float64) float64 {
+	if b.Min > 0 && v < b.Min {
+		v = b.Min
+	}
+	if b.Max > 0 && v > b.Max {
+		v = b.Max
+	}
+	return v
+}
+
+// ceilTo 向上取整到step的整数倍
+func ceilTo(v, step float64) float64 {
+	return roundTo(math.Ceil(roundTo(v/step, 6))*step, 6)
+}
+
+// impliedLimit 由使用量和使用率(百分比)反推limit，使用率为0时无法计算
+func impliedLimit(used, percent float64) float64 {
+	if percent <= 0 {
+		return 0
+	}
+	return used / (percent / 100)
+}
+
+func describeRecommendation(name, request, limit string, current float64, quantity func(float64) string) string {
+	text := fmt.Sprintf("%s request %s、limit %s", name, request, limit)
+	if current > 0 {
+		text += fmt.Sprintf("(当前limit约%s)", quantity(current))
+	}
+	return text
+}
+
+// CpuQuantity 以Kubernetes的格式表示CPU核数，如0.25核为250m
+func CpuQuantity(cores float64) string {
+	return strconv.FormatFloat(math.Ceil(roundTo(cores*1000, 6)), 'f', 0, 64) + "m"
+}
+
+// MemoryQuantity 以Kubernetes的格式表示内存，如512Mi
+func MemoryQuantity(mib float64) string {
+	return strconv.FormatFloat(math.Ceil(roundTo(mib, 6)), 'f', 0, 64) + "Mi"
+}
+
+// Resources 可直接粘贴到Deployment中的resources片段，只有外部建议时为空
+func (r *Recommendation) Resources() string {
+	if r == nil || r.Source != RecommendBuiltin {
+		return ""
+	}
+	var requests, limits []string
+	if r.CpuRequest > 0 {
+		requests = append(requests, "    cpu: "+CpuQuantity(r.CpuRequest))
+		limits = append(limits, "    cpu: "+CpuQuantity(r.CpuLimit))
+	}
+	if r.MemoryRequest > 0 {
+		requests = append(requests, "    memory: "+MemoryQuantity(r.MemoryRequest))
+		limits = append(limits, "    memory: "+MemoryQuantity(r.MemoryLimit))
+	}
+	return "resources:\n  requests:\n" + strings.Join(requests, "\n") + "\n  limits:\n" + strings.Join(limits, "\n")
+}
+
+// validate 校验建议配置，错误通过add报告
+func (c RecommenderConfig) validate(add func(key, format string, args ...interface{})) {
+	if c.Headroom < 1 {
+		add("recommender.headroom", "不能小于1，当前为%v", c.Headroom)
+	}
+	if c.LimitFactor < 1 {
+		add("recommender.limit_factor", "不能小于1，当前为%v", c.LimitFactor)
+	}
+	for key, b := range map[string]ResourceBounds{"recommender.cpu": c.Cpu, "recommender.memory": c.Memory} {
+		if b.Min < 0 || b.Max < 0 {
+			add(key, "上下限不能小于0")
+		}
+		if b.Max > 0 && b.Min > b.Max {
+			add(key+".min", "不能大于max")
+		}
+	}
+}
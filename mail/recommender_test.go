@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRecommenderSizing(t *testing.T) {
+	c := RecommenderConfig{Headroom: 1.2, LimitFactor: 1.5, Cpu: ResourceBounds{Min: 0.1, Max: 4}, Memory: ResourceBounds{Min: 128, Max: 8192}}
+	tests := []struct {
+		name                           string
+		record                         UsageRecord
+		cpuRequest, cpuLimit           float64
+		memoryRequest, memoryLimit     float64
+		currentCpuLimit, currentMemory float64
+	}{
+		// request = 使用量*1.2，limit = request*1.5，CPU按0.01核、内存按16Mi向上取整
+		{"sized", UsageRecord{ContainerCoreAvg: 0.5, ContainerCpuAvg: 25, ContainerMemValue: 300, ContainerMemAvg: 50}, 0.6, 0.9, 368, 560, 2, 600},
+		{"min bound", UsageRecord{ContainerCoreAvg: 0.01, ContainerMemValue: 10}, 0.1, 0.15, 128, 192, 0, 0},
+		{"max bound", UsageRecord{ContainerCoreAvg: 5, ContainerMemValue: 6000}, 4, 4, 7200, 8192, 0, 0},
+		{"cpu only", UsageRecord{ContainerCoreAvg: 1}, 1.2, 1.8, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		rec := c.Recommend(tt.record)
+		if rec == nil {
+			t.Fatalf("%s: Recommend() = nil", tt.name)
+		}
+		got := [6]float64{rec.CpuRequest, rec.CpuLimit, rec.MemoryRequest, rec.MemoryLimit, rec.CurrentCpuLimit, rec.CurrentMemoryLimit}
+		want := [6]float64{tt.cpuRequest, tt.cpuLimit, tt.memoryRequest, tt.memoryLimit, tt.currentCpuLimit, tt.currentMemory}
+		if got != want || rec.Source != RecommendBuiltin {
+			t.Errorf("%s: Recommend() = %+v, want %v", tt.name, rec, want)
+		}
+	}
+
+	rec := c.Recommend(tests[0].record)
+	if want := "建议CPU request 600m、limit 900m(当前limit约2000m)；内存 request 368Mi、limit 560Mi(当前limit约600Mi)"; rec.Text != want {
+		t.Errorf("Text = %q, want %q", rec.Text, want)
+	}
+	if want := "resources:\n  requests:\n    cpu: 600m\n    memory: 368Mi\n  limits:\n    cpu: 900m\n    memory: 560Mi"; rec.Resources() != want {
+		t.Errorf("Resources() = %q", rec.Resources())
+	}
+	if c.Recommend(UsageRecord{ContainerCpuAvg: 20}) != nil {
+		t.Error("Recommend() without usage should return nil")
+	}
+}
+
+func TestRecommenderApply(t *testing.T) {
+	c := defaultConfig().Recommender
+	// 内置建议覆盖Recommend，外部建议保留在External中
+	r := UsageRecord{ContainerCoreAvg: 0.2, ContainerCpuAvg: 10, Recommend: "建议CPU limit调整为0.5核"}
+	c.Apply(&r)
+	if r.Recommendation == nil || r.Recommendation.Source != RecommendBuiltin || r.Recommendation.External != "建议CPU limit调整为0.5核" {
+		t.Fatalf("Recommendation = %+v", r.Recommendation)
+	}
+	if r.Recommend != "建议CPU request 260m、limit 520m(当前limit约2000m)" {
+		t.Errorf("Recommend = %q", r.Recommend)
+	}
+
+	// 没有使用量时退回外部建议
+	external := UsageRecord{Recommend: "外部建议"}
+	c.Apply(&external)
+	if rec := external.Recommendation; rec == nil || rec.Source != RecommendExternal || rec.Text != "外部建议" || rec.Resources() != "" {
+		t.Errorf("external Recommendation = %+v", rec)
+	}
+	none := UsageRecord{}
+	c.Apply(&none)
+	if none.Recommendation != nil || none.Recommend != "" {
+		t.Errorf("empty record got %+v", none.Recommendation)
+	}
+}
+
+func TestQuantities(t *testing.T) {
+	for _, tt := range []struct{ got, want string }{
+		{CpuQuantity(0.25), "250m"},
+		{CpuQuantity(1.0004), "1001m"},
+		{MemoryQuantity(511.2), "512Mi"},
+		{MemoryQuantity(64), "64Mi"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestRecommenderValidate(t *testing.T) {
+	var keys []string
+	add := func(key, format string, args ...interface{}) { keys = append(keys, key) }
+	RecommenderConfig{Headroom: 0.5, LimitFactor: 1, Cpu: ResourceBounds{Min: 2, Max: 1}, Memory: ResourceBounds{Min: -1}}.validate(add)
+	got := strings.Join(keys, ",")
+	for _, want := range []string{"recommender.headroom", "recommender.cpu.min", "recommender.memory"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("validate() keys = %s, missing %s", got, want)
+		}
+	}
+	if strings.Contains(got, "limit_factor") {
+		t.Errorf("limit_factor 1 should be valid: %s", got)
+	}
+}
+
+func TestBuildReportRecommender(t *testing.T) {
+	source := openFixtureSource(t)
+	rules := NewRuleEngine(RulesConfig{}, defaultConfig().Thresholds)
+	report, err := BuildReport(context.Background(), source, rules, fixtureWindow, ReportOptions{Recommender: defaultConfig().RecommenderModel()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 未启用时RecommenderModel为nil，只使用外部建议
+	if api := report.Records(CategoryOver)[0]; api.Recommend != "建议CPU limit调整为4核" || api.Recommendation != nil {
+		t.Errorf("disabled recommender changed %+v", api)
+	}
+
+	cfg := defaultConfig()
+	cfg.Recommender.Enabled = true
+	report, err = BuildReport(context.Background(), source, rules, fixtureWindow, ReportOptions{Recommender: cfg.RecommenderModel()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := report.Records(CategoryOver)[0]
+	if api.Recommendation == nil || api.Recommendation.Source != RecommendBuiltin || api.Recommendation.External != "建议CPU limit调整为4核" {
+		t.Errorf("finops-api Recommendation = %+v", api.Recommendation)
+	}
+	if !strings.HasPrefix(api.Recommend, "建议CPU request 3750m、limit 7500m(当前limit约4000m)") {
+		t.Errorf("finops-api Recommend = %q", api.Recommend)
+	}
+}
+
+func TestRecommenderUsesPeak(t *testing.T) {
+	c := RecommenderConfig{Headroom: 1.2, LimitFactor: 1.5}
+	r := UsageRecord{ContainerCoreAvg: 0.5, Stats: map[string]Stats{
+		statsKey(MetricCpuCore, ScopeContainer): {Max: 1.5},
+	}}
+	// limit取request*limit_factor与峰值*headroom中较大的值
+	rec := c.Recommend(r)
+	if rec.CpuRequest != 0.6 || rec.CpuLimit != 1.8 {
+		t.Errorf("with peak: request %v, limit %v, want 0.6, 1.8", rec.CpuRequest, rec.CpuLimit)
+	}
+	r.Stats[statsKey(MetricCpuCore, ScopeContainer)] = Stats{Max: 0.6}
+	if rec := c.Recommend(r); rec.CpuLimit != 0.9 {
+		t.Errorf("low peak: limit %v, want 0.9", rec.CpuLimit)
+	}
+}
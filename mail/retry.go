@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// RetryConfig 投递临时失败时的重试配置
+type RetryConfig struct {
+	MaxAttempts    int    `json:"max_attempts"`    // 包括第一次在内的最多尝试次数，1为不重试
+	InitialBackoff string `json:"initial_backoff"` // 第一次重试前的等待时间，之后每次翻倍
+	MaxBackoff     string `json:"max_backoff"`     // 单次等待时间的上限
+	Deadline       string `json:"deadline"`        // 包括重试在内的总时长，为空时不限制
+}
+
+// RetryPolicy 解析后的重试策略
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Deadline       time.Duration
+}
+
+// Policy 解析重试配置
+func (c RetryConfig) Policy() (RetryPolicy, error) {
+	policy := RetryPolicy{MaxAttempts: c.MaxAttempts}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"initial_backoff", c.InitialBackoff, &policy.InitialBackoff},
+		{"max_backoff", c.MaxBackoff, &policy.MaxBackoff},
+		{"deadline", c.Deadline, &policy.Deadline},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return RetryPolicy{}, fmt.Errorf("解析%s失败: %w", d.name, err)
+		}
+		*d.dst = v
+	}
+	return policy, nil
+}
+
+// backoff 第attempt次失败后的等待时间：指数退避，在[d/2, d]内随机以错开多个实例的重试
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// RetryTransport 对临时失败(见IsTemporary)按指数退避重试，永久失败立即返回
+type RetryTransport struct {
+	Transport Transport
+	Policy    RetryPolicy
+}
+
+func (t *RetryTransport) Send(ctx context.Context, msg *Message) error {
+	if t.Policy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Policy.Deadline)
+		defer cancel()
+	}
+	// 重试时报文的Date和Message-ID保持不变，收件方可以据此去重
+	if msg.Date.IsZero() {
+		msg.Date = time.Now()
+	}
+	if msg.MessageID == "" {
+		msg.MessageID = generateMessageID(msg.From.Address)
+	}
+	for attempt := 1; ; attempt++ {
+		err := t.Transport.Send(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		if !IsTemporary(err) || attempt >= t.Policy.MaxAttempts {
+			return retryError(attempt, err)
+		}
+		delay := t.Policy.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("重试超过时限: %w", retryError(attempt, err))
+		}
+		log.Printf("邮件发送临时失败，%v后进行第%d次重试：%v", delay.Round(time.Millisecond), attempt, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return retryError(attempt, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// retryError 多次尝试后的错误，保留最后一次的错误以便errors.As取得*SMTPError
+func retryError(attempts int, err error) error {
+	if attempts <= 1 {
+		return err
+	}
+	return fmt.Errorf("尝试%d次后仍失败: %w", attempts, err)
+}
+
+// validate 校验重试配置，错误通过add报告
+func (c RetryConfig) validate(add func(key, format string, args ...interface{})) {
+	if c.MaxAttempts < 1 {
+		add("smtp.retry.max_attempts", "不能小于1，当前为%d", c.MaxAttempts)
+	}
+	for key, value := range map[string]string{"smtp.retry.initial_backoff": c.InitialBackoff, "smtp.retry.max_backoff": c.MaxBackoff, "smtp.retry.deadline": c.Deadline} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			add(key, "应为不小于0的时长，如5s，当前为%q", value)
+		}
+	}
+	if policy, err := c.Policy(); err == nil && policy.MaxBackoff > 0 && policy.InitialBackoff > policy.MaxBackoff {
+		add("smtp.retry.initial_backoff", "不能大于max_backoff")
+	}
+}
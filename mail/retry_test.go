@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyTransport 依次返回errs中的错误，之后的调用成功，并记录每次收到的报文头
+type flakyTransport struct {
+	mu      sync.Mutex
+	errs    []error
+	headers []mail.Header
+}
+
+func (t *flakyTransport) Send(ctx context.Context, msg *Message) error {
+	raw, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.headers = append(t.headers, parsed.Header)
+	if len(t.errs) == 0 {
+		return nil
+	}
+	err, t.errs = t.errs[0], t.errs[1:]
+	return err
+}
+
+func (t *flakyTransport) calls() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.headers)
+}
+
+func newRetryMessage() *Message {
+	return &Message{From: mail.Address{Address: "rpa@example.com"}, To: []string{"ops@example.com"}, Subject: "报告", Text: "hi"}
+}
+
+// fastPolicy 等待时间很短的重试策略
+var fastPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond, Deadline: 5 * time.Second}
+
+func TestRetryTransportRecoversFromTemporaryFailure(t *testing.T) {
+	inner := &flakyTransport{errs: []error{&SMTPError{Command: "RCPT TO", Code: 451, Message: "try again later"}}}
+	msg := newRetryMessage()
+	if err := (&RetryTransport{Transport: inner, Policy: fastPolicy}).Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if inner.calls() != 2 {
+		t.Fatalf("got %d attempts, want 2", inner.calls())
+	}
+	// 重试时Date和Message-ID保持不变，收件方可以据此去重
+	first, second := inner.headers[0], inner.headers[1]
+	if first.Get("Message-Id") == "" || first.Get("Message-Id") != second.Get("Message-Id") {
+		t.Errorf("Message-ID changed between attempts: %q, %q", first.Get("Message-Id"), second.Get("Message-Id"))
+	}
+	if first.Get("Date") != second.Get("Date") {
+		t.Errorf("Date changed between attempts: %q, %q", first.Get("Date"), second.Get("Date"))
+	}
+	if "<"+msg.MessageID+">" != first.Get("Message-Id") {
+		t.Errorf("msg.MessageID = %q, sent %q", msg.MessageID, first.Get("Message-Id"))
+	}
+}
+
+func TestRetryTransportPermanentFailure(t *testing.T) {
+	inner := &flakyTransport{errs: []error{&SMTPError{Command: "RCPT TO", Code: 550, Enhanced: "5.1.1", Message: "User unknown"}}}
+	err := (&RetryTransport{Transport: inner, Policy: fastPolicy}).Send(context.Background(), newRetryMessage())
+	if inner.calls() != 1 {
+		t.Errorf("permanent failure retried: %d attempts", inner.calls())
+	}
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 || smtpErr.Enhanced != "5.1.1" {
+		t.Errorf("err = %v, want the 550 response", err)
+	}
+	if strings.Contains(err.Error(), "尝试") {
+		t.Errorf("single attempt should not be reported as retried: %v", err)
+	}
+}
+
+func TestRetryTransportGivesUpAfterMaxAttempts(t *testing.T) {
+	temporary := &SMTPError{Code: 421, Message: "Service not available"}
+	inner := &flakyTransport{errs: []error{temporary, temporary, temporary, temporary}}
+	err := (&RetryTransport{Transport: inner, Policy: fastPolicy}).Send(context.Background(), newRetryMessage())
+	if inner.calls() != 3 {
+		t.Errorf("got %d attempts, want 3", inner.calls())
+	}
+	var smtpErr *SMTPError
+	if err == nil || !strings.Contains(err.Error(), "尝试3次后仍失败") || !errors.As(err, &smtpErr) || smtpErr.Code != 421 {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestRetryTransportDeadline(t *testing.T) {
+	// 下次重试的等待时间超过剩余时限时不再等待，直接返回
+	inner := &flakyTransport{errs: []error{&SMTPError{Code: 421}}}
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, Deadline: 100 * time.Millisecond}
+	start := time.Now()
+	err := (&RetryTransport{Transport: inner, Policy: policy}).Send(context.Background(), newRetryMessage())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Send() waited %v past the deadline", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "重试超过时限") || inner.calls() != 1 {
+		t.Errorf("err = %v after %d attempts", err, inner.calls())
+	}
+}
+
+func TestRetryTransportCanceledWhileWaiting(t *testing.T) {
+	inner := &flakyTransport{errs: []error{&SMTPError{Code: 421}}}
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	err := (&RetryTransport{Transport: inner, Policy: policy}).Send(ctx, newRetryMessage())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Send() ignored cancellation for %v", elapsed)
+	}
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || inner.calls() != 1 {
+		t.Errorf("err = %v after %d attempts, want the last SMTP error", err, inner.calls())
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for _, tt := range []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second}, // 1.6s超过上限
+		{50, 500 * time.Millisecond, time.Second},
+	} {
+		for i := 0; i < 20; i++ {
+			if d := policy.backoff(tt.attempt); d < tt.min || d > tt.max {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v]", tt.attempt, d, tt.min, tt.max)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("backoff without InitialBackoff = %v, want 0", d)
+	}
+}
+
+func TestRetryConfigPolicy(t *testing.T) {
+	policy, err := defaultConfig().SMTP.Retry.Policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RetryPolicy{MaxAttempts: 3, InitialBackoff: 5 * time.Second, MaxBackoff: time.Minute, Deadline: 5 * time.Minute}
+	if policy != want {
+		t.Errorf("Policy() = %+v, want %+v", policy, want)
+	}
+	if _, err := (RetryConfig{MaxAttempts: 3, InitialBackoff: "5 seconds"}).Policy(); err == nil || !strings.Contains(err.Error(), "initial_backoff") {
+		t.Errorf("Policy() with invalid duration: err = %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"path"
+	"sort"
+)
+
+// RoutingConfig 按负责人拆分报告，每个负责人只收到自己负责的部署组
+// 负责人来自Owners和OwnerQuery两部分，都未配置时不拆分
+type RoutingConfig struct {
+	Owners     []OwnerRoute `json:"owners"`
+	OwnerQuery string       `json:"owner_query"` // 在database中执行，返回系统名和邮箱两列，同一系统可有多行
+	Subject    string       `json:"subject"`     // 个人报告的主题，为空时为"<报告主题>-<负责人>"
+}
+
+// OwnerRoute 负责人及其负责的范围，Systems、Applications、Groups为通配符，满足任一即匹配
+type OwnerRoute struct {
+	Name         string   `json:"name"`
+	To           []string `json:"to"`
+	Cc           []string `json:"cc"`
+	Systems      []string `json:"systems"`
+	Applications []string `json:"applications"`
+	Groups       []string `json:"groups"`
+}
+
+// Enabled 是否配置了负责人
+func (c RoutingConfig) Enabled() bool {
+	return len(c.Owners) > 0 || c.OwnerQuery != ""
+}
+
+// Match 部署组是否由该负责人负责
+func (o OwnerRoute) Match(r UsageRecord) bool {
+	return matchAny(o.Systems, r.SystemName) || matchAny(o.Applications, r.ApplicationName) || matchAny(o.Groups, r.GroupName)
+}
+
+// OwnerRoutes 合并配置和owner_query中的负责人，owner_query的结果按系统名各生成一个负责人
+func (c *Config) OwnerRoutes(ctx context.Context) ([]OwnerRoute, error) {
+	routes := append([]OwnerRoute(nil), c.Routing.Owners...)
+	if c.Routing.OwnerQuery == "" {
+		return routes, nil
+	}
+	db, err := c.Database.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.QueryContext(ctx, c.Routing.OwnerQuery)
+	if err != nil {
+		return nil, fmt.Errorf("查询负责人失败: %w", err)
+	}
+	defer rows.Close()
+	bySystem := map[string][]string{}
+	for rows.Next() {
+		var system, addr string
+		if err := rows.Scan(&system, &addr); err != nil {
+			return nil, fmt.Errorf("读取负责人失败: %w", err)
+		}
+		if _, err := mail.ParseAddress(addr); err != nil {
+			// 个别系统的地址有误不影响其他负责人
+			log.Printf("系统%s的负责人地址无效：%s", system, addr)
+			continue
+		}
+		bySystem[system] = append(bySystem[system], addr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("读取负责人失败: %w", err)
+	}
+	systems := make([]string, 0, len(bySystem))
+	for system := range bySystem {
+		systems = append(systems, system)
+	}
+	sort.Strings(systems)
+	for _, system := range systems {
+		// 系统名按字面匹配，避免其中的通配符字符
+		routes = append(routes, OwnerRoute{Name: system, To: bySystem[system], Systems: []string{escapePattern(system)}})
+	}
+	return routes, nil
+}
+
+// escapePattern 转义path.Match中的特殊字符
+func escapePattern(s string) string {
+	var escaped []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, s[i])
+	}
+	return string(escaped)
+}
+
+// OwnerReport 负责人的个人报告
+type OwnerReport struct {
+	Owner OwnerRoute
+	Info  MailTotalDataInfo // 正文数据，每类最多10个部署组
+	Excel MailExcelDataInfo // 附件数据，包含负责人的全部部署组
+}
+
+// SplitByOwner 按负责人拆分报告，没有部署组的负责人不在结果中
+// 一个部署组可以属于多个负责人，不属于任何负责人的部署组只出现在全局报告中
+func SplitByOwner(excel MailExcelDataInfo, routes []OwnerRoute) []OwnerReport {
+	var reports []OwnerReport
+	for _, route := range routes {
+		owned := excel.Filter(route.Match)
+		if owned.Empty() {
+			continue
+		}
+		reports = append(reports, OwnerReport{Owner: route, Info: owned.Truncate(bodyRecordLimit), Excel: owned})
+	}
+	return reports
+}
+
+// ownerSubject 个人报告的主题
+func (c RoutingConfig) ownerSubject(subject string, owner OwnerRoute) string {
+	if c.Subject != "" {
+		return c.Subject
+	}
+	return subject + "-" + owner.Name
+}
+
+// sendOwnerReports 向每个负责人发送个人报告，单个负责人失败不影响其他负责人，各负责人的错误合并后返回
+func sendOwnerReports(ctx context.Context, transport Transport, p *PreparedReport, mailServer *MailServerConfig) error {
+	routes, err := GlobalConfig.OwnerRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("读取负责人失败: %w", err)
+	}
+	var errs []error
+	for _, owner := range SplitByOwner(p.Excel, routes) {
+		if err := sendOwnerReport(ctx, transport, p, owner, mailServer); err != nil {
+			errs = append(errs, fmt.Errorf("发送%s的报告失败: %w", owner.Owner.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sendOwnerReport 渲染并发送一个负责人的报告，正文图片与全局报告相同，发送结果以"<报告名>/<负责人>"记录在history中
+func sendOwnerReport(ctx context.Context, transport Transport, p *PreparedReport, owner OwnerReport, mailServer *MailServerConfig) error {
+	owner.Info.Images = p.Info.Images
+	htmlBody, err := RenderReportHTML(GlobalConfig.Templates.HTML, owner.Info)
+	if err != nil {
+		return err
+	}
+	fileName, attachment, err := CreateExcelAttachmentWithData(GlobalConfig.Templates.Excel, owner.Excel)
+	if err != nil {
+		return err
+	}
+	subject := GlobalConfig.Routing.ownerSubject(p.Subject(), owner.Owner)
+	msg, err := buildReportMessage(owner.Owner.To, owner.Owner.Cc, subject, fileName, attachment, nil, nil, mailServer, htmlBody)
+	if err != nil {
+		return fmt.Errorf("构建邮件失败: %w", err)
+	}
+	if err := embedReportImages(msg, owner.Info.Images); err != nil {
+		return fmt.Errorf("嵌入报告图片失败: %w", err)
+	}
+	record := NewHistoryRecord(p.Name+"/"+owner.Owner.Name, msg, owner.Info, owner.Excel, fileName, attachment, nil)
+	return deliverReport(ctx, transport, msg, record, owner.Excel)
+}
+
+// validate 校验负责人配置，错误通过add报告
+func (c RoutingConfig) validate(database DatabaseConfig, add func(key, format string, args ...interface{})) {
+	if c.OwnerQuery != "" && database.Driver == "" {
+		add("routing.owner_query", "需要配置database")
+	}
+	for i, owner := range c.Owners {
+		key := fmt.Sprintf("routing.owners[%d]", i)
+		if owner.Name == "" {
+			add(key+".name", "不能为空")
+		}
+		if len(owner.To) == 0 {
+			add(key+".to", "至少需要一个收件人")
+		}
+		for field, list := range map[string][]string{"to": owner.To, "cc": owner.Cc} {
+			for j, addr := range list {
+				if _, err := mail.ParseAddress(addr); err != nil {
+					add(fmt.Sprintf("%s.%s[%d]", key, field, j), "不是有效的邮箱地址: %s", addr)
+				}
+			}
+		}
+		if len(owner.Systems)+len(owner.Applications)+len(owner.Groups) == 0 {
+			add(key, "systems、applications和groups至少配置一项")
+		}
+		for field, patterns := range map[string][]string{"systems": owner.Systems, "applications": owner.Applications, "groups": owner.Groups} {
+			for j, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					add(fmt.Sprintf("%s.%s[%d]", key, field, j), "通配符格式错误: %v", err)
+				}
+			}
+		}
+	}
+}
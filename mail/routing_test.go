@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// routingReport 两个系统共四个部署组的报告
+func routingReport() Report {
+	report := sampleReport()
+	report.Add(CategoryOver, UsageRecord{SystemName: "gp18ar", ApplicationName: "finops", GroupName: "finops-job", ContainerCpuAvg: 65})
+	report.Add(CategoryBelow, UsageRecord{SystemName: "claims", ApplicationName: "claims-portal", GroupName: "claims-web", ContainerCpuAvg: 20})
+	return report
+}
+
+// ownedGroups 报告中各分类的部署组名，按名称排序
+func ownedGroups(r Report) []string {
+	var names []string
+	for _, section := range r.Sections {
+		names = append(names, groupNames(section.Records)...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSplitByOwner(t *testing.T) {
+	excel := routingReport()
+	before, _ := json.Marshal(excel)
+	routes := []OwnerRoute{
+		{Name: "claims-team", To: []string{"claims@example.com"}, Systems: []string{"claims"}},
+		{Name: "finops-api", To: []string{"api@example.com"}, Groups: []string{"finops-api*"}},
+		{Name: "portal", To: []string{"portal@example.com"}, Applications: []string{"claims-portal"}, Groups: []string{"finops-job"}},
+		{Name: "nobody", To: []string{"nobody@example.com"}, Systems: []string{"unknown"}},
+	}
+
+	reports := SplitByOwner(excel, routes)
+	got := map[string][]string{}
+	for _, r := range reports {
+		got[r.Owner.Name] = ownedGroups(r.Excel)
+		if body := ownedGroups(r.Info); !reflect.DeepEqual(body, got[r.Owner.Name]) {
+			t.Errorf("%s body = %v, attachment = %v", r.Owner.Name, body, got[r.Owner.Name])
+		}
+	}
+	want := map[string][]string{
+		"claims-team": {"claims-batch", "claims-web"},
+		"finops-api":  {"finops-api"},
+		"portal":      {"claims-batch", "claims-web", "finops-job"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitByOwner() = %v, want %v", got, want)
+	}
+	if after, _ := json.Marshal(excel); !bytes.Equal(after, before) {
+		t.Error("SplitByOwner() modified the global report")
+	}
+}
+
+func TestOwnerRoutes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Database = DatabaseConfig{Driver: "sqlite", DSN: writeManyGroupsFixture(t, 0)}
+	db, err := sql.Open("sqlite", cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE owners (systemname TEXT, owner_email TEXT);
+INSERT INTO owners VALUES
+    ('claims', 'claims-a@example.com'),
+    ('claims', 'claims-b@example.com'),
+    ('gp18*', 'wildcard@example.com'),
+    ('gp18ar', 'not an address');`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Routing = RoutingConfig{
+		Owners:     []OwnerRoute{{Name: "finops-api", To: []string{"api@example.com"}, Groups: []string{"finops-api"}}},
+		OwnerQuery: "SELECT systemname, owner_email FROM owners",
+	}
+
+	routes, err := cfg.OwnerRoutes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 配置的负责人在前，owner_query按系统名排序，地址无效的行被跳过
+	var names []string
+	for _, r := range routes {
+		names = append(names, r.Name+":"+strings.Join(r.To, ","))
+	}
+	want := []string{"finops-api:api@example.com", "claims:claims-a@example.com,claims-b@example.com", "gp18*:wildcard@example.com"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("OwnerRoutes() = %v, want %v", names, want)
+	}
+	// 系统名中的通配符按字面匹配
+	if wildcard := routes[2]; wildcard.Match(UsageRecord{SystemName: "gp18ar"}) || !wildcard.Match(UsageRecord{SystemName: "gp18*"}) {
+		t.Errorf("route %q matches by pattern", wildcard.Name)
+	}
+
+	cfg.Routing.OwnerQuery = "SELECT missing FROM owners"
+	if _, err := cfg.OwnerRoutes(context.Background()); err == nil {
+		t.Error("OwnerRoutes() with an invalid owner_query should fail")
+	}
+}
+
+func TestSendOwnerReports(t *testing.T) {
+	cfg := testConfig()
+	cfg.Database = DatabaseConfig{Driver: "sqlite", DSN: writeManyGroupsFixture(t, 0)}
+	useConfig(t, cfg)
+	global, err := PrepareReport(context.Background(), DailyReport, fixtureWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global.Routing {
+		t.Fatal("routing enabled without owners")
+	}
+
+	cfg.Routing = RoutingConfig{Owners: []OwnerRoute{
+		{Name: "claims-team", To: []string{"claims@example.com"}, Systems: []string{"claims"}},
+		{Name: "finops-team", To: []string{"finops@example.com"}, Systems: []string{"gp18ar"}},
+	}}
+	prepared, err := PrepareReport(context.Background(), DailyReport, fixtureWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &MemoryTransport{}
+	if err := prepared.Send(context.Background(), transport); err != nil {
+		t.Fatal(err)
+	}
+	messages := transport.Messages()
+	if len(messages) != 3 {
+		t.Fatalf("sent %d messages, want the global report and 2 owner reports", len(messages))
+	}
+
+	// 全局报告与不拆分时相同
+	if digest := messages[0]; strings.Join(digest.To, ",") != "ops@example.com" || digest.HTML != global.HTML || !bytes.Equal(digest.Attachments[0].Data, global.Attachment) {
+		t.Error("global report differs from the report without routing")
+	}
+	all := ownedGroups(prepared.Excel)
+	for _, msg := range messages[1:] {
+		owner := strings.TrimPrefix(msg.Subject, prepared.Subject()+"-")
+		system := map[string]string{"claims-team": "claims", "finops-team": "gp18ar"}[owner]
+		if system == "" {
+			t.Fatalf("unexpected owner report %q", msg.Subject)
+		}
+		f, err := excelize.OpenReader(bytes.NewReader(msg.Attachments[0].Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sent []string
+		for _, sheet := range f.GetSheetList() {
+			rows, _ := f.GetRows(sheet)
+			for i, row := range rows {
+				if i > 0 && len(row) > 2 && row[0] != "" {
+					if row[0] != system {
+						t.Errorf("%s received %s of system %s", owner, row[2], row[0])
+					}
+					sent = append(sent, row[2])
+				}
+			}
+		}
+		f.Close()
+		if len(sent) == 0 {
+			t.Errorf("%s received no groups", owner)
+		}
+		for _, group := range all {
+			owned := false
+			for _, name := range sent {
+				owned = owned || name == group
+			}
+			if strings.Contains(msg.HTML, ">"+group+"<") != owned {
+				t.Errorf("%s body and attachment disagree on %s", owner, group)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 规则可以引用的指标
+const (
+	MetricCpuLimit = "cpu_limit" // CPU使用量/limit
+	MetricCpuCore  = "cpu_core"  // CPU使用核数
+	MetricMemLimit = "mem_limit" // 内存使用量/limit
+	MetricMemValue = "mem_value" // 内存使用量
+)
+
+// 规则可以引用的范围
+const (
+	ScopePod       = "pod"
+	ScopeContainer = "container"
+)
+
+// 规则组合方式
+const (
+	CombinatorAnd = "and"
+	CombinatorOr  = "or"
+)
+
+// Rule 单条阈值规则，如pod的cpu_limit >= 0.4
+// cpu_limit、mem_limit的阈值为比例(0.4即40%)，cpu_core、mem_value的阈值与数据单位一致
+type Rule struct {
+	Metric     string  `json:"metric"`
+	Scope      string  `json:"scope"`
+	Stat       string  `json:"stat"`       // avg(默认)、p50、p95、p99或max
+	Comparator string  `json:"comparator"` // >、>=、<、<=、==、!=
+	Threshold  float64 `json:"threshold"`
+}
+
+// RuleSet 一组规则及其组合方式，Groups为嵌套的规则组，与Rules按同一方式组合
+type RuleSet struct {
+	Combinator string    `json:"combinator"` // and(默认)或or
+	Rules      []Rule    `json:"rules"`
+	Groups     []RuleSet `json:"groups"`
+}
+
+// RulesConfig 超过阈值(建议扩容)与低于阈值(建议缩容)的判定规则
+// 未配置时由thresholds生成，与原先写在SQL中的条件一致
+type RulesConfig struct {
+	Over  RuleSet `json:"over"`
+	Below RuleSet `json:"below"`
+}
+
+// RuleEngine 按规则将部署组分到超过阈值、低于阈值两类
+type RuleEngine struct {
+	Over  RuleSet
+	Below RuleSet
+}
+
+// NewRuleEngine 根据配置创建规则引擎，未配置的类别使用thresholds生成的默认规则
+func NewRuleEngine(rules RulesConfig, thresholds ThresholdConfig) *RuleEngine {
+	e := &RuleEngine{Over: rules.Over, Below: rules.Below}
+	if e.Over.empty() {
+		e.Over = RuleSet{
+			Combinator: CombinatorOr,
+			Groups: []RuleSet{
+				{Rules: []Rule{
+					{Metric: MetricCpuLimit, Scope: ScopePod, Comparator: ">=", Threshold: thresholds.CpuOverPod},
+					{Metric: MetricCpuLimit, Scope: ScopeContainer, Comparator: ">=", Threshold: thresholds.CpuOverContainer},
+				}},
+				{Rules: []Rule{
+					{Metric: MetricMemLimit, Scope: ScopeContainer, Comparator: ">", Threshold: thresholds.MemOverContainer},
+				}},
+			},
+		}
+	}
+	if e.Below.empty() {
+		// 0.001用于排除没有采集到数据的部署组
+		e.Below = RuleSet{Rules: []Rule{
+			{Metric: MetricCpuLimit, Scope: ScopePod, Comparator: ">=", Threshold: 0.001},
+			{Metric: MetricCpuLimit, Scope: ScopePod, Comparator: "<", Threshold: thresholds.CpuBelowPod},
+			{Metric: MetricCpuLimit, Scope: ScopeContainer, Comparator: ">=", Threshold: 0.001},
+			{Metric: MetricCpuLimit, Scope: ScopeContainer, Comparator: "<", Threshold: thresholds.CpuBelowContainer},
+		}}
+	}
+	return e
+}
+
+// RuleEngine 返回当前配置的规则引擎
+func (c *Config) RuleEngine() *RuleEngine {
+	return NewRuleEngine(c.Rules, c.Thresholds)
+}
+
+// Classify 将部署组分为超过阈值和低于阈值两类，同时满足时只计入超过阈值
+// 超过阈值按container CPU使用率降序，低于阈值按升序，与原SQL的排序一致
+func (e *RuleEngine) Classify(groups []UsageRecord) (over, below []UsageRecord) {
+	for _, g := range groups {
+		switch e.Category(g) {
+		case CategoryOver:
+			over = append(over, g)
+		case CategoryBelow:
+			below = append(below, g)
+		}
+	}
+	sort.SliceStable(over, func(i, j int) bool { return over[i].ContainerCpuAvg > over[j].ContainerCpuAvg })
+	sort.SliceStable(below, func(i, j int) bool { return below[i].ContainerCpuAvg < below[j].ContainerCpuAvg })
+	return over, below
+}
+
+// Category 部署组的分类，同时满足时为超过阈值
+func (e *RuleEngine) Category(g UsageRecord) Category {
+	switch {
+	case e.Over.Match(g):
+		return CategoryOver
+	case e.Below.Match(g):
+		return CategoryBelow
+	}
+	return CategoryNormal
+}
+
+func (s RuleSet) empty() bool {
+	return len(s.Rules) == 0 && len(s.Groups) == 0
+}
+
+// Match 判断部署组是否满足规则组
+func (s RuleSet) Match(g UsageRecord) bool {
+	or := strings.EqualFold(s.Combinator, CombinatorOr)
+	for _, r := range s.Rules {
+		if r.Match(g) == or {
+			return or
+		}
+	}
+	for _, sub := range s.Groups {
+		if sub.Match(g) == or {
+			return or
+		}
+	}
+	return !or && !s.empty()
+}
+
+// Match 判断部署组是否满足单条规则，数据源没有所需的统计量时不满足
+func (r Rule) Match(g UsageRecord) bool {
+	value, ok := g.Value(r.Metric, r.Scope, r.Stat)
+	if !ok {
+		return false
+	}
+	if r.Metric == MetricCpuLimit || r.Metric == MetricMemLimit {
+		// 周表中的使用率为百分比，阈值为比例
+		value /= 100
+	}
+	switch r.Comparator {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	case "!=":
+		return value != r.Threshold
+	}
+	return false
+}
+
+// String 规则的可读描述，用于报告标题，如container CPU使用率 >= 60%
+func (r Rule) String() string {
+	names := map[string]string{
+		MetricCpuLimit: "CPU使用率",
+		MetricCpuCore:  "CPU使用量",
+		MetricMemLimit: "内存使用率",
+		MetricMemValue: "内存使用量",
+	}
+	threshold := strconv.FormatFloat(r.Threshold, 'f', -1, 64)
+	if r.Metric == MetricCpuLimit || r.Metric == MetricMemLimit {
+		threshold = strconv.FormatFloat(r.Threshold*100, 'f', -1, 64) + "%"
+	}
+	name := names[r.Metric]
+	if stat := map[string]string{StatP50: "P50", StatP95: "P95", StatP99: "P99", StatMax: "峰值"}[r.Stat]; stat != "" {
+		name = stat + " " + name
+	}
+	return fmt.Sprintf("%s %s %s %s", r.Scope, name, r.Comparator, threshold)
+}
+
+// String 规则组的可读描述
+func (s RuleSet) String() string {
+	sep := " 且 "
+	if strings.EqualFold(s.Combinator, CombinatorOr) {
+		sep = " 或 "
+	}
+	var parts []string
+	for _, r := range s.Rules {
+		// 排除无数据部署组的下限规则不展示
+		if r.Comparator == ">=" && r.Threshold == 0.001 {
+			continue
+		}
+		parts = append(parts, r.String())
+	}
+	for _, sub := range s.Groups {
+		text := sub.String()
+		if len(sub.Rules)+len(sub.Groups) > 1 && len(s.Rules)+len(s.Groups) > 1 {
+			text = "(" + text + ")"
+		}
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, sep)
+}
+
+// validate 校验规则组，错误通过add报告
+func (s RuleSet) validate(key string, add func(key, format string, args ...interface{})) {
+	switch strings.ToLower(s.Combinator) {
+	case "", CombinatorAnd, CombinatorOr:
+	default:
+		add(key+".combinator", "只能是and或or，当前为%q", s.Combinator)
+	}
+	for i, r := range s.Rules {
+		ruleKey := fmt.Sprintf("%s.rules[%d]", key, i)
+		switch r.Metric {
+		case MetricCpuLimit, MetricCpuCore, MetricMemLimit, MetricMemValue:
+		default:
+			add(ruleKey+".metric", "只能是cpu_limit、cpu_core、mem_limit或mem_value，当前为%q", r.Metric)
+		}
+		switch r.Scope {
+		case ScopePod, ScopeContainer:
+		default:
+			add(ruleKey+".scope", "只能是pod或container，当前为%q", r.Scope)
+		}
+		switch r.Stat {
+		case "", StatAvg, StatP50, StatP95, StatP99, StatMax:
+		default:
+			add(ruleKey+".stat", "只能是avg、p50、p95、p99或max，当前为%q", r.Stat)
+		}
+		switch r.Comparator {
+		case ">", ">=", "<", "<=", "==", "!=":
+		default:
+			add(ruleKey+".comparator", "只能是>、>=、<、<=、==或!=，当前为%q", r.Comparator)
+		}
+	}
+	for i, sub := range s.Groups {
+		sub.validate(fmt.Sprintf("%s.groups[%d]", key, i), add)
+	}
+}
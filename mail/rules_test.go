@@ -0,0 +1,129 @@
+package main
+
+import "testing"
+
+func TestRuleMatch(t *testing.T) {
+	record := UsageRecord{
+		ContainerCpuAvg:  60,
+		PodCpuAvg:        40,
+		ContainerMemAvg:  95,
+		ContainerCoreAvg: 2.5,
+		PodMemValue:      2048,
+		Stats: map[string]Stats{
+			statsKey(MetricCpuLimit, ScopeContainer): {P50: 55, P95: 88, P99: 93, Max: 97},
+		},
+	}
+	tests := []struct {
+		name string
+		rule Rule
+		want bool
+	}{
+		// 使用率为百分比，阈值为比例，等于阈值时按比较符判断
+		{"pod >= at threshold", Rule{Metric: MetricCpuLimit, Scope: ScopePod, Comparator: ">=", Threshold: 0.4}, true},
+		{"pod > at threshold", Rule{Metric: MetricCpuLimit, Scope: ScopePod, Comparator: ">", Threshold: 0.4}, false},
+		{"container >= at threshold", Rule{Metric: MetricCpuLimit, Scope: ScopeContainer, Comparator: ">=", Threshold: 0.6}, true},
+		{"container >= above value", Rule{Metric: MetricCpuLimit, Scope: ScopeContainer, Comparator: ">=", Threshold: 0.61}, false},
+		{"mem > at threshold", Rule{Metric: MetricMemLimit, Scope: ScopeContainer, Comparator: ">", Threshold: 0.95}, false},
+		{"mem <= at threshold", Rule{Metric: MetricMemLimit, Scope: ScopeContainer, Comparator: "<=", Threshold: 0.95}, true},
+		{"pod < below value", Rule{Metric: MetricCpuLimit, Scope: ScopePod, Comparator: "<", Threshold: 0.15}, false},
+		{"==", Rule{Metric: MetricCpuLimit, Scope: ScopePod, Comparator: "==", Threshold: 0.4}, true},
+		{"!=", Rule{Metric: MetricCpuLimit, Scope: ScopePod, Comparator: "!=", Threshold: 0.4}, false},
+		// cpu_core、mem_value的阈值与数据单位一致，不换算
+		{"cores", Rule{Metric: MetricCpuCore, Scope: ScopeContainer, Comparator: ">", Threshold: 2}, true},
+		{"mem value", Rule{Metric: MetricMemValue, Scope: ScopePod, Comparator: "<", Threshold: 1024}, false},
+		// 分位数
+		{"p95", Rule{Metric: MetricCpuLimit, Scope: ScopeContainer, Stat: StatP95, Comparator: ">=", Threshold: 0.88}, true},
+		{"max", Rule{Metric: MetricCpuLimit, Scope: ScopeContainer, Stat: StatMax, Comparator: ">", Threshold: 0.97}, false},
+		{"avg stat", Rule{Metric: MetricCpuLimit, Scope: ScopeContainer, Stat: StatAvg, Comparator: ">=", Threshold: 0.6}, true},
+		// 数据源没有所需的统计量或配置无效时不满足
+		{"missing stats", Rule{Metric: MetricCpuLimit, Scope: ScopePod, Stat: StatP95, Comparator: ">=", Threshold: 0}, false},
+		{"unknown metric", Rule{Metric: "disk", Scope: ScopePod, Comparator: ">=", Threshold: 0}, false},
+		{"unknown scope", Rule{Metric: MetricCpuLimit, Scope: "node", Comparator: ">=", Threshold: 0}, false},
+		{"unknown comparator", Rule{Metric: MetricCpuLimit, Scope: ScopePod, Comparator: "=>", Threshold: 0}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.Match(record); got != tt.want {
+			t.Errorf("%s: %s Match() = %v, want %v", tt.name, tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestRuleSetMatch(t *testing.T) {
+	high := Rule{Metric: MetricCpuLimit, Scope: ScopeContainer, Comparator: ">=", Threshold: 0.5}
+	low := Rule{Metric: MetricCpuLimit, Scope: ScopeContainer, Comparator: "<", Threshold: 0.1}
+	record := UsageRecord{ContainerCpuAvg: 70}
+	tests := []struct {
+		name string
+		set  RuleSet
+		want bool
+	}{
+		{"empty", RuleSet{}, false},
+		{"and all match", RuleSet{Rules: []Rule{high, high}}, true},
+		{"and one fails", RuleSet{Rules: []Rule{high, low}}, false},
+		{"or one matches", RuleSet{Combinator: "OR", Rules: []Rule{low, high}}, true},
+		{"or none match", RuleSet{Combinator: CombinatorOr, Rules: []Rule{low, low}}, false},
+		{"nested or in and", RuleSet{Rules: []Rule{high}, Groups: []RuleSet{{Combinator: CombinatorOr, Rules: []Rule{low, high}}}}, true},
+		{"nested and fails", RuleSet{Combinator: CombinatorOr, Rules: []Rule{low}, Groups: []RuleSet{{Rules: []Rule{high, low}}}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.set.Match(record); got != tt.want {
+			t.Errorf("%s: Match() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultRuleEngineThresholds(t *testing.T) {
+	engine := NewRuleEngine(RulesConfig{}, defaultConfig().Thresholds)
+	tests := []struct {
+		name   string
+		record UsageRecord
+		want   Category
+	}{
+		{"cpu over at thresholds", UsageRecord{PodCpuAvg: 40, ContainerCpuAvg: 60}, CategoryOver},
+		{"cpu over needs pod and container", UsageRecord{PodCpuAvg: 39.9, ContainerCpuAvg: 80}, CategoryNormal},
+		{"mem over is strict", UsageRecord{PodCpuAvg: 20, ContainerCpuAvg: 35, ContainerMemAvg: 95}, CategoryNormal},
+		{"mem over", UsageRecord{PodCpuAvg: 20, ContainerCpuAvg: 35, ContainerMemAvg: 95.1}, CategoryOver},
+		{"below", UsageRecord{PodCpuAvg: 8, ContainerCpuAvg: 12}, CategoryBelow},
+		{"below is strict", UsageRecord{PodCpuAvg: 15, ContainerCpuAvg: 12}, CategoryNormal},
+		{"no data is not below", UsageRecord{PodCpuAvg: 0, ContainerCpuAvg: 0}, CategoryNormal},
+		{"over wins over below", UsageRecord{PodCpuAvg: 8, ContainerCpuAvg: 12, ContainerMemAvg: 99}, CategoryOver},
+	}
+	for _, tt := range tests {
+		if got := engine.Category(tt.record); got != tt.want {
+			t.Errorf("%s: Category() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	over, below := engine.Classify([]UsageRecord{
+		{GroupName: "a", PodCpuAvg: 50, ContainerCpuAvg: 65},
+		{GroupName: "b", PodCpuAvg: 10, ContainerCpuAvg: 20},
+		{GroupName: "c", PodCpuAvg: 70, ContainerCpuAvg: 90},
+		{GroupName: "d", PodCpuAvg: 5, ContainerCpuAvg: 5},
+	})
+	if got := groupNames(over); len(got) != 2 || got[0] != "c" || got[1] != "a" {
+		t.Errorf("over = %v, want [c a]", got)
+	}
+	if got := groupNames(below); len(got) != 2 || got[0] != "d" || got[1] != "b" {
+		t.Errorf("below = %v, want [d b]", got)
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	engine := NewRuleEngine(RulesConfig{}, defaultConfig().Thresholds)
+	want := "(pod CPU使用率 >= 40% 且 container CPU使用率 >= 60%) 或 container 内存使用率 > 95%"
+	if got := engine.Over.String(); got != want {
+		t.Errorf("Over.String() = %q, want %q", got, want)
+	}
+	rule := Rule{Metric: MetricCpuLimit, Scope: ScopeContainer, Stat: StatP95, Comparator: ">=", Threshold: 0.8}
+	if got := rule.String(); got != "container P95 CPU使用率 >= 80%" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func groupNames(records []UsageRecord) []string {
+	var names []string
+	for _, r := range records {
+		names = append(names, r.GroupName)
+	}
+	return names
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata" // 容器镜像中可能没有时区数据
+
+	"github.com/robfig/cron/v3"
+)
+
+// ScheduleConfig 常驻模式下每日报告的发送计划
+type ScheduleConfig struct {
+	Cron      string `json:"cron"`       // 标准5段cron表达式，如"0 9 * * 1-5"
+	Timezone  string `json:"timezone"`   // 如Asia/Shanghai，为空时使用本机时区
+	Jitter    string `json:"jitter"`     // 在计划时间后随机延迟[0, jitter)
+	CatchUp   string `json:"catch_up"`   // 重启后补发该时长内错过的最近一次发送，为空时不补发
+	StateFile string `json:"state_file"` // 记录上次计划发送时间，用于补发
+	LockFile  string `json:"lock_file"`  // 保证同一时间只有一个实例运行
+}
+
+// Scheduler 按cron表达式定时执行Job，Job同步执行，执行期间不会开始下一次
+type Scheduler struct {
+	Job       func(ctx context.Context, scheduled time.Time)
+	schedule  cron.Schedule
+	location  *time.Location
+	jitter    time.Duration
+	catchUp   time.Duration
+	stateFile string
+	lockFile  string
+}
+
+// parseSchedule 解析cron表达式，表达式中没有CRON_TZ时使用loc
+func parseSchedule(expr string, loc *time.Location) (cron.Schedule, error) {
+	if !strings.HasPrefix(expr, "CRON_TZ=") && !strings.HasPrefix(expr, "TZ=") {
+		expr = "CRON_TZ=" + loc.String() + " " + expr
+	}
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("解析cron表达式失败: %w", err)
+	}
+	return schedule, nil
+}
+
+// location 配置的时区，为空时为本机时区
+func (c ScheduleConfig) location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("加载时区%s失败: %w", c.Timezone, err)
+	}
+	return loc, nil
+}
+
+// durations 解析jitter和catch_up，为空时为0
+func (c ScheduleConfig) durations() (jitter, catchUp time.Duration, err error) {
+	if c.Jitter != "" {
+		if jitter, err = time.ParseDuration(c.Jitter); err != nil {
+			return 0, 0, fmt.Errorf("解析jitter失败: %w", err)
+		}
+	}
+	if c.CatchUp != "" {
+		if catchUp, err = time.ParseDuration(c.CatchUp); err != nil {
+			return 0, 0, fmt.Errorf("解析catch_up失败: %w", err)
+		}
+	}
+	return jitter, catchUp, nil
+}
+
+// NewScheduler 按配置创建调度器
+func (c ScheduleConfig) NewScheduler(job func(ctx context.Context, scheduled time.Time)) (*Scheduler, error) {
+	if c.Cron == "" {
+		return nil, fmt.Errorf("未配置schedule.cron")
+	}
+	loc, err := c.location()
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := parseSchedule(c.Cron, loc)
+	if err != nil {
+		return nil, err
+	}
+	jitter, catchUp, err := c.durations()
+	if err != nil {
+		return nil, err
+	}
+	return &Scheduler{
+		Job:       job,
+		schedule:  schedule,
+		location:  loc,
+		jitter:    jitter,
+		catchUp:   catchUp,
+		stateFile: c.StateFile,
+		lockFile:  c.LockFile,
+	}, nil
+}
+
+// Run 持有锁文件并按计划执行Job，直到ctx取消
+// ctx取消时正在执行的Job不会被中断，等其结束后返回
+func (s *Scheduler) Run(ctx context.Context) error {
+	release, err := acquireLock(s.lockFile)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if scheduled, ok := s.missed(s.lastRun(), time.Now()); ok {
+		log.Printf("补发错过的报告，计划时间%s", scheduled.In(s.location).Format(time.RFC3339))
+		s.run(ctx, scheduled)
+	}
+	for ctx.Err() == nil {
+		next := s.schedule.Next(time.Now())
+		fire := next.Add(s.delay())
+		log.Printf("下次发送时间%s", fire.In(s.location).Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(fire))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+			s.run(ctx, next)
+		}
+	}
+	log.Println("调度器已停止")
+	return nil
+}
+
+// run 执行一次Job并记录计划时间，Job使用不会被取消的ctx
+func (s *Scheduler) run(ctx context.Context, scheduled time.Time) {
+	s.Job(context.WithoutCancel(ctx), scheduled)
+	if err := s.saveLastRun(scheduled); err != nil {
+		log.Println(err)
+	}
+}
+
+// delay 本次执行的随机延迟
+func (s *Scheduler) delay() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.jitter)))
+}
+
+// missed 返回last之后、now之前且在catch_up范围内的最近一次计划时间
+// 错过多次时只补发最近一次，没有上次记录时不补发
+func (s *Scheduler) missed(last, now time.Time) (time.Time, bool) {
+	if s.catchUp <= 0 || last.IsZero() {
+		return time.Time{}, false
+	}
+	if earliest := now.Add(-s.catchUp); last.Before(earliest) {
+		last = earliest
+	}
+	var scheduled time.Time
+	for t := s.schedule.Next(last); !t.IsZero() && !t.After(now); t = s.schedule.Next(t) {
+		scheduled = t
+	}
+	return scheduled, !scheduled.IsZero()
+}
+
+// lastRun 读取上次计划发送时间，没有记录或无法解析时为零值
+func (s *Scheduler) lastRun() time.Time {
+	if s.stateFile == "" {
+		return time.Time{}
+	}
+	data, err := os.ReadFile(s.stateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Println("读取调度状态失败:", err)
+		}
+		return time.Time{}
+	}
+	last, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		log.Println("调度状态格式错误:", err)
+		return time.Time{}
+	}
+	return last
+}
+
+// saveLastRun 记录计划发送时间，先写临时文件再替换，避免中途退出留下不完整的内容
+func (s *Scheduler) saveLastRun(scheduled time.Time) error {
+	if s.stateFile == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.stateFile), 0o755); err != nil {
+		return fmt.Errorf("创建调度状态目录失败: %w", err)
+	}
+	tmp := s.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(scheduled.Format(time.RFC3339)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("保存调度状态失败: %w", err)
+	}
+	if err := os.Rename(tmp, s.stateFile); err != nil {
+		return fmt.Errorf("保存调度状态失败: %w", err)
+	}
+	return nil
+}
+
+// acquireLock 创建锁文件并写入当前进程号，返回释放锁的函数
+// 锁文件中的进程已不存在时视为上次异常退出的残留，直接接管
+func acquireLock(path string) (release func(), err error) {
+	if path == "" {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("创建锁文件目录失败: %w", err)
+	}
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("写入锁文件失败: %w", err)
+			}
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("创建锁文件失败: %w", err)
+		}
+		pid := lockOwner(path)
+		if attempt > 0 || (pid > 0 && processAlive(pid)) {
+			return nil, fmt.Errorf("已有实例在运行(进程%d)，锁文件%s", pid, path)
+		}
+		log.Printf("清理残留的锁文件%s(进程%d)", path, pid)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("清理锁文件失败: %w", err)
+		}
+	}
+}
+
+// lockOwner 锁文件中的进程号，无法读取时为0
+func lockOwner(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// processAlive 进程是否存在；容器中重启后进程号可能与上次相同，当前进程不算
+func processAlive(pid int) bool {
+	if pid == os.Getpid() {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// RunDailyScheduler 常驻运行，按schedule配置发送每日报告
+// 收到SIGTERM或SIGINT后等待正在进行的发送结束再退出，再次收到信号时立即退出
+func RunDailyScheduler(transport Transport) error {
+	if GlobalConfig == nil {
+		return fmt.Errorf("未加载配置，请先调用InitConfig")
+	}
+	scheduler, err := GlobalConfig.Schedule.NewScheduler(func(ctx context.Context, scheduled time.Time) {
+		if err := DailySendEmailAt(ctx, transport, scheduled); err != nil {
+			log.Println("发送每日报告失败:", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	// 第一次信号后恢复默认处理，发送卡住时可以再次发送信号强制退出
+	context.AfterFunc(ctx, stop)
+	return scheduler.Run(ctx)
+}
+
+// validate 校验调度配置，错误通过add报告
+func (c ScheduleConfig) validate(add func(key, format string, args ...interface{})) {
+	loc, err := c.location()
+	if err != nil {
+		add("schedule.timezone", "%v", err)
+		loc = time.Local
+	}
+	if c.Cron != "" {
+		if _, err := parseSchedule(c.Cron, loc); err != nil {
+			add("schedule.cron", "%v", err)
+		}
+	}
+	for key, value := range map[string]string{"schedule.jitter": c.Jitter, "schedule.catch_up": c.CatchUp} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			add(key, "应为不小于0的时长，如5m，当前为%q", value)
+		}
+	}
+	if c.CatchUp != "" && c.StateFile == "" {
+		add("schedule.state_file", "配置catch_up时不能为空")
+	}
+}
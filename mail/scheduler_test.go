@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestScheduler 每天9点执行、补发catchUp内错过的发送的调度器，时区为UTC
+func newTestScheduler(t *testing.T, catchUp string) *Scheduler {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := ScheduleConfig{
+		Cron:      "0 9 * * *",
+		Timezone:  "UTC",
+		CatchUp:   catchUp,
+		StateFile: filepath.Join(dir, "scheduler.state"),
+		LockFile:  filepath.Join(dir, "scheduler.lock"),
+	}.NewScheduler(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSchedulerMissed(t *testing.T) {
+	day := func(d, hour int) time.Time { return time.Date(2025, 6, d, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name    string
+		catchUp string
+		last    time.Time
+		now     time.Time
+		want    time.Time // 零值为不补发
+	}{
+		{"no state", "48h", time.Time{}, day(3, 10), time.Time{}},
+		{"catch_up disabled", "", day(1, 9), day(3, 10), time.Time{}},
+		{"nothing missed", "48h", day(3, 9), day(3, 10), time.Time{}},
+		{"one missed", "48h", day(2, 9), day(3, 10), day(3, 9)},
+		{"several missed, only the latest", "72h", day(1, 9), day(4, 10), day(4, 9)},
+		{"last run older than the window", "6h", day(1, 9), day(3, 10), day(3, 9)},
+		{"missed run outside the window", "30m", day(1, 9), day(3, 10), time.Time{}},
+		{"exactly at the scheduled time", "1h", day(2, 9), day(3, 9), day(3, 9)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScheduler(t, tt.catchUp)
+			got, ok := s.missed(tt.last, tt.now)
+			if ok != !tt.want.IsZero() || !got.Equal(tt.want) {
+				t.Errorf("missed(%v, %v) = %v, %v; want %v", tt.last, tt.now, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerLastRun(t *testing.T) {
+	s := newTestScheduler(t, "48h")
+	if last := s.lastRun(); !last.IsZero() {
+		t.Errorf("lastRun() without state = %v", last)
+	}
+	scheduled := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	if err := s.saveLastRun(scheduled); err != nil {
+		t.Fatal(err)
+	}
+	if last := s.lastRun(); !last.Equal(scheduled) {
+		t.Errorf("lastRun() = %v, want %v", last, scheduled)
+	}
+	if err := os.WriteFile(s.stateFile, []byte("yesterday"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if last := s.lastRun(); !last.IsZero() {
+		t.Errorf("lastRun() with a corrupt state = %v", last)
+	}
+}
+
+func TestSchedulerRunCatchesUpOnce(t *testing.T) {
+	s := newTestScheduler(t, "168h")
+	// 上次发送在三天前，期间错过的几次只补发最近一次
+	now := time.Now()
+	if err := s.saveLastRun(now.Add(-72 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	want, ok := s.missed(s.lastRun(), now)
+	if !ok {
+		t.Fatal("expected a missed run")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var runs []time.Time
+	s.Job = func(jobCtx context.Context, scheduled time.Time) {
+		runs = append(runs, scheduled)
+		// Job使用的ctx不随调度器取消
+		cancel()
+		if jobCtx.Err() != nil {
+			t.Error("job context was canceled with the scheduler")
+		}
+	}
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || !runs[0].Equal(want) {
+		t.Errorf("runs = %v, want one catch-up at %v", runs, want)
+	}
+	if last := s.lastRun(); !last.Equal(want) {
+		t.Errorf("state after catch-up = %v, want %v", last, want)
+	}
+	if _, err := os.Stat(s.lockFile); !os.IsNotExist(err) {
+		t.Errorf("lock file left after Run: %v", err)
+	}
+}
+
+// exitedPID 返回一个已退出进程的进程号
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "scheduler.lock")
+	release, err := acquireLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := lockOwner(path); pid != os.Getpid() {
+		t.Errorf("lock owner = %d, want %d", pid, os.Getpid())
+	}
+	release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock file left after release: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"stale pid", fmt.Sprintf("%d\n", exitedPID(t)), false},
+		{"own pid after a container restart", fmt.Sprintf("%d\n", os.Getpid()), false},
+		{"unreadable pid", "not a pid\n", false},
+		{"running instance", fmt.Sprintf("%d\n", os.Getppid()), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(path)
+			release, err := acquireLock(path)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "已有实例在运行") {
+					t.Errorf("acquireLock() = %v, want an error for a running instance", err)
+				}
+				if data, _ := os.ReadFile(path); string(data) != tt.content {
+					t.Errorf("lock file was replaced: %q", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+			if pid := lockOwner(path); pid != os.Getpid() {
+				t.Errorf("lock owner = %d, want %d", pid, os.Getpid())
+			}
+		})
+	}
+
+	if release, err := acquireLock(""); err != nil {
+		t.Errorf("acquireLock(\"\") = %v", err)
+	} else {
+		release()
+	}
+}
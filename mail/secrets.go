@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// secretScheme 配置中引用敏感信息的前缀，如secret://smtp/password
+const secretScheme = "secret://"
+
+// ErrSecretNotFound 提供方中不存在该敏感信息
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider 根据路径(如smtp/password)读取敏感信息
+type SecretProvider interface {
+	GetSecret(ctx context.Context, path string) (string, error)
+}
+
+// EnvSecretProvider 从环境变量读取，smtp/password对应Prefix+SMTP_PASSWORD
+type EnvSecretProvider struct {
+	Prefix string
+}
+
+func (p *EnvSecretProvider) GetSecret(ctx context.Context, path string) (string, error) {
+	name := p.Prefix + strings.ToUpper(strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(path))
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("%w: 环境变量%s未设置", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// FileSecretProvider 从挂载目录读取，smtp/password对应Dir/smtp/password，与Kubernetes Secret挂载方式一致
+type FileSecretProvider struct {
+	Dir string
+}
+
+func (p *FileSecretProvider) GetSecret(ctx context.Context, path string) (string, error) {
+	clean := filepath.Clean("/" + path)
+	data, err := os.ReadFile(filepath.Join(p.Dir, clean))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, filepath.Join(p.Dir, clean))
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// KeystoreSecretProvider 从本地加密密钥库读取，密钥库用主密钥经scrypt派生的AES-256-GCM密钥加密
+type KeystoreSecretProvider struct {
+	Path      string
+	MasterKey []byte
+
+	once    sync.Once
+	secrets map[string]string
+	err     error
+}
+
+// keystoreFile 密钥库文件格式
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+func (p *KeystoreSecretProvider) GetSecret(ctx context.Context, path string) (string, error) {
+	p.once.Do(func() {
+		p.secrets, p.err = OpenKeystore(p.Path, p.MasterKey)
+	})
+	if p.err != nil {
+		return "", p.err
+	}
+	value, ok := p.secrets[path]
+	if !ok {
+		return "", fmt.Errorf("%w: 密钥库中没有%s", ErrSecretNotFound, path)
+	}
+	return value, nil
+}
+
+// OpenKeystore 解密密钥库，返回路径到敏感信息的映射
+func OpenKeystore(path string, masterKey []byte) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥库失败: %w", err)
+	}
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("密钥库格式错误: %w", err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("不支持的密钥库版本: %d", file.Version)
+	}
+	salt, err1 := base64.StdEncoding.DecodeString(file.Salt)
+	nonce, err2 := base64.StdEncoding.DecodeString(file.Nonce)
+	ciphertext, err3 := base64.StdEncoding.DecodeString(file.Ciphertext)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("密钥库格式错误: %w", err)
+	}
+	gcm, err := keystoreCipher(masterKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("解密密钥库失败，请检查主密钥")
+	}
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("密钥库内容格式错误: %w", err)
+	}
+	return secrets, nil
+}
+
+// SealKeystore 用主密钥加密并写入密钥库
+func SealKeystore(path string, masterKey []byte, secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	gcm, err := keystoreCipher(masterKey, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(keystoreFile{
+		Version:    1,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil)),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func keystoreCipher(masterKey, salt []byte) (cipher.AEAD, error) {
+	if len(masterKey) == 0 {
+		return nil, fmt.Errorf("未提供密钥库主密钥")
+	}
+	key, err := scrypt.Key(masterKey, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ChainSecretProvider 按顺序尝试多个提供方，返回第一个找到的结果
+type ChainSecretProvider []SecretProvider
+
+func (c ChainSecretProvider) GetSecret(ctx context.Context, path string) (string, error) {
+	var errs []error
+	for _, p := range c {
+		value, err := p.GetSecret(ctx, path)
+		if err == nil {
+			return value, nil
+		}
+		if !errors.Is(err, ErrSecretNotFound) {
+			return "", err
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return "", fmt.Errorf("%w: 未配置任何提供方", ErrSecretNotFound)
+	}
+	return "", errors.Join(errs...)
+}
+
+// resolveSecretRefs 将结构体中所有secret://引用替换为实际值，并登记到日志脱敏列表
+func resolveSecretRefs(ctx context.Context, v reflect.Value, provider SecretProvider, key string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return resolveSecretRefs(ctx, v.Elem(), provider, key)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" || !v.Field(i).CanSet() {
+				continue
+			}
+			if err := resolveSecretRefs(ctx, v.Field(i), provider, strings.TrimPrefix(key+"."+name, ".")); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(k))
+			if err := resolveSecretRefs(ctx, elem, provider, key+"."+k.String()); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, elem)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveSecretRefs(ctx, v.Index(i), provider, fmt.Sprintf("%s[%d]", key, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		ref := v.String()
+		if !strings.HasPrefix(ref, secretScheme) {
+			return nil
+		}
+		value, err := provider.GetSecret(ctx, strings.TrimPrefix(ref, secretScheme))
+		if err != nil {
+			return &ConfigError{Key: key, Msg: fmt.Sprintf("解析%s失败: %v", ref, err)}
+		}
+		RegisterSecret(value)
+		v.SetString(value)
+	}
+	return nil
+}
+
+// secretValues 已知的敏感信息，按长度从长到短排列，输出日志前会被替换
+// 较长的值先替换，避免其中包含的较短敏感信息先被替换后长值无法再匹配而部分泄露
+var secretValues = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// minSecretLength 登记到日志脱敏列表的最短长度(字节)
+const minSecretLength = 4
+
+// redactedText 敏感信息在日志中的替代文本
+const redactedText = "******"
+
+// RegisterSecret 登记敏感信息，之后的日志中将不再出现该值
+// 少于4个字节的值不登记：替换这类值会破坏日志中的正常内容，这样短的值本身也不具备保密性
+func RegisterSecret(value string) {
+	if len(value) < minSecretLength {
+		return
+	}
+	secretValues.Lock()
+	defer secretValues.Unlock()
+	i := sort.Search(len(secretValues.values), func(i int) bool {
+		v := secretValues.values[i]
+		return len(v) < len(value) || (len(v) == len(value) && v >= value)
+	})
+	if i < len(secretValues.values) && secretValues.values[i] == value {
+		return
+	}
+	secretValues.values = append(secretValues.values, "")
+	copy(secretValues.values[i+1:], secretValues.values[i:])
+	secretValues.values[i] = value
+}
+
+// Redact 按从长到短的顺序替换文本中所有已登记的敏感信息
+func Redact(text string) string {
+	secretValues.RLock()
+	defer secretValues.RUnlock()
+	for _, value := range secretValues.values {
+		text = strings.ReplaceAll(text, value, redactedText)
+	}
+	return text
+}
+
+// RedactWriter 写入前脱敏的io.Writer，用于log.SetOutput
+type RedactWriter struct {
+	W io.Writer
+}
+
+func (r *RedactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.W, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "finops.keystore")
+	secrets := map[string]string{"smtp/password": "s3cret-pass", "database/password": "db-pass"}
+	if err := SealKeystore(path, []byte("correct horse"), secrets); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("keystore mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+	if data, _ := os.ReadFile(path); bytes.Contains(data, []byte("s3cret-pass")) {
+		t.Error("keystore contains the plaintext secret")
+	}
+
+	got, err := OpenKeystore(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, secrets) {
+		t.Errorf("OpenKeystore() = %v, want %v", got, secrets)
+	}
+	if _, err := OpenKeystore(path, []byte("wrong horse")); err == nil || !strings.Contains(err.Error(), "请检查主密钥") {
+		t.Errorf("OpenKeystore() with a wrong master key: err = %v", err)
+	}
+	if _, err := OpenKeystore(path, nil); err == nil {
+		t.Error("OpenKeystore() without a master key should fail")
+	}
+}
+
+func TestSecretsSealCommand(t *testing.T) {
+	dir := t.TempDir()
+	keystore := filepath.Join(dir, "finops.keystore")
+	input := filepath.Join(dir, "secrets.json")
+	seal := func(json, masterKey string) (int, string, string) {
+		t.Helper()
+		if err := os.WriteFile(input, []byte(json), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("TEST_FINOPS_MASTER_KEY", masterKey)
+		var stdout, stderr bytes.Buffer
+		code := runCLI([]string{"secrets", "seal", "--keystore", keystore, "--in", input, "--master-key-env", "TEST_FINOPS_MASTER_KEY"}, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	if code, stdout, stderr := seal(`{"smtp/password": "first-pass"}`, "master"); code != exitOK || strings.Contains(stdout, "first-pass") {
+		t.Fatalf("seal = %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	// 已有的密钥库用同一主密钥合并
+	if code, _, stderr := seal(`{"database/password": "second-pass"}`, "master"); code != exitOK {
+		t.Fatalf("second seal = %d: %s", code, stderr)
+	}
+	got, err := OpenKeystore(keystore, []byte("master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"smtp/password": "first-pass", "database/password": "second-pass"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keystore = %v, want %v", got, want)
+	}
+
+	// 主密钥错误时不覆盖原密钥库，输出中不出现待写入的值
+	code, _, stderr := seal(`{"smtp/password": "third-pass"}`, "not-master")
+	if code != exitFailure || !strings.Contains(stderr, "请检查主密钥") || strings.Contains(stderr, "third-pass") {
+		t.Errorf("seal with a wrong master key = %d: %s", code, stderr)
+	}
+	if got, err := OpenKeystore(keystore, []byte("master")); err != nil || got["smtp/password"] != "first-pass" {
+		t.Errorf("keystore changed after a failed seal: %v, %v", got, err)
+	}
+
+	if code, _, stderr := seal(`["not", "an", "object"]`, "master"); code != exitFailure || !strings.Contains(stderr, "JSON对象") {
+		t.Errorf("seal with invalid input = %d: %s", code, stderr)
+	}
+	if code, _, _ := seal(`{}`, ""); code != exitFailure {
+		t.Errorf("seal without a master key = %d, want %d", code, exitFailure)
+	}
+}
+
+func TestRunCLIRedactsErrors(t *testing.T) {
+	RegisterSecret("cli-secret-value")
+	var stdout, stderr bytes.Buffer
+	missing := filepath.Join(t.TempDir(), "cli-secret-value.yaml")
+	if code := runCLI([]string{"report", "render", "--config", missing}, &stdout, &stderr); code != exitFailure {
+		t.Fatalf("runCLI() = %d, want %d", code, exitFailure)
+	}
+	if strings.Contains(stderr.String(), "cli-secret-value") || !strings.Contains(stderr.String(), redactedText) {
+		t.Errorf("stderr is not redacted: %s", stderr.String())
+	}
+}
+
+func TestRegisterSecretMinLength(t *testing.T) {
+	RegisterSecret("abc")
+	RegisterSecret("abcd-long-enough")
+	if got := Redact("abc abcd-long-enough"); got != "abc "+redactedText {
+		t.Errorf("Redact() = %q", got)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// SMTPError 邮件服务器返回的错误响应
+// 4xx为临时失败，稍后重试可能成功；5xx为永久失败，如收件人不存在、认证失败
+// Error只包含响应本身，出错的命令由外层错误说明
+type SMTPError struct {
+	Command  string // 出错的命令，如RCPT TO，连接时的问候为空
+	Code     int    // 响应码，如421、550
+	Enhanced string // RFC 3463增强状态码，如5.1.1，服务器未返回时为空
+	Message  string // 去掉增强状态码后的响应内容
+
+	// Rejected RCPT TO阶段被永久拒绝的收件人，此时Code、Enhanced和Message取自第一个拒绝响应
+	Rejected []string
+}
+
+func (e *SMTPError) Error() string {
+	text := fmt.Sprintf("%d", e.Code)
+	if e.Enhanced != "" {
+		text += " " + e.Enhanced
+	}
+	if e.Message != "" {
+		text += " " + e.Message
+	}
+	if len(e.Rejected) > 0 {
+		text += " (被拒绝的收件人: " + strings.Join(e.Rejected, ", ") + ")"
+	}
+	return text
+}
+
+// Temporary 是否为临时失败(4xx)
+func (e *SMTPError) Temporary() bool {
+	return e.Code >= 400 && e.Code < 500
+}
+
+// Permanent 是否为永久失败(5xx)
+func (e *SMTPError) Permanent() bool {
+	return e.Code >= 500
+}
+
+var enhancedStatusPattern = regexp.MustCompile(`^([245]\.\d{1,3}\.\d{1,3})\s+`)
+
+// smtpError 把net/smtp返回的响应错误转换为*SMTPError，其他错误原样返回
+func smtpError(command string, err error) error {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		return err
+	}
+	e := &SMTPError{Command: command, Code: protoErr.Code, Message: protoErr.Msg}
+	if m := enhancedStatusPattern.FindStringSubmatch(protoErr.Msg); m != nil {
+		e.Enhanced, e.Message = m[1], protoErr.Msg[len(m[0]):]
+	}
+	return e
+}
+
+// IsTemporary 投递错误是否为临时失败，临时失败可以重试
+// 服务器的4xx响应和连接中断、超时等网络错误为临时失败；5xx、TLS证书、配置错误以及ctx取消不是
+func IsTemporary(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var smtpErr *SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Temporary()
+	}
+	for _, transient := range []error{io.EOF, io.ErrUnexpectedEOF, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE} {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		// TLS握手时收到的告警(如客户端证书被拒绝)也以OpError返回，重试不会成功
+		return opErr.Op != "remote error"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
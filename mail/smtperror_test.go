@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSMTPErrorFromResponse(t *testing.T) {
+	err := smtpError("RCPT TO", &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"})
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) {
+		t.Fatalf("smtpError() = %T, want *SMTPError", err)
+	}
+	if smtpErr.Command != "RCPT TO" || smtpErr.Code != 550 || smtpErr.Enhanced != "5.1.1" || smtpErr.Message != "User unknown" {
+		t.Errorf("smtpError() = %+v", smtpErr)
+	}
+	if !smtpErr.Permanent() || smtpErr.Temporary() {
+		t.Errorf("550 should be permanent")
+	}
+	if got := smtpErr.Error(); got != "550 5.1.1 User unknown" {
+		t.Errorf("Error() = %q", got)
+	}
+
+	plain := smtpError("", &textproto.Error{Code: 421, Msg: "Service not available"})
+	if !errors.As(plain, &smtpErr) || smtpErr.Enhanced != "" || smtpErr.Error() != "421 Service not available" {
+		t.Errorf("smtpError() without enhanced code = %+v", plain)
+	}
+
+	other := errors.New("broken pipe")
+	if got := smtpError("DATA", other); got != other {
+		t.Errorf("smtpError() should return non-protocol errors unchanged, got %v", got)
+	}
+}
+
+func TestIsTemporary(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"4xx", &SMTPError{Code: 421}, true},
+		{"wrapped 4xx", fmt.Errorf("RCPT TO失败: %w", &SMTPError{Code: 452, Enhanced: "4.2.2"}), true},
+		{"5xx", &SMTPError{Code: 550}, false},
+		{"wrapped 5xx", fmt.Errorf("认证失败: %w", &SMTPError{Code: 535}), false},
+		{"EOF", fmt.Errorf("读取响应失败: %w", io.EOF), true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"connection refused", fmt.Errorf("连接失败: %w", syscall.ECONNREFUSED), true},
+		{"broken pipe", syscall.EPIPE, true},
+		{"dial error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")}, true},
+		{"dns temporary", &net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+		{"dns timeout", &net.DNSError{Err: "i/o timeout", IsTimeout: true}, true},
+		{"dns not found", &net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{"tls alert", &net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}, false},
+		{"certificate", errors.New("x509: certificate signed by unknown authority"), false},
+		{"config", &ConfigError{Key: "smtp.host", Msg: "不能为空"}, false},
+		{"canceled", fmt.Errorf("发送失败: %w", context.Canceled), false},
+	}
+	for _, tt := range tests {
+		if got := IsTemporary(tt.err); got != tt.want {
+			t.Errorf("%s: IsTemporary(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite"
+)
+
+// SQLTables 周平均值表名，每项依次为container表和pod表
+type SQLTables struct {
+	CpuLimit [2]string
+	MemLimit [2]string
+	CpuCore  [2]string
+	MemAvg   [2]string
+}
+
+// defaultSQLTables AUTOMATED_DB中的表名
+var defaultSQLTables = SQLTables{
+	CpuLimit: [2]string{"container_cpu_limit_week_values", "pod_cpu_limit_week_values"},
+	MemLimit: [2]string{"container_memory_limit_week_values", "pod_memory_limit_week_values"},
+	CpuCore:  [2]string{"container_cpu_core_week_values", "pod_cpu_core_week_values"},
+	MemAvg:   [2]string{"container_memory_avg_week_values", "pod_memory_avg_week_values"},
+}
+
+// sqlInChunkSize 单条IN查询的最大参数个数，避免超出数据库的占位符上限
+const sqlInChunkSize = 500
+
+// SQLMetricsSource 从AUTOMATED_DB的周平均值表读取报告数据
+// 周表每天生成一次，窗口[Start, End]的数据在End的次日写入，因此按creat_time取End次日的记录
+type SQLMetricsSource struct {
+	DB     *sql.DB
+	Tables SQLTables
+}
+
+// NewSQLMetricsSource 使用默认表名创建数据源
+func NewSQLMetricsSource(db *sql.DB) *SQLMetricsSource {
+	return &SQLMetricsSource{DB: db, Tables: defaultSQLTables}
+}
+
+// Close 关闭数据库连接
+func (s *SQLMetricsSource) Close() error {
+	return s.DB.Close()
+}
+
+// Open 按database配置连接数据库，driver为mysql或sqlite
+func (c DatabaseConfig) Open() (*sql.DB, error) {
+	if c.Driver == "" {
+		return nil, fmt.Errorf("未配置数据库")
+	}
+	db, err := sql.Open(c.Driver, c.ConnString())
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
+	}
+	return db, nil
+}
+
+// creatTimeRange 窗口对应周表记录的creat_time范围[from, to)
+func (w ReportWindow) creatTimeRange() (from, to string) {
+	day := w.End.AddDate(0, 0, 1)
+	return day.Format("2006-01-02"), day.AddDate(0, 0, 1).Format("2006-01-02")
+}
+
+// weekFilter 周表的查询条件，按creat_time和可选的部署组过滤
+func weekFilter(w ReportWindow, groupNames []string) (string, []interface{}) {
+	from, to := w.creatTimeRange()
+	where := "creat_time >= ? AND creat_time < ?"
+	args := []interface{}{from, to}
+	if len(groupNames) > 0 {
+		where += " AND group_name IN (?" + strings.Repeat(", ?", len(groupNames)-1) + ")"
+		for _, name := range groupNames {
+			args = append(args, name)
+		}
+	}
+	return where, args
+}
+
+// weekAvgQuery 查询container表和pod表中每个部署组的平均值
+// percent为true时结果换算为百分比，与原先round(avg(average*100),1)的口径一致
+func weekAvgQuery(tables [2]string, percent bool, w ReportWindow, groupNames []string) (string, []interface{}) {
+	where, args := weekFilter(w, groupNames)
+	// pod表使用相同的条件
+	args = append(args, args...)
+
+	scale, digits := "", 2
+	if percent {
+		scale, digits = " * 100", 1
+	}
+	query := fmt.Sprintf(`SELECT c.group_name, ROUND(c.avg_value%[1]s, %[2]d), ROUND(p.avg_value%[1]s, %[2]d)
+FROM (SELECT group_name, AVG(average) AS avg_value FROM %[3]s WHERE %[5]s GROUP BY group_name) c
+JOIN (SELECT group_name, AVG(average) AS avg_value FROM %[4]s WHERE %[5]s GROUP BY group_name) p ON c.group_name = p.group_name
+ORDER BY c.avg_value DESC, c.group_name`, scale, digits, tables[0], tables[1], where)
+	return query, args
+}
+
+// queryWeekAvg 执行weekAvgQuery，返回部署组、container平均值、pod平均值
+// 指定的部署组较多时分批查询，结果按container平均值降序合并
+func (s *SQLMetricsSource) queryWeekAvg(ctx context.Context, tables [2]string, percent bool, w ReportWindow, groupNames []string) ([]GroupWeekAvg, error) {
+	if len(groupNames) <= sqlInChunkSize {
+		return s.queryWeekAvgChunk(ctx, tables, percent, w, groupNames)
+	}
+	var result []GroupWeekAvg
+	for start := 0; start < len(groupNames); start += sqlInChunkSize {
+		data, err := s.queryWeekAvgChunk(ctx, tables, percent, w, groupNames[start:min(start+sqlInChunkSize, len(groupNames))])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data...)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].ContainerAvg > result[j].ContainerAvg })
+	return result, nil
+}
+
+func (s *SQLMetricsSource) queryWeekAvgChunk(ctx context.Context, tables [2]string, percent bool, w ReportWindow, groupNames []string) ([]GroupWeekAvg, error) {
+	query, args := weekAvgQuery(tables, percent, w, groupNames)
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询%s失败: %w", tables[0], err)
+	}
+	defer rows.Close()
+	var result []GroupWeekAvg
+	for rows.Next() {
+		var data GroupWeekAvg
+		if err := rows.Scan(&data.GroupName, &data.ContainerAvg, &data.PodAvg); err != nil {
+			return nil, fmt.Errorf("读取%s失败: %w", tables[0], err)
+		}
+		result = append(result, data)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("读取%s失败: %w", tables[0], err)
+	}
+
+	// 分位数数据库无法通用地计算，读取明细后在程序中计算
+	scale, digits := 1.0, 2
+	if percent {
+		scale, digits = 100, 1
+	}
+	containerStats, err := s.queryStats(ctx, tables[0], w, groupNames)
+	if err != nil {
+		return nil, err
+	}
+	podStats, err := s.queryStats(ctx, tables[1], w, groupNames)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		result[i].Container = containerStats[result[i].GroupName].scaled(scale, digits)
+		result[i].Pod = podStats[result[i].GroupName].scaled(scale, digits)
+	}
+	return result, nil
+}
+
+// queryStats 读取周表中每条记录的average并按部署组计算分位数
+// 周表中每个container(pod)一条记录，分位数反映的是部署组内各副本周平均值的分布
+func (s *SQLMetricsSource) queryStats(ctx context.Context, table string, w ReportWindow, groupNames []string) (map[string]*Stats, error) {
+	where, args := weekFilter(w, groupNames)
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf("SELECT group_name, average FROM %s WHERE %s", table, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询%s失败: %w", table, err)
+	}
+	defer rows.Close()
+	values := map[string][]float64{}
+	for rows.Next() {
+		var group string
+		var value float64
+		if err := rows.Scan(&group, &value); err != nil {
+			return nil, fmt.Errorf("读取%s失败: %w", table, err)
+		}
+		values[group] = append(values[group], value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("读取%s失败: %w", table, err)
+	}
+	stats := make(map[string]*Stats, len(values))
+	for group, v := range values {
+		stats[group] = computeStats(v)
+	}
+	return stats, nil
+}
+
+func (s *SQLMetricsSource) CpuLimitWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]GroupWeekAvg, error) {
+	return s.queryWeekAvg(ctx, s.Tables.CpuLimit, true, w, groupNames)
+}
+
+func (s *SQLMetricsSource) MemLimitWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]MemLimitWeekData, error) {
+	return s.queryWeekAvg(ctx, s.Tables.MemLimit, true, w, groupNames)
+}
+
+func (s *SQLMetricsSource) CpuCoreWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]CpuCoreWeekData, error) {
+	return s.queryWeekAvg(ctx, s.Tables.CpuCore, false, w, groupNames)
+}
+
+func (s *SQLMetricsSource) MemAvgWeekData(ctx context.Context, w ReportWindow, groupNames ...string) ([]MemAvgWeekData, error) {
+	return s.queryWeekAvg(ctx, s.Tables.MemAvg, false, w, groupNames)
+}
+
+func (s *SQLMetricsSource) AppInfos(ctx context.Context, w ReportWindow, groupNames []string) (map[string]MailAppInfo, error) {
+	from, to := w.creatTimeRange()
+	infos := make(map[string]MailAppInfo, len(groupNames))
+	for start := 0; start < len(groupNames); start += sqlInChunkSize {
+		chunk := groupNames[start:min(start+sqlInChunkSize, len(groupNames))]
+		args := []interface{}{from, to}
+		for _, name := range chunk {
+			args = append(args, name)
+		}
+		rows, err := s.DB.QueryContext(ctx, `SELECT ag.group_name, ag.application_name, c.systemname, arw.recommend
+FROM app_groups ag
+JOIN cims_systems_info c ON ag.cimsid = c.cimsid
+LEFT JOIN agent_recommend_weeks arw ON ag.group_name = arw.group_name AND arw.creat_time >= ? AND arw.creat_time < ?
+WHERE ag.group_name IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)
+ORDER BY ag.group_name, arw.creat_time DESC, arw.recommend, ag.application_name, c.systemname`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("查询应用信息失败: %w", err)
+		}
+		if err := scanAppInfos(rows, infos); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+// scanAppInfos 读取应用信息，同一部署组有多条记录时取第一条，与原先的Limit(1)一致
+// 查询按部署组和建议时间倒序排列，第一条即为窗口内最新的建议，结果不受数据库返回顺序影响
+func scanAppInfos(rows *sql.Rows, infos map[string]MailAppInfo) error {
+	defer rows.Close()
+	for rows.Next() {
+		var info MailAppInfo
+		var recommend sql.NullString
+		if err := rows.Scan(&info.GroupName, &info.ApplicationName, &info.SystemName, &recommend); err != nil {
+			return fmt.Errorf("读取应用信息失败: %w", err)
+		}
+		if _, ok := infos[info.GroupName]; ok {
+			continue
+		}
+		info.Recommend = recommend.String
+		infos[info.GroupName] = info
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("读取应用信息失败: %w", err)
+	}
+	return nil
+}
+
+// newSQLMetricsSource 按database配置连接数据库，ping失败时尽早报错
+func newSQLMetricsSource(ctx context.Context, cfg DatabaseConfig) (*SQLMetricsSource, error) {
+	db, err := cfg.Open()
+	if err != nil {
+		return nil, err
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
+	}
+	return NewSQLMetricsSource(db), nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// fixtureWindow testdata/finops_fixture.sql对应的报告窗口
+var fixtureWindow = ReportWindow{
+	Start: time.Date(2025, 5, 26, 0, 0, 0, 0, time.Local),
+	End:   time.Date(2025, 6, 1, 0, 0, 0, 0, time.Local),
+}
+
+// openFixtureSource 将样例数据导入临时的SQLite数据库
+func openFixtureSource(t *testing.T) *SQLMetricsSource {
+	t.Helper()
+	fixture, err := os.ReadFile("testdata/finops_fixture.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "finops.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(string(fixture)); err != nil {
+		t.Fatalf("导入样例数据失败: %v", err)
+	}
+	return NewSQLMetricsSource(db)
+}
+
+func TestSQLMetricsSourceCpuLimitWeekData(t *testing.T) {
+	source := openFixtureSource(t)
+	data, err := source.CpuLimitWeekData(context.Background(), fixtureWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 按container平均值降序，claims-batch上周的数据不计入
+	want := []GroupWeekAvg{
+		{GroupName: "finops-api", ContainerAvg: 72, PodAvg: 55},
+		{GroupName: "claims-web", ContainerAvg: 40, PodAvg: 25},
+		{GroupName: "finops-job", ContainerAvg: 35, PodAvg: 30},
+		{GroupName: "claims-batch", ContainerAvg: 12, PodAvg: 8},
+	}
+	if len(data) != len(want) {
+		t.Fatalf("got %d groups, want %d: %+v", len(data), len(want), data)
+	}
+	for i, w := range want {
+		got := data[i]
+		if got.GroupName != w.GroupName || got.ContainerAvg != w.ContainerAvg || got.PodAvg != w.PodAvg {
+			t.Errorf("data[%d] = %s %v/%v, want %s %v/%v", i, got.GroupName, got.ContainerAvg, got.PodAvg, w.GroupName, w.ContainerAvg, w.PodAvg)
+		}
+		if got.Container == nil || got.Container.Max != w.ContainerAvg {
+			t.Errorf("data[%d].Container = %+v, want max %v", i, got.Container, w.ContainerAvg)
+		}
+	}
+
+	filtered, err := source.CpuLimitWeekData(context.Background(), fixtureWindow, "claims-batch", "finops-job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) != 2 || filtered[0].GroupName != "finops-job" || filtered[1].GroupName != "claims-batch" {
+		t.Errorf("filtered = %+v, want finops-job, claims-batch", filtered)
+	}
+}
+
+func TestSQLMetricsSourceAppInfos(t *testing.T) {
+	source := openFixtureSource(t)
+	infos, err := source.AppInfos(context.Background(), fixtureWindow, []string{"finops-api", "finops-job", "claims-batch", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]MailAppInfo{
+		"finops-api":   {GroupName: "finops-api", ApplicationName: "finops", SystemName: "gp18ar", Recommend: "建议CPU limit调整为4核"},
+		"finops-job":   {GroupName: "finops-job", ApplicationName: "finops", SystemName: "gp18ar"},
+		"claims-batch": {GroupName: "claims-batch", ApplicationName: "claims-portal", SystemName: "claims", Recommend: "建议CPU limit调整为0.5核"},
+	}
+	if !reflect.DeepEqual(infos, want) {
+		t.Errorf("AppInfos() = %+v, want %+v", infos, want)
+	}
+}
+
+func TestBuildReportFromSQL(t *testing.T) {
+	source := openFixtureSource(t)
+	rules := NewRuleEngine(RulesConfig{}, defaultConfig().Thresholds)
+	report, err := BuildReport(context.Background(), source, rules, fixtureWindow, ReportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.StartTime != "2025-05-26" || report.EndTime != "2025-06-01" {
+		t.Errorf("window = %s ~ %s", report.StartTime, report.EndTime)
+	}
+
+	over := report.Section(CategoryOver).Records
+	if names := groupNames(over); !reflect.DeepEqual(names, []string{"finops-api", "finops-job"}) {
+		t.Fatalf("over = %v, want [finops-api finops-job]", names)
+	}
+	api := over[0]
+	if api.SystemName != "gp18ar" || api.ApplicationName != "finops" || api.Recommend != "建议CPU limit调整为4核" {
+		t.Errorf("finops-api app info = %s/%s/%q", api.SystemName, api.ApplicationName, api.Recommend)
+	}
+	if api.ContainerCpuAvg != 72 || api.ContainerMemAvg != 60 || api.ContainerCoreAvg != 2.88 || api.PodMemValue != 2600 {
+		t.Errorf("finops-api usage = %+v", api)
+	}
+
+	below := report.Section(CategoryBelow).Records
+	if names := groupNames(below); !reflect.DeepEqual(names, []string{"claims-batch"}) {
+		t.Fatalf("below = %v, want [claims-batch]", names)
+	}
+	if below[0].Recommend != "建议CPU limit调整为0.5核" {
+		t.Errorf("claims-batch recommend = %q", below[0].Recommend)
+	}
+
+	limited, err := BuildReport(context.Background(), source, rules, fixtureWindow, ReportOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := groupNames(limited.Section(CategoryOver).Records); !reflect.DeepEqual(names, []string{"finops-api"}) {
+		t.Errorf("limited over = %v, want [finops-api]", names)
+	}
+}
+
+// countingDriver 统计经过的查询数，用于检查报告生成时的查询次数
+type countingDriver struct {
+	sqlite.Driver
+	queries atomic.Int64
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, queries: &d.queries}, nil
+}
+
+type countingConn struct {
+	driver.Conn
+	queries *atomic.Int64
+}
+
+func (c *countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.queries.Add(1)
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c *countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+// queryCounter 以sqlite-counting驱动名注册
+var queryCounter = &countingDriver{}
+
+func init() {
+	sql.Register("sqlite-counting", queryCounter)
+}
+
+// writeManyGroupsFixture 在样例数据之外再写入n个低于阈值的部署组，返回数据库路径
+func writeManyGroupsFixture(t *testing.T, n int) string {
+	t.Helper()
+	fixture, err := os.ReadFile("testdata/finops_fixture.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "finops.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(fixture)); err != nil {
+		t.Fatalf("导入样例数据失败: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const day = "2025-06-02 02:00:00"
+	for i := 0; i < n; i++ {
+		group := fmt.Sprintf("idle-%04d", i)
+		if _, err := tx.Exec("INSERT INTO app_groups VALUES (?, 'idle', 'C002')", group); err != nil {
+			t.Fatal(err)
+		}
+		for _, table := range []string{"container_cpu_limit_week_values", "pod_cpu_limit_week_values", "container_memory_limit_week_values", "pod_memory_limit_week_values", "container_cpu_core_week_values", "pod_cpu_core_week_values", "container_memory_avg_week_values", "pod_memory_avg_week_values"} {
+			if _, err := tx.Exec("INSERT INTO "+table+" VALUES (?, 0.05, ?)", group, day); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
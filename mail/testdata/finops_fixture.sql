@@ -0,0 +1,90 @@
+-- 报告数据源的SQLite样例数据，对应报告窗口2025-05-26 ~ 2025-06-01(周表在2025-06-02写入)
+-- 使用方式：sqlite3 finops.db < testdata/finops_fixture.sql，然后配置
+--   database: {driver: sqlite, dsn: finops.db}
+
+CREATE TABLE app_groups (
+    group_name       TEXT NOT NULL,
+    application_name TEXT NOT NULL,
+    cimsid           TEXT NOT NULL
+);
+
+CREATE TABLE cims_systems_info (
+    cimsid     TEXT NOT NULL,
+    systemname TEXT NOT NULL
+);
+
+CREATE TABLE agent_recommend_weeks (
+    group_name TEXT NOT NULL,
+    recommend  TEXT,
+    creat_time TEXT NOT NULL
+);
+
+CREATE TABLE container_cpu_limit_week_values    (group_name TEXT NOT NULL, average REAL NOT NULL, creat_time TEXT NOT NULL);
+CREATE TABLE pod_cpu_limit_week_values          (group_name TEXT NOT NULL, average REAL NOT NULL, creat_time TEXT NOT NULL);
+CREATE TABLE container_memory_limit_week_values (group_name TEXT NOT NULL, average REAL NOT NULL, creat_time TEXT NOT NULL);
+CREATE TABLE pod_memory_limit_week_values       (group_name TEXT NOT NULL, average REAL NOT NULL, creat_time TEXT NOT NULL);
+CREATE TABLE container_cpu_core_week_values     (group_name TEXT NOT NULL, average REAL NOT NULL, creat_time TEXT NOT NULL);
+CREATE TABLE pod_cpu_core_week_values           (group_name TEXT NOT NULL, average REAL NOT NULL, creat_time TEXT NOT NULL);
+CREATE TABLE container_memory_avg_week_values   (group_name TEXT NOT NULL, average REAL NOT NULL, creat_time TEXT NOT NULL);
+CREATE TABLE pod_memory_avg_week_values         (group_name TEXT NOT NULL, average REAL NOT NULL, creat_time TEXT NOT NULL);
+
+INSERT INTO cims_systems_info VALUES
+    ('C001', 'gp18ar'),
+    ('C002', 'claims');
+
+INSERT INTO app_groups VALUES
+    ('finops-api', 'finops', 'C001'),
+    ('finops-job', 'finops', 'C001'),
+    ('claims-web', 'claims-portal', 'C002'),
+    ('claims-batch', 'claims-portal', 'C002');
+
+INSERT INTO agent_recommend_weeks VALUES
+    ('finops-api', '建议CPU limit调整为4核', '2025-06-02 03:00:00'),
+    ('claims-batch', '建议CPU limit调整为0.5核', '2025-06-02 03:00:00'),
+    ('claims-batch', '上周的建议，不应出现在报告中', '2025-05-26 03:00:00');
+
+-- finops-api：CPU超过阈值；finops-job：内存超过阈值；claims-batch：CPU低于阈值；claims-web：正常
+INSERT INTO container_cpu_limit_week_values VALUES
+    ('finops-api', 0.72, '2025-06-02 02:00:00'),
+    ('finops-job', 0.35, '2025-06-02 02:00:00'),
+    ('claims-web', 0.40, '2025-06-02 02:00:00'),
+    ('claims-batch', 0.12, '2025-06-02 02:00:00'),
+    ('claims-batch', 0.90, '2025-05-26 02:00:00');
+INSERT INTO pod_cpu_limit_week_values VALUES
+    ('finops-api', 0.55, '2025-06-02 02:00:00'),
+    ('finops-job', 0.30, '2025-06-02 02:00:00'),
+    ('claims-web', 0.25, '2025-06-02 02:00:00'),
+    ('claims-batch', 0.08, '2025-06-02 02:00:00');
+
+INSERT INTO container_memory_limit_week_values VALUES
+    ('finops-api', 0.60, '2025-06-02 02:00:00'),
+    ('finops-job', 0.97, '2025-06-02 02:00:00'),
+    ('claims-web', 0.50, '2025-06-02 02:00:00'),
+    ('claims-batch', 0.20, '2025-06-02 02:00:00');
+INSERT INTO pod_memory_limit_week_values VALUES
+    ('finops-api', 0.55, '2025-06-02 02:00:00'),
+    ('finops-job', 0.90, '2025-06-02 02:00:00'),
+    ('claims-web', 0.45, '2025-06-02 02:00:00'),
+    ('claims-batch', 0.18, '2025-06-02 02:00:00');
+
+INSERT INTO container_cpu_core_week_values VALUES
+    ('finops-api', 2.88, '2025-06-02 02:00:00'),
+    ('finops-job', 0.70, '2025-06-02 02:00:00'),
+    ('claims-web', 0.80, '2025-06-02 02:00:00'),
+    ('claims-batch', 0.24, '2025-06-02 02:00:00');
+INSERT INTO pod_cpu_core_week_values VALUES
+    ('finops-api', 3.30, '2025-06-02 02:00:00'),
+    ('finops-job', 0.75, '2025-06-02 02:00:00'),
+    ('claims-web', 1.00, '2025-06-02 02:00:00'),
+    ('claims-batch', 0.32, '2025-06-02 02:00:00');
+
+INSERT INTO container_memory_avg_week_values VALUES
+    ('finops-api', 2457.6, '2025-06-02 02:00:00'),
+    ('finops-job', 1986.6, '2025-06-02 02:00:00'),
+    ('claims-web', 1024.0, '2025-06-02 02:00:00'),
+    ('claims-batch', 409.6, '2025-06-02 02:00:00');
+INSERT INTO pod_memory_avg_week_values VALUES
+    ('finops-api', 2600.0, '2025-06-02 02:00:00'),
+    ('finops-job', 2100.0, '2025-06-02 02:00:00'),
+    ('claims-web', 1100.0, '2025-06-02 02:00:00'),
+    ('claims-batch', 450.0, '2025-06-02 02:00:00');
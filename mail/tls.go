@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSMode 与邮件服务器之间的加密方式
+type TLSMode string
+
+const (
+	TLSModeNone          TLSMode = "none"          // 不加密
+	TLSModeStartTLS      TLSMode = "starttls"      // 必须通过STARTTLS升级，服务器不支持时失败
+	TLSModeOpportunistic TLSMode = "opportunistic" // 服务器支持时使用STARTTLS
+	TLSModeImplicit      TLSMode = "tls"           // 连接建立即TLS(SMTPS，通常为465端口)
+)
+
+// tlsMode 返回实际生效的加密方式
+func (c *MailServerConfig) tlsMode() (TLSMode, error) {
+	switch c.TLSMode {
+	case "":
+		if c.SMTPPort == 465 {
+			return TLSModeImplicit, nil
+		}
+		return TLSModeOpportunistic, nil
+	case TLSModeNone, TLSModeStartTLS, TLSModeOpportunistic, TLSModeImplicit:
+		return c.TLSMode, nil
+	default:
+		return "", fmt.Errorf("不支持的TLS模式: %s", c.TLSMode)
+	}
+}
+
+// tlsConfig 根据配置构建TLS参数
+func (c *MailServerConfig) tlsConfig() (*tls.Config, error) {
+	serverName := c.TLSServerName
+	if serverName == "" {
+		serverName = c.SMTPServer
+	}
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA证书%s中没有有效的PEM证书", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if c.ClientCertFile != "" || c.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.InsecureSkipVerify && len(c.PinnedCertSHA256) == 0 {
+		// 既不校验证书链也不校验指纹时，任何中间人都能冒充邮件服务器取得凭据
+		return nil, fmt.Errorf("跳过证书校验时必须配置证书指纹")
+	}
+	if len(c.PinnedCertSHA256) > 0 {
+		pins := make([][]byte, 0, len(c.PinnedCertSHA256))
+		for _, pin := range c.PinnedCertSHA256 {
+			fingerprint, err := parseCertPin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, fingerprint)
+		}
+		cfg.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPinnedCert(state, pins)
+		}
+	}
+	return cfg, nil
+}
+
+// parseCertPin 解析十六进制的SHA-256证书指纹，允许用冒号分隔
+func parseCertPin(pin string) ([]byte, error) {
+	fingerprint, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(pin), ":", ""))
+	if err != nil || len(fingerprint) != sha256.Size {
+		return nil, fmt.Errorf("证书指纹格式错误: %s", pin)
+	}
+	return fingerprint, nil
+}
+
+// verifyPinnedCert 校验服务器证书的SHA-256指纹
+func verifyPinnedCert(state tls.ConnectionState, pins [][]byte) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("服务器未提供证书")
+	}
+	sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+	for _, pin := range pins {
+		if bytes.Equal(sum[:], pin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("服务器证书指纹%s不在信任列表中", hex.EncodeToString(sum[:]))
+}
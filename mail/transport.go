@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Transport 邮件投递方式，SMTP、本地文件、内存记录等均实现该接口
+type Transport interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// SMTPTransport 通过SMTP服务器投递邮件
+type SMTPTransport struct {
+	Server *MailServerConfig
+}
+
+// NewSMTPTransport 创建SMTP投递方式
+func NewSMTPTransport(server *MailServerConfig) *SMTPTransport {
+	return &SMTPTransport{Server: server}
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, msg *Message) error {
+	if t.Server == nil {
+		return fmt.Errorf("未配置邮件服务器")
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return fmt.Errorf("构建邮件失败: %w", err)
+	}
+	rcpts, err := msg.Recipients()
+	if err != nil {
+		return fmt.Errorf("构建邮件失败: %w", err)
+	}
+	c, closeSession, err := t.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeSession()
+
+	if err := c.Mail(msg.From.Address); err != nil {
+		return fmt.Errorf("MAIL FROM失败: %w", smtpError("MAIL FROM", err))
+	}
+	// 个别收件人被永久拒绝(5xx)时继续投递给其余收件人，最后返回列出被拒绝收件人的错误
+	// 临时拒绝(4xx)仍然中止本次投递，整体重试，避免部分收件人重复收到
+	var rejected *SMTPError
+	for _, rcpt := range rcpts {
+		if err := c.Rcpt(rcpt); err != nil {
+			err = smtpError("RCPT TO", err)
+			smtpErr, ok := err.(*SMTPError)
+			if !ok || !smtpErr.Permanent() {
+				return fmt.Errorf("RCPT TO %s失败: %w", rcpt, err)
+			}
+			if rejected == nil {
+				rejected = smtpErr
+			}
+			rejected.Rejected = append(rejected.Rejected, rcpt)
+		}
+	}
+	if rejected != nil && len(rejected.Rejected) == len(rcpts) {
+		return fmt.Errorf("所有收件人均被拒绝: %w", rejected)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("DATA失败: %w", smtpError("DATA", err))
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("写入邮件内容失败: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("提交邮件内容失败: %w", smtpError("DATA", err))
+	}
+	// 服务器已接受邮件，QUIT失败不影响投递，也不能因此重试，否则收件人会收到重复的邮件
+	c.Quit()
+	if rejected != nil {
+		return fmt.Errorf("已投递给%d个收件人，部分收件人被拒绝: %w", len(rcpts)-len(rejected.Rejected), rejected)
+	}
+	return nil
+}
+
+// open 连接邮件服务器并完成TLS和认证，返回的closeSession用于关闭会话
+func (t *SMTPTransport) open(ctx context.Context) (*smtp.Client, func(), error) {
+	mode, err := t.Server.tlsMode()
+	if err != nil {
+		return nil, nil, err
+	}
+	var tlsConfig *tls.Config
+	if mode != TLSModeNone {
+		if tlsConfig, err = t.Server.tlsConfig(); err != nil {
+			return nil, nil, err
+		}
+	}
+	host := t.Server.SMTPServer
+	addr := net.JoinHostPort(host, strconv.Itoa(t.Server.SMTPPort))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("连接邮件服务器%s失败: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// ctx取消时关闭连接，打断阻塞中的读写
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	if mode == TLSModeImplicit {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			stop()
+			conn.Close()
+			return nil, nil, fmt.Errorf("TLS握手失败: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, nil, fmt.Errorf("建立SMTP会话失败: %w", smtpError("", err))
+	}
+	closeSession := func() {
+		c.Close()
+		stop()
+	}
+	if err := t.handshake(ctx, c, mode, tlsConfig, addr); err != nil {
+		closeSession()
+		return nil, nil, err
+	}
+	return c, closeSession, nil
+}
+
+// handshake 按TLS模式升级连接并认证
+func (t *SMTPTransport) handshake(ctx context.Context, c *smtp.Client, mode TLSMode, tlsConfig *tls.Config, addr string) error {
+	encrypted := mode == TLSModeImplicit
+	if mode == TLSModeStartTLS || mode == TLSModeOpportunistic {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("STARTTLS失败: %w", smtpError("STARTTLS", err))
+			}
+			encrypted = true
+		} else if mode == TLSModeStartTLS {
+			return fmt.Errorf("邮件服务器%s不支持STARTTLS", addr)
+		}
+	}
+	if t.Server.User != "" {
+		if !encrypted && !t.Server.AllowInsecureAuth {
+			return fmt.Errorf("连接未加密，拒绝向%s发送凭据", addr)
+		}
+		ok, mechanisms := c.Extension("AUTH")
+		if !ok {
+			return fmt.Errorf("邮件服务器不支持AUTH")
+		}
+		auth, err := t.Server.negotiateAuth(ctx, mechanisms)
+		if err != nil {
+			return err
+		}
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP认证失败: %w", smtpError("AUTH", err))
+		}
+	}
+	return nil
+}
+
+// Check 连接邮件服务器并完成TLS和认证，不发送邮件，用于检查SMTP配置
+func (t *SMTPTransport) Check(ctx context.Context) error {
+	if t.Server == nil {
+		return fmt.Errorf("未配置邮件服务器")
+	}
+	c, closeSession, err := t.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeSession()
+	return c.Quit()
+}
+
+// FileTransport 将邮件写入本地目录而不真正发送，用于离线预览报告
+// Maildir为true时按Maildir格式写入Dir/new，否则直接写成Dir下的.eml文件
+type FileTransport struct {
+	Dir     string
+	Maildir bool
+}
+
+var fileTransportSeq uint64
+
+func (t *FileTransport) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return fmt.Errorf("构建邮件失败: %w", err)
+	}
+	now := time.Now()
+	seq := atomic.AddUint64(&fileTransportSeq, 1)
+
+	if !t.Maildir {
+		if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+			return fmt.Errorf("创建目录失败: %w", err)
+		}
+		name := fmt.Sprintf("%s_%d.eml", now.Format("20060102150405.000000"), seq)
+		if err := os.WriteFile(filepath.Join(t.Dir, name), data, 0o644); err != nil {
+			return fmt.Errorf("写入邮件文件失败: %w", err)
+		}
+		return nil
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(t.Dir, sub), 0o755); err != nil {
+			return fmt.Errorf("创建Maildir目录失败: %w", err)
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	// Maildir约定：先写入tmp，再原子地移动到new
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), seq, hostname)
+	tmpPath := filepath.Join(t.Dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("写入邮件文件失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(t.Dir, "new", name)); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("移动邮件文件失败: %w", err)
+	}
+	return nil
+}
+
+// MemoryTransport 将邮件记录在内存中，用于离线验证整个发送流程
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func (t *MemoryTransport) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// 记录副本，调用方之后修改或复用msg不影响已记录的邮件
+	cp := msg.clone()
+	t.mu.Lock()
+	t.messages = append(t.messages, cp)
+	t.mu.Unlock()
+	return nil
+}
+
+// Messages 返回已记录的邮件
+func (t *MemoryTransport) Messages() []*Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Message(nil), t.messages...)
+}
+
+// Reset 清空已记录的邮件
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	t.messages = nil
+	t.mu.Unlock()
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCert 测试用的自签名证书，对localhost和127.0.0.1有效
+type testCert struct {
+	cert   tls.Certificate
+	caFile string // PEM格式的证书文件，作为客户端的CA
+	pin    string // 证书的SHA-256指纹
+}
+
+func newTestCert(t *testing.T) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(der)
+	return testCert{
+		cert:   tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		caFile: caFile,
+		pin:    hex.EncodeToString(sum[:]),
+	}
+}
+
+// smtpSession 测试服务器记录的一次会话
+type smtpSession struct {
+	TLS   bool
+	Auth  []string // AUTH命令及之后客户端发送的各行
+	From  string
+	Rcpts []string
+	Data  string
+}
+
+// smtpTestServer 进程内的SMTP服务器
+type smtpTestServer struct {
+	Implicit   bool            // 连接建立即TLS
+	StartTLS   bool            // EHLO中通告STARTTLS
+	AuthMechs  string          // EHLO中通告的AUTH机制，为空时不通告
+	AuthPrompt []string        // AUTH LOGIN时依次发送的提示语，为空时使用Username:、Password:
+	RejectRcpt map[string]bool // RCPT阶段以550拒绝的地址
+
+	cert     tls.Certificate
+	listener net.Listener
+	active   sync.WaitGroup
+	mu       sync.Mutex
+	sessions []*smtpSession
+}
+
+// start 在127.0.0.1的随机端口上启动服务器，测试结束时关闭
+func (s *smtpTestServer) start(t *testing.T, cert testCert) {
+	t.Helper()
+	s.cert = cert.cert
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.listener = l
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.active.Add(1)
+			go s.serve(conn)
+		}
+	}()
+}
+
+// mailServer 连接该服务器的客户端配置，使用cert作为CA
+func (s *smtpTestServer) mailServer(cert testCert, mode TLSMode) *MailServerConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return &MailServerConfig{
+		SMTPServer:    "127.0.0.1",
+		SMTPPort:      addr.Port,
+		User:          "rpa@example.com",
+		Password:      "s3cret",
+		TLSMode:       mode,
+		TLSServerName: "localhost",
+		CAFile:        cert.caFile,
+	}
+}
+
+// Sessions 等待进行中的会话结束，返回所有会话
+func (s *smtpTestServer) Sessions() []*smtpSession {
+	s.active.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*smtpSession(nil), s.sessions...)
+}
+
+func (s *smtpTestServer) serve(conn net.Conn) {
+	session := &smtpSession{}
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		s.sessions = append(s.sessions, session)
+		s.mu.Unlock()
+		s.active.Done()
+	}()
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{s.cert}}
+	if s.Implicit {
+		conn = tls.Server(conn, tlsConfig)
+		session.TLS = true
+	}
+	text := textproto.NewConn(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			text.PrintfLine("%s", line)
+		}
+	}
+	reply("220 localhost ESMTP test")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"250-localhost"}
+			if s.StartTLS && !session.TLS {
+				lines = append(lines, "250-STARTTLS")
+			}
+			if s.AuthMechs != "" {
+				lines = append(lines, "250-AUTH "+s.AuthMechs)
+			}
+			reply(append(lines, "250 8BITMIME")...)
+		case "STARTTLS":
+			if !s.StartTLS || session.TLS {
+				reply("502 5.5.1 not supported")
+				continue
+			}
+			reply("220 2.0.0 ready to start TLS")
+			tlsConn := tls.Server(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			session.TLS = true
+		case "AUTH":
+			session.Auth = append(session.Auth, line)
+			mech, _, _ := strings.Cut(arg, " ")
+			if strings.EqualFold(mech, AuthLogin) {
+				prompts := s.AuthPrompt
+				if len(prompts) == 0 {
+					prompts = []string{"Username:", "Password:"}
+				}
+				for _, prompt := range prompts {
+					reply("334 " + base64.StdEncoding.EncodeToString([]byte(prompt)))
+					answer, err := text.ReadLine()
+					if err != nil {
+						return
+					}
+					session.Auth = append(session.Auth, answer)
+				}
+			}
+			reply("235 2.7.0 authenticated")
+		case "MAIL":
+			session.From = arg
+			reply("250 2.1.0 ok")
+		case "RCPT":
+			_, addr, _ := strings.Cut(arg, ":")
+			addr = strings.Trim(addr, "<>")
+			if s.RejectRcpt[addr] {
+				reply("550 5.1.1 <" + addr + ">: user unknown")
+				continue
+			}
+			session.Rcpts = append(session.Rcpts, addr)
+			reply("250 2.1.5 ok")
+		case "DATA":
+			reply("354 end with <CRLF>.<CRLF>")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			session.Data = string(data)
+			reply("250 2.0.0 queued")
+		case "RSET", "NOOP":
+			reply("250 2.0.0 ok")
+		case "QUIT":
+			reply("221 2.0.0 bye")
+			return
+		default:
+			reply("502 5.5.2 unknown command")
+		}
+	}
+}
+
+func testMessage() *Message {
+	return &Message{From: mail.Address{Address: "rpa@example.com"}, To: []string{"ops@example.com"}, Subject: "报告", Text: "hi"}
+}
+
+func TestSMTPTransportTLSModes(t *testing.T) {
+	cert := newTestCert(t)
+	tests := []struct {
+		name    string
+		server  *smtpTestServer
+		mode    TLSMode
+		wantTLS bool
+	}{
+		{"implicit", &smtpTestServer{Implicit: true, AuthMechs: "PLAIN LOGIN"}, TLSModeImplicit, true},
+		{"starttls", &smtpTestServer{StartTLS: true, AuthMechs: "PLAIN LOGIN"}, TLSModeStartTLS, true},
+		{"opportunistic with STARTTLS", &smtpTestServer{StartTLS: true, AuthMechs: "PLAIN LOGIN"}, TLSModeOpportunistic, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.server
+			server.start(t, cert)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := NewSMTPTransport(server.mailServer(cert, tt.mode)).Send(ctx, testMessage()); err != nil {
+				t.Fatal(err)
+			}
+			sessions := server.Sessions()
+			if len(sessions) != 1 {
+				t.Fatalf("got %d sessions, want 1", len(sessions))
+			}
+			session := sessions[0]
+			if session.TLS != tt.wantTLS {
+				t.Errorf("session TLS = %v, want %v", session.TLS, tt.wantTLS)
+			}
+			if len(session.Auth) == 0 || !strings.HasPrefix(session.Auth[0], "AUTH PLAIN ") {
+				t.Errorf("Auth = %q, want AUTH PLAIN", session.Auth)
+			}
+			if strings.Join(session.Rcpts, ",") != "ops@example.com" || !strings.Contains(session.Data, "Subject: ") {
+				t.Errorf("session = %+v", session)
+			}
+		})
+	}
+}
+
+func TestSMTPTransportOpportunisticWithoutStartTLS(t *testing.T) {
+	// 服务器不支持STARTTLS时机会性加密退回明文，不发送凭据时可以投递
+	cert := newTestCert(t)
+	server := smtpTestServer{}
+	server.start(t, cert)
+	config := server.mailServer(cert, TLSModeOpportunistic)
+	config.User = ""
+	if err := NewSMTPTransport(config).Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if sessions := server.Sessions(); len(sessions) != 1 || sessions[0].TLS || len(sessions[0].Rcpts) != 1 {
+		t.Errorf("sessions = %+v", sessions)
+	}
+}
+
+func TestSMTPTransportStartTLSRequired(t *testing.T) {
+	cert := newTestCert(t)
+	server := smtpTestServer{AuthMechs: "PLAIN"}
+	server.start(t, cert)
+	err := NewSMTPTransport(server.mailServer(cert, TLSModeStartTLS)).Send(context.Background(), testMessage())
+	if err == nil || !strings.Contains(err.Error(), "不支持STARTTLS") {
+		t.Fatalf("Send() = %v, want a STARTTLS error", err)
+	}
+	if sessions := server.Sessions(); len(sessions) != 1 || len(sessions[0].Auth) > 0 || sessions[0].From != "" {
+		t.Errorf("session continued after the STARTTLS failure: %+v", sessions[0])
+	}
+}
+
+func TestSMTPTransportRefusesPlaintextAuth(t *testing.T) {
+	cert := newTestCert(t)
+	for _, mode := range []TLSMode{TLSModeNone, TLSModeOpportunistic} {
+		server := smtpTestServer{AuthMechs: "PLAIN LOGIN"}
+		server.start(t, cert)
+		err := NewSMTPTransport(server.mailServer(cert, mode)).Send(context.Background(), testMessage())
+		if err == nil || !strings.Contains(err.Error(), "拒绝") {
+			t.Errorf("%s: Send() = %v, want a refusal to send credentials", mode, err)
+		}
+		if sessions := server.Sessions(); len(sessions) != 1 || len(sessions[0].Auth) > 0 {
+			t.Errorf("%s: credentials were sent over plaintext: %+v", mode, sessions[0].Auth)
+		}
+	}
+
+	// allow_insecure_auth明确允许时才在明文连接上认证
+	server := smtpTestServer{AuthMechs: "PLAIN"}
+	server.start(t, cert)
+	config := server.mailServer(cert, TLSModeNone)
+	config.AllowInsecureAuth = true
+	if err := NewSMTPTransport(config).Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if sessions := server.Sessions(); len(sessions[0].Auth) != 1 {
+		t.Errorf("Auth = %q, want one AUTH PLAIN", sessions[0].Auth)
+	}
+}
+
+func TestSMTPTransportCertPins(t *testing.T) {
+	cert := newTestCert(t)
+	other := newTestCert(t)
+	tests := []struct {
+		name    string
+		setup   func(*MailServerConfig)
+		wantErr string
+	}{
+		{"matching pin", func(c *MailServerConfig) { c.PinnedCertSHA256 = []string{other.pin, cert.pin} }, ""},
+		{"pin mismatch", func(c *MailServerConfig) { c.PinnedCertSHA256 = []string{other.pin} }, "不在信任列表中"},
+		{"pin without chain", func(c *MailServerConfig) {
+			c.CAFile, c.InsecureSkipVerify, c.PinnedCertSHA256 = "", true, []string{cert.pin}
+		}, ""},
+		{"pin mismatch without chain", func(c *MailServerConfig) {
+			c.CAFile, c.InsecureSkipVerify, c.PinnedCertSHA256 = "", true, []string{other.pin}
+		}, "不在信任列表中"},
+		{"skip verify without pins", func(c *MailServerConfig) { c.CAFile, c.InsecureSkipVerify = "", true }, "必须配置证书指纹"},
+		{"unknown CA", func(c *MailServerConfig) { c.CAFile = other.caFile }, "TLS握手失败"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := smtpTestServer{Implicit: true, AuthMechs: "PLAIN"}
+			server.start(t, cert)
+			config := server.mailServer(cert, TLSModeImplicit)
+			tt.setup(config)
+			err := NewSMTPTransport(config).Send(context.Background(), testMessage())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Send() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			for _, session := range server.Sessions() {
+				if len(session.Auth) > 0 {
+					t.Errorf("credentials were sent to an untrusted server: %q", session.Auth)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateInsecureSkipVerify(t *testing.T) {
+	cfg := testConfig()
+	cfg.SMTP.InsecureSkipVerify = true
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "smtp.insecure_skip_verify") {
+		t.Errorf("Validate() = %v, want an insecure_skip_verify error", err)
+	}
+	cfg.SMTP.PinnedCertSHA256 = []string{"not-a-pin"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "smtp.pinned_cert_sha256[0]") {
+		t.Errorf("Validate() = %v, want a pinned_cert_sha256 error", err)
+	}
+	cfg.SMTP.PinnedCertSHA256 = []string{strings.Repeat("ab:", 31) + "ab"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() with a pin = %v", err)
+	}
+}
+
+func TestFileTransportEML(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	transport := &FileTransport{Dir: dir}
+	for i := 0; i < 2; i++ {
+		if err := transport.Send(context.Background(), testMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("got %v (%v), want two .eml files", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to, err := parsed.Header.AddressList("To"); err != nil || len(to) != 1 || to[0].Address != "ops@example.com" || parsed.Header.Get("Message-Id") == "" {
+		t.Errorf("header = %v", parsed.Header)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := transport.Send(ctx, testMessage()); err == nil {
+		t.Error("Send() with a canceled context should fail")
+	}
+}
+
+func TestFileTransportMaildir(t *testing.T) {
+	dir := t.TempDir()
+	transport := &FileTransport{Dir: dir, Maildir: true}
+	if err := transport.Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	count := func(sub string) int {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+	// 写入tmp后移动到new，tmp中不留下文件
+	if tmp, cur, n := count("tmp"), count("cur"), count("new"); tmp != 0 || cur != 0 || n != 1 {
+		t.Errorf("tmp/new/cur = %d/%d/%d, want 0/1/0", tmp, n, cur)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "new"))
+	if name := entries[0].Name(); strings.Contains(name, "/") || strings.Contains(name, ":") {
+		t.Errorf("Maildir file name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "new", entries[0].Name()))
+	if err != nil || !strings.Contains(string(data), "\r\n\r\nhi") {
+		t.Errorf("message = %q, %v", data, err)
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	var transport MemoryTransport
+	msg := testMessage()
+	msg.Cc = []string{"lead@example.com"}
+	msg.Headers = map[string]string{"X-Report": "daily"}
+	msg.Attach("report.xlsx", []byte("xlsx"), "")
+	msg.EmbedImage("logo", "logo.png", pngHeader)
+	if err := transport.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// 记录的是副本，之后修改原邮件不影响记录
+	msg.To[0] = "changed@example.com"
+	msg.Cc = append(msg.Cc[:0], "changed@example.com")
+	msg.Headers["X-Report"] = "changed"
+	msg.Attachments[0].Filename = "changed.xlsx"
+	msg.Attachments[0].Data[0] = 'X'
+	msg.Inline[0].ContentID = "changed"
+	msg.Subject = "changed"
+
+	messages := transport.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	got := messages[0]
+	if got.To[0] != "ops@example.com" || got.Cc[0] != "lead@example.com" || got.Headers["X-Report"] != "daily" || got.Subject != "报告" {
+		t.Errorf("recorded message changed with the original: %+v", got)
+	}
+	if got.Attachments[0].Filename != "report.xlsx" || string(got.Attachments[0].Data) != "xlsx" || got.Inline[0].ContentID != "logo" {
+		t.Errorf("recorded attachments changed with the original: %+v, %+v", got.Attachments, got.Inline)
+	}
+
+	transport.Reset()
+	if len(transport.Messages()) != 0 {
+		t.Error("Reset() did not clear the messages")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := transport.Send(ctx, testMessage()); err == nil || len(transport.Messages()) != 0 {
+		t.Errorf("Send() with a canceled context = %v", err)
+	}
+}
+
+func TestSMTPTransportPartialRecipientRejection(t *testing.T) {
+	cert := newTestCert(t)
+	server := smtpTestServer{RejectRcpt: map[string]bool{"gone@example.com": true, "left@example.com": true}}
+	server.start(t, cert)
+	config := server.mailServer(cert, TLSModeNone)
+	config.User = ""
+
+	msg := testMessage()
+	msg.To = []string{"ops@example.com", "gone@example.com"}
+	msg.Cc = []string{"Left <left@example.com>", "lead@example.com"}
+	inner := NewSMTPTransport(config)
+	err := (&RetryTransport{Transport: inner, Policy: fastPolicy}).Send(context.Background(), msg)
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) {
+		t.Fatalf("Send() = %v, want an *SMTPError", err)
+	}
+	if !smtpErr.Permanent() || IsTemporary(err) || smtpErr.Code != 550 || smtpErr.Enhanced != "5.1.1" || smtpErr.Command != "RCPT TO" {
+		t.Errorf("error = %+v, want a permanent 550 at RCPT TO", smtpErr)
+	}
+	if strings.Join(smtpErr.Rejected, ",") != "gone@example.com,left@example.com" {
+		t.Errorf("Rejected = %v", smtpErr.Rejected)
+	}
+	if !strings.Contains(err.Error(), "已投递给2个收件人") || !strings.Contains(err.Error(), "gone@example.com, left@example.com") {
+		t.Errorf("error message = %q", err)
+	}
+	// 其余收件人照常投递，且永久失败不重试
+	sessions := server.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1 (no retry)", len(sessions))
+	}
+	if strings.Join(sessions[0].Rcpts, ",") != "ops@example.com,lead@example.com" || sessions[0].Data == "" {
+		t.Errorf("session = %+v, want the message delivered to the accepted recipients", sessions[0])
+	}
+}
+
+func TestSMTPTransportAllRecipientsRejected(t *testing.T) {
+	cert := newTestCert(t)
+	server := smtpTestServer{RejectRcpt: map[string]bool{"ops@example.com": true, "gone@example.com": true}}
+	server.start(t, cert)
+	config := server.mailServer(cert, TLSModeNone)
+	config.User = ""
+
+	msg := testMessage()
+	msg.To = []string{"ops@example.com", "gone@example.com"}
+	err := NewSMTPTransport(config).Send(context.Background(), msg)
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || len(smtpErr.Rejected) != 2 || !strings.Contains(err.Error(), "所有收件人均被拒绝") {
+		t.Fatalf("Send() = %v", err)
+	}
+	if sessions := server.Sessions(); sessions[0].Data != "" {
+		t.Errorf("DATA was sent with no accepted recipients")
+	}
+}